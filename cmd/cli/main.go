@@ -40,8 +40,7 @@ func main() {
 
 	// Execute the root command
 	if err := cmd.RootCmd.Execute(); err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+		os.Exit(cmd.HandleError(err))
 	}
 }
 
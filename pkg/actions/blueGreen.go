@@ -0,0 +1,149 @@
+package actions
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/zulubit/mole/pkg/helpers"
+)
+
+const (
+	// StrategyInPlace restarts the project in place on every deployment.
+	StrategyInPlace = "inplace"
+	// StrategyBlueGreen starts every release next to the running one and switches traffic once it is healthy.
+	StrategyBlueGreen = "bluegreen"
+)
+
+const (
+	slotBlue  = "blue"
+	slotGreen = "green"
+)
+
+// stopSlot tears down the stack a project runs in the given slot.
+var stopSlot = func(p Project, slot string, out io.Writer) error {
+	return runCompose(p, nil, composeArgs(p, slot, "down"), ComposeIO{Stdout: out, Stderr: out})
+}
+
+// SetDeployStrategy changes how a project is deployed, either "inplace" or "bluegreen".
+// Switching strategies forgets the active slot, the next deployment starts from the app port again.
+func SetDeployStrategy(projectNOI, strategy string) error {
+	if strategy != StrategyInPlace && strategy != StrategyBlueGreen {
+		return fmt.Errorf("unknown deploy strategy %s, use %s or %s", strategy, StrategyInPlace, StrategyBlueGreen)
+	}
+
+	return editProject(projectNOI, func(p *Project) {
+		if p.Strategy == strategy || (p.Strategy == "" && strategy == StrategyInPlace) {
+			return
+		}
+		p.Strategy = strategy
+		p.ActiveSlot = ""
+	})
+}
+
+// nextSlot returns the slot the next release of a project is started in.
+// A project that was never deployed blue/green runs in place, on the port of the blue slot.
+func nextSlot(active string) string {
+	if active == slotGreen {
+		return slotBlue
+	}
+	return slotGreen
+}
+
+// slotPort returns the port the project listens on in the given slot.
+// The blue slot uses PortApp, the green slot PortTwo.
+func slotPort(secrets *projectSecrets, slot string) int {
+	if slot == slotGreen {
+		return secrets.PortTwo
+	}
+	return secrets.PortApp
+}
+
+// activeSlotPort returns the port the live release of a blue/green project listens on, 0 if it is unknown.
+func activeSlotPort(p Project) int {
+	secrets, err := readProjectSecrets(p.Name)
+	if err != nil {
+		return 0
+	}
+	return slotPort(secrets, p.ActiveSlot)
+}
+
+// composeProjectName returns the Docker Compose project name the stack of a slot runs under.
+// The stack of a project deployed in place runs under the project name, which is also the default name compose
+// derives from the project directory, so stacks started by mole.sh without a name are found as well.
+func composeProjectName(p Project, slot string) string {
+	if slot == "" {
+		return p.Name
+	}
+	return p.Name + "-" + slot
+}
+
+// slotEnv returns the environment telling the deployment scripts which slot they deploy to.
+func slotEnv(p Project, slot string, port int) []string {
+	return []string{
+		"MOLE_SLOT=" + slot,
+		"MOLE_SLOT_PORT=" + strconv.Itoa(port),
+		"COMPOSE_PROJECT_NAME=" + composeProjectName(p, slot),
+	}
+}
+
+// switchSlot saves the new slot as the active one, renders the project's domains to point at its port, updates the
+// Caddy route of the project and stops the stack of the old slot. When Caddy can not be updated, the active slot
+// and the partial are rolled back and traffic stays on the old slot.
+func switchSlot(p Project, slot string, secrets *projectSecrets, out io.Writer) error {
+	to := slotPort(secrets, slot)
+
+	partialPath := getDomainPartialPath(p.Name)
+	previous, err := os.ReadFile(partialPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to read domain configuration %s: %w", partialPath, err)
+	}
+
+	rollback := func() {
+		editProject(p.Name, func(pro *Project) { pro.ActiveSlot = p.ActiveSlot })
+		if previous == nil {
+			os.Remove(partialPath)
+		} else {
+			helpers.WriteFileAtomic(partialPath, previous, 0644)
+		}
+	}
+
+	switched, err := updateProjectDomains(p.Name, func(pro *Project, _ []Project) error {
+		pro.ActiveSlot = slot
+		// a partial mole does not keep the domains of is left alone
+		if len(pro.Domains) == 0 {
+			return nil
+		}
+		return renderProjectDomains(*pro)
+	})
+	if err != nil {
+		rollback()
+		return fmt.Errorf("failed to switch the domains, traffic stays on the %s stack: %w", slotName(p.ActiveSlot), err)
+	}
+
+	if len(switched.Domains) == 0 {
+		fmt.Fprintf(out, "%s has no domains, skipping the proxy switch\n", p.Name)
+	} else if err := syncDomainRoute(p.Name); err != nil {
+		rollback()
+		return fmt.Errorf("failed to update the caddy route, traffic stays on the %s stack: %w", slotName(p.ActiveSlot), err)
+	}
+
+	fmt.Fprintf(out, "Traffic switched to the %s slot on port %d\n", slot, to)
+
+	fmt.Fprintf(out, "Stopping the %s stack...\n", slotName(p.ActiveSlot))
+	if err := stopSlot(p, p.ActiveSlot, out); err != nil {
+		fmt.Fprintf(out, "Failed to stop the %s stack: %v\n", slotName(p.ActiveSlot), err)
+	}
+
+	return nil
+}
+
+// slotName names a slot for deploy output, the stack deployed in place has no slot.
+func slotName(slot string) string {
+	if slot == "" {
+		return "in-place"
+	}
+	return slot
+}
@@ -0,0 +1,155 @@
+package actions
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zulubit/mole/pkg/consts"
+)
+
+func TestBlueGreenDeployment(t *testing.T) {
+	consts.Testing = true
+
+	tmp := t.TempDir()
+	consts.BasePath = tmp
+
+	projectName := "test-project"
+	np := Project{
+		Name: projectName,
+	}
+	addProject(np)
+
+	err := createProjectSecretsJson(np)
+	assert.Nil(t, err, "Failed to setup project secrets")
+
+	err = SetDeployStrategy(projectName, "canary")
+	assert.ErrorContains(t, err, "unknown deploy strategy", "only known strategies can be set")
+
+	err = SetDeployStrategy(projectName, StrategyBlueGreen)
+	assert.Nil(t, err, "strategy can be set")
+
+	// each slot port is served by its own app, green can be made unhealthy
+	var greenHealthy atomic.Bool
+	greenHealthy.Store(true)
+	blue := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer blue.Close()
+	green := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !greenHealthy.Load() {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer green.Close()
+
+	port := func(s *httptest.Server) int {
+		u, _ := url.Parse(s.URL)
+		p, _ := strconv.Atoi(u.Port())
+		return p
+	}
+
+	secrets, err := readProjectSecrets(projectName)
+	assert.Nil(t, err, "secrets can be read")
+	secrets.PortApp = port(blue)
+	secrets.PortTwo = port(green)
+	err = saveProjectSecrets(projectName, secrets)
+	assert.Nil(t, err, "slot ports point at the test servers")
+
+	hc, _ := NewHealthCheck("/", 200, 2, "10ms", false)
+	SetHealthCheck(projectName, hc)
+
+	var stopped []string
+	originalStopSlot := stopSlot
+	defer func() { stopSlot = originalStopSlot }()
+	stopSlot = func(p Project, slot string, out io.Writer) error {
+		stopped = append(stopped, composeProjectName(p, slot))
+		return nil
+	}
+
+	projectDir := path.Join(tmp, "projects", projectName)
+	os.MkdirAll(projectDir, 0755)
+	err = os.WriteFile(path.Join(projectDir, "mole.sh"), []byte("#!/bin/bash\necho \"$MOLE_SLOT $MOLE_SLOT_PORT $COMPOSE_PROJECT_NAME\""), 0755)
+	assert.Nil(t, err, "mole.sh written")
+
+	err = AddDomainProxy(projectName, "test.com", 0)
+	assert.Nil(t, err, "domain proxies to the live slot")
+
+	partial := func() string {
+		d, _ := os.ReadFile(path.Join(tmp, "domains", projectName+".caddy"))
+		return string(d)
+	}
+	assert.Contains(t, partial(), "127.0.0.1:"+strconv.Itoa(port(blue)), "project runs in place on the app port")
+
+	var output bytes.Buffer
+	_, err = RunDeployment(projectName, DeployOptions{Output: &output})
+	assert.Nil(t, err, "first blue/green deployment succeeds")
+	assert.Contains(t, output.String(), "green "+strconv.Itoa(port(green))+" test-project-green", "release is started in the green slot")
+	assert.Contains(t, partial(), "127.0.0.1:"+strconv.Itoa(port(green)), "traffic is switched to the green slot")
+	assert.Equal(t, []string{"test-project"}, stopped, "stack deployed in place is stopped")
+
+	p, _ := FindProject(projectName)
+	assert.Equal(t, "green", p.ActiveSlot, "active slot is saved")
+
+	_, err = RunDeployment(projectName, DeployOptions{})
+	assert.Nil(t, err, "second blue/green deployment succeeds")
+	assert.Contains(t, partial(), "127.0.0.1:"+strconv.Itoa(port(blue)), "traffic is switched back to the blue slot")
+	assert.Equal(t, []string{"test-project", "test-project-green"}, stopped, "green stack is stopped")
+
+	greenHealthy.Store(false)
+	stopped = nil
+	_, err = RunDeployment(projectName, DeployOptions{})
+	assert.ErrorIs(t, err, ErrHealthCheckFailed, "unhealthy release fails the deployment")
+	assert.Contains(t, partial(), "127.0.0.1:"+strconv.Itoa(port(blue)), "traffic stays on the blue slot")
+	assert.Equal(t, []string{"test-project-green"}, stopped, "failed release is stopped")
+
+	p, _ = FindProject(projectName)
+	assert.Equal(t, "blue", p.ActiveSlot, "active slot is unchanged")
+}
+
+func TestSwitchSlotRollsBack(t *testing.T) {
+	consts.Testing = true
+	consts.BasePath = t.TempDir()
+
+	np := Project{Name: "test"}
+	addProject(np)
+	createProjectSecretsJson(np)
+	SetDeployStrategy("test", StrategyBlueGreen)
+	editProject("test", func(p *Project) { p.ActiveSlot = slotBlue })
+	AddDomainProxy("test", "test.com", 0)
+	AddDomainRoute("test", "test.com", Route{Path: "/ws/*", Kind: DomainProxy, Port: "PortThree"})
+
+	secrets, _ := readProjectSecrets("test")
+	before, _ := os.ReadFile(getDomainPartialPath("test"))
+
+	originalStopSlot, originalSync := stopSlot, syncDomainRoute
+	defer func() { stopSlot, syncDomainRoute = originalStopSlot, originalSync }()
+	stopSlot = func(p Project, slot string, out io.Writer) error { return nil }
+	syncDomainRoute = func(projectName string) error { return errors.New("connection refused") }
+
+	p, _ := FindProject("test")
+	err := switchSlot(p, slotGreen, secrets, io.Discard)
+	assert.ErrorContains(t, err, "traffic stays on the blue stack")
+
+	p, _ = FindProject("test")
+	assert.Equal(t, slotBlue, p.ActiveSlot, "the active slot is rolled back")
+	after, _ := os.ReadFile(getDomainPartialPath("test"))
+	assert.Equal(t, string(before), string(after), "the partial is rolled back")
+
+	syncDomainRoute = originalSync
+	err = switchSlot(p, slotGreen, secrets, io.Discard)
+	assert.Nil(t, err, "traffic is switched")
+
+	p, _ = FindProject("test")
+	assert.Equal(t, slotGreen, p.ActiveSlot)
+	switched, _ := os.ReadFile(getDomainPartialPath("test"))
+	assert.Contains(t, string(switched), "reverse_proxy 127.0.0.1:"+strconv.Itoa(secrets.PortTwo), "the domain follows the live slot")
+	assert.Contains(t, string(switched), "reverse_proxy 127.0.0.1:"+strconv.Itoa(secrets.PortThree), "routes to other ports are kept")
+}
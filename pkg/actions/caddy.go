@@ -0,0 +1,456 @@
+package actions
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/pmezard/go-difflib/difflib"
+	"github.com/zulubit/mole/pkg/consts"
+)
+
+// caddyAdminURL is the address of the admin API of Caddy.
+var caddyAdminURL = "http://localhost:2019"
+
+// caddyServerName is the HTTP server of the Caddy config the routes of all domain partials are added to.
+const caddyServerName = "mole"
+
+// caddyRoutesPath is the config path of the routes of the mole server.
+const caddyRoutesPath = "/config/apps/http/servers/" + caddyServerName + "/routes"
+
+// errCaddyServerMissing is returned when the running Caddy config has no mole server to add a route to,
+// e.g. because it was loaded by an older version of mole or Caddy started without a config.
+var errCaddyServerMissing = errors.New("caddy config has no " + caddyServerName + " server")
+
+// caddyRoute is a route of the Caddy JSON config, see https://caddyserver.com/docs/json/apps/http/servers/routes/
+type caddyRoute struct {
+	ID       string           `json:"@id,omitempty"`
+	Group    string           `json:"group,omitempty"`
+	Match    []map[string]any `json:"match,omitempty"`
+	Handle   []map[string]any `json:"handle,omitempty"`
+	Terminal bool             `json:"terminal,omitempty"`
+}
+
+// caddyServer is the part of an HTTP server of the Caddy JSON config mole reads from adapted partials.
+type caddyServer struct {
+	Listen []string     `json:"listen"`
+	Routes []caddyRoute `json:"routes"`
+}
+
+// caddyAPIError is an error response of the Caddy admin API.
+type caddyAPIError struct {
+	Status  int
+	Message string
+}
+
+func (e caddyAPIError) Error() string {
+	return fmt.Sprintf("Caddy API returned status %d: %s", e.Status, e.Message)
+}
+
+// caddyClient talks to the admin API of Caddy.
+type caddyClient struct {
+	url  string
+	http *http.Client
+}
+
+func newCaddyClient() caddyClient {
+	return caddyClient{url: caddyAdminURL, http: &http.Client{Timeout: 30 * time.Second}}
+}
+
+// caddyRouteID returns the stable @id of the route of a domain partial, domains/<name>.caddy.
+func caddyRouteID(name string) string {
+	return "mole-domains-" + name
+}
+
+// request sends a request to the admin API and returns the body of a successful response.
+func (c caddyClient) request(method, apiPath, contentType string, body []byte) ([]byte, error) {
+	req, err := http.NewRequest(method, c.url+apiPath, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach the Caddy API: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the Caddy API response: %w", err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		message := strings.TrimSpace(string(respBody))
+		if json.Unmarshal(respBody, &apiErr) == nil && apiErr.Error != "" {
+			message = apiErr.Error
+		}
+		return nil, caddyAPIError{Status: resp.StatusCode, Message: message}
+	}
+
+	return respBody, nil
+}
+
+// requestJSON sends v as JSON to the admin API.
+func (c caddyClient) requestJSON(method, apiPath string, v any) ([]byte, error) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode caddy config: %w", err)
+	}
+	return c.request(method, apiPath, "application/json", body)
+}
+
+// adapt converts a Caddyfile to the JSON config without loading it.
+func (c caddyClient) adapt(caddyfile []byte) (json.RawMessage, error) {
+	body, err := c.request(http.MethodPost, "/adapt", "text/caddyfile", caddyfile)
+	if err != nil {
+		return nil, err
+	}
+
+	var adapted struct {
+		Result json.RawMessage `json:"result"`
+	}
+	if err := json.Unmarshal(body, &adapted); err != nil {
+		return nil, fmt.Errorf("failed to parse adapted caddy config: %w", err)
+	}
+	return adapted.Result, nil
+}
+
+// load replaces the whole running config.
+func (c caddyClient) load(config any) error {
+	_, err := c.requestJSON(http.MethodPost, "/load", config)
+	return err
+}
+
+// upsertRoute replaces the route with the same @id, or appends it to the routes of the mole server if there is none.
+func (c caddyClient) upsertRoute(route caddyRoute) error {
+	_, err := c.requestJSON(http.MethodPatch, "/id/"+route.ID, route)
+	var apiErr caddyAPIError
+	if !errors.As(err, &apiErr) || apiErr.Status != http.StatusNotFound {
+		return err
+	}
+
+	server, err := c.request(http.MethodGet, "/config/apps/http/servers/"+caddyServerName, "", nil)
+	if err != nil {
+		return err
+	}
+	if trimmed := bytes.TrimSpace(server); len(trimmed) == 0 || string(trimmed) == "null" {
+		return errCaddyServerMissing
+	}
+
+	_, err = c.requestJSON(http.MethodPost, caddyRoutesPath, route)
+	return err
+}
+
+// deleteRoute removes the route with the @id, a route that does not exist is not an error.
+func (c caddyClient) deleteRoute(id string) error {
+	_, err := c.request(http.MethodDelete, "/id/"+id, "", nil)
+	var apiErr caddyAPIError
+	if errors.As(err, &apiErr) && apiErr.Status == http.StatusNotFound {
+		return nil
+	}
+	return err
+}
+
+// partialRoute adapts the partial domains/<name>.caddy to a single route under a stable @id.
+// The routes of its sites become a subroute, so the project can be updated without touching the others.
+// Only the routes of HTTPS sites are used, global options and TLS settings belong in caddy/main.caddy.
+// ok is false when the partial is empty.
+func (c caddyClient) partialRoute(name string) (route caddyRoute, ok bool, err error) {
+	partialPath := getDomainPartialPath(name)
+	content, err := os.ReadFile(partialPath)
+	if err != nil {
+		return caddyRoute{}, false, fmt.Errorf("failed to read domain configuration %s: %w", partialPath, err)
+	}
+	if len(bytes.TrimSpace(content)) == 0 {
+		return caddyRoute{}, false, nil
+	}
+
+	invalid := func(err error) (caddyRoute, bool, error) {
+		return caddyRoute{}, false, fmt.Errorf("invalid domain configuration domains/%s.caddy: %w", name, err)
+	}
+
+	adapted, err := c.adapt(content)
+	var apiErr caddyAPIError
+	if errors.As(err, &apiErr) {
+		return invalid(err)
+	}
+	if err != nil {
+		return caddyRoute{}, false, err
+	}
+
+	var config struct {
+		Apps map[string]json.RawMessage `json:"apps"`
+	}
+	if err := json.Unmarshal(adapted, &config); err != nil {
+		return invalid(fmt.Errorf("failed to parse adapted config: %w", err))
+	}
+
+	var httpApp struct {
+		Servers map[string]caddyServer `json:"servers"`
+	}
+	for app, raw := range config.Apps {
+		if app != "http" {
+			return invalid(fmt.Errorf("it configures the %s app, move global options and TLS settings to caddy/main.caddy", app))
+		}
+		if err := json.Unmarshal(raw, &httpApp); err != nil {
+			return invalid(fmt.Errorf("failed to parse adapted config: %w", err))
+		}
+	}
+
+	routes := []caddyRoute{}
+	servers := make([]string, 0, len(httpApp.Servers))
+	for s := range httpApp.Servers {
+		servers = append(servers, s)
+	}
+	slices.Sort(servers)
+	for _, s := range servers {
+		server := httpApp.Servers[s]
+		if !slices.Equal(server.Listen, []string{":443"}) {
+			return invalid(fmt.Errorf("sites listening on %s are not supported, only HTTPS sites are", strings.Join(server.Listen, ", ")))
+		}
+		routes = append(routes, server.Routes...)
+	}
+	if len(routes) == 0 {
+		return caddyRoute{}, false, nil
+	}
+
+	return caddyRoute{
+		ID:     caddyRouteID(name),
+		Match:  routeHosts(routes),
+		Handle: []map[string]any{{"handler": "subroute", "routes": routes}},
+	}, true, nil
+}
+
+// routeHosts returns a host matcher for all hosts the routes match.
+// Caddy only manages certificates for hosts matched at the top level of a server, so the subroute repeats them.
+// Without a host on one of the routes, the subroute has to see every request and nil is returned.
+func routeHosts(routes []caddyRoute) []map[string]any {
+	hosts := []string{}
+	for _, r := range routes {
+		if len(r.Match) == 0 {
+			return nil
+		}
+		for _, m := range r.Match {
+			matched, ok := m["host"].([]any)
+			if !ok {
+				return nil
+			}
+			for _, h := range matched {
+				if host, ok := h.(string); ok && !slices.Contains(hosts, host) {
+					hosts = append(hosts, host)
+				}
+			}
+		}
+	}
+	return []map[string]any{{"host": hosts}}
+}
+
+// updateCaddyRoute applies the partial of a project to the running Caddy as a single route, leaving all other routes alone.
+// When the running config is not managed by mole yet, the whole config is loaded from the partials instead.
+func updateCaddyRoute(projectName string) error {
+	c := newCaddyClient()
+
+	route, ok, err := c.partialRoute(projectName)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return c.deleteRoute(caddyRouteID(projectName))
+	}
+
+	err = c.upsertRoute(route)
+	if errors.Is(err, errCaddyServerMissing) {
+		_, err = ReloadCaddy(false)
+	}
+	return err
+}
+
+// removeCaddyRoute removes the route of a project from the running Caddy.
+func removeCaddyRoute(projectName string) error {
+	return newCaddyClient().deleteRoute(caddyRouteID(projectName))
+}
+
+// CaddyReload is the result of rebuilding the Caddy config from the partials.
+type CaddyReload struct {
+	DryRun bool `json:"dryRun"`
+	// Changed reports whether the rebuilt config differs from the running one.
+	Changed bool `json:"changed"`
+	// Diff is a unified diff from the running config to the rebuilt one, empty when nothing changed.
+	Diff string `json:"diff"`
+}
+
+// ReloadCaddy replaces the running Caddy config with the one built from caddy/main.caddy and the partials in domains,
+// which are the snapshot of every route mole manages and are used to recover them.
+// Nothing is applied when a partial fails to adapt, so the running sites stay up. With dryRun the config is only validated and diffed.
+func ReloadCaddy(dryRun bool) (CaddyReload, error) {
+	c := newCaddyClient()
+	reload := CaddyReload{DryRun: dryRun}
+
+	config, err := buildCaddyConfig(c)
+	if err != nil {
+		return reload, err
+	}
+
+	running, err := c.request(http.MethodGet, "/config/", "", nil)
+	if err != nil {
+		return reload, fmt.Errorf("failed to read the running caddy config: %w", err)
+	}
+
+	reload.Diff, err = diffCaddyConfig(running, config)
+	if err != nil {
+		return reload, err
+	}
+	reload.Changed = reload.Diff != ""
+
+	if dryRun {
+		return reload, nil
+	}
+
+	if err := c.load(config); err != nil {
+		return reload, fmt.Errorf("failed to load caddy config: %w", err)
+	}
+
+	return reload, nil
+}
+
+// buildCaddyConfig adapts caddy/main.caddy and adds the route of every partial in domains to its mole server.
+// It fails naming every partial that does not adapt, and when the partials conflict with each other once merged.
+func buildCaddyConfig(c caddyClient) (map[string]any, error) {
+	mainFilePath := path.Join(consts.GetBasePath(), "caddy", "main.caddy")
+	mainCaddyContent, err := os.ReadFile(mainFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read main Caddyfile %s: %w", mainFilePath, err)
+	}
+
+	config := map[string]any{}
+	if len(bytes.TrimSpace(mainCaddyContent)) > 0 {
+		adapted, err := c.adapt(mainCaddyContent)
+		if err != nil {
+			return nil, fmt.Errorf("invalid main Caddyfile %s: %w", mainFilePath, err)
+		}
+		if err := json.Unmarshal(adapted, &config); err != nil || config == nil {
+			config = map[string]any{}
+		}
+	}
+
+	apps := childMap(config, "apps")
+	servers := childMap(childMap(apps, "http"), "servers")
+
+	// sites of the main Caddyfile are served next to the partials
+	for name, s := range servers {
+		server, _ := s.(map[string]any)
+		if listen, _ := server["listen"].([]any); len(listen) == 1 && listen[0] == ":443" {
+			delete(servers, name)
+			servers[caddyServerName] = server
+			break
+		}
+	}
+	server := childMap(servers, caddyServerName)
+	server["listen"] = []any{":443"}
+	routes, ok := server["routes"].([]any)
+	if !ok {
+		routes = []any{}
+	}
+
+	domainsDir := path.Join(consts.GetBasePath(), "domains")
+	entries, err := os.ReadDir(domainsDir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read domain configurations %s: %w", domainsDir, err)
+	}
+
+	merged := bytes.NewBuffer(append(mainCaddyContent, '\n'))
+	partialErrs := []error{}
+	for _, e := range entries {
+		name, isPartial := strings.CutSuffix(e.Name(), ".caddy")
+		if e.IsDir() || !isPartial {
+			continue
+		}
+
+		route, ok, err := c.partialRoute(name)
+		if err != nil {
+			partialErrs = append(partialErrs, err)
+			continue
+		}
+		if ok {
+			routes = append(routes, route)
+		}
+
+		content, _ := os.ReadFile(getDomainPartialPath(name))
+		merged.Write(append(content, '\n'))
+	}
+	if len(partialErrs) > 0 {
+		return nil, fmt.Errorf("refusing to reload caddy: %w", errors.Join(partialErrs...))
+	}
+
+	// every partial is valid on its own, but sites defined by more than one of them only fail once merged
+	if _, err := c.adapt(merged.Bytes()); err != nil {
+		return nil, fmt.Errorf("refusing to reload caddy, the partials conflict: %w", err)
+	}
+
+	server["routes"] = routes
+
+	return config, nil
+}
+
+// diffCaddyConfig returns a unified diff from the running config to the new one, both indented with sorted keys.
+func diffCaddyConfig(running []byte, config map[string]any) (string, error) {
+	var before any
+	if err := json.Unmarshal(running, &before); err != nil {
+		return "", fmt.Errorf("failed to parse the running caddy config: %w", err)
+	}
+
+	// the new config goes through JSON once, so it is compared in the same shape as the running one
+	encoded, err := json.Marshal(config)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode caddy config: %w", err)
+	}
+	var after any
+	if err := json.Unmarshal(encoded, &after); err != nil {
+		return "", fmt.Errorf("failed to encode caddy config: %w", err)
+	}
+
+	a, err := json.MarshalIndent(before, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	b, err := json.MarshalIndent(after, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if bytes.Equal(a, b) {
+		return "", nil
+	}
+
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(string(a)),
+		B:        difflib.SplitLines(string(b)),
+		FromFile: "running",
+		ToFile:   "domains",
+		Context:  3,
+	})
+}
+
+// childMap returns the object under key, adding an empty one if there is none.
+func childMap(m map[string]any, key string) map[string]any {
+	child, ok := m[key].(map[string]any)
+	if !ok {
+		child = map[string]any{}
+		m[key] = child
+	}
+	return child
+}
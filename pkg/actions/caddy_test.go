@@ -0,0 +1,258 @@
+package actions
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zulubit/mole/pkg/consts"
+)
+
+// adaptedProxy is the config Caddy adapts successDomainProxy to.
+const adaptedProxy = `{"apps":{"http":{"servers":{"srv0":{"listen":[":443"],"routes":[
+	{"match":[{"host":["www.test.com"]}],"handle":[{"handler":"subroute","routes":[{"handle":[{"handler":"static_response","headers":{"Location":["https://test.com{http.request.uri}"]},"status_code":302}]}]}],"terminal":true},
+	{"match":[{"host":["test.com"]}],"handle":[{"handler":"subroute","routes":[{"handle":[{"handler":"reverse_proxy","upstreams":[{"dial":"127.0.0.1:3000"}]}]}]}],"terminal":true}
+]}}}}}`
+
+// fakeCaddy is a minimal Caddy admin API keeping the routes of the mole server.
+type fakeCaddy struct {
+	mu sync.Mutex
+	// adapted maps Caddyfiles to their adapted config, others adapt to an empty config
+	// unless they hold the directive "broken" or define a site twice
+	adapted map[string]string
+	server  bool
+	routes  []map[string]any
+	loaded  map[string]any
+}
+
+func (f *fakeCaddy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	body, _ := io.ReadAll(r.Body)
+	fail := func(status int, msg string) {
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]string{"error": msg})
+	}
+	routeIndex := func(id string) int {
+		for i, route := range f.routes {
+			if route["@id"] == id {
+				return i
+			}
+		}
+		return -1
+	}
+
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/adapt":
+		result, ok := f.adapted[string(body)]
+		if !ok {
+			result = "{}"
+			sites := map[string]bool{}
+			for _, line := range strings.Split(string(body), "\n") {
+				if strings.Contains(line, "broken") {
+					fail(http.StatusBadRequest, "Caddyfile:1: unrecognized directive: broken")
+					return
+				}
+				if site, ok := strings.CutSuffix(line, " {"); ok {
+					if sites[site] {
+						fail(http.StatusBadRequest, "ambiguous site definition: "+site)
+						return
+					}
+					sites[site] = true
+				}
+			}
+		}
+		w.Write([]byte(`{"result":` + result + `}`))
+	case r.Method == http.MethodPost && r.URL.Path == "/load":
+		json.Unmarshal(body, &f.loaded)
+		f.server = true
+	case r.Method == http.MethodGet && r.URL.Path == "/config/":
+		if f.loaded == nil {
+			w.Write([]byte("null\n"))
+			return
+		}
+		json.NewEncoder(w).Encode(f.loaded)
+	case r.Method == http.MethodGet && r.URL.Path == "/config/apps/http/servers/mole":
+		if !f.server {
+			w.Write([]byte("null\n"))
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"routes": f.routes})
+	case r.Method == http.MethodPost && r.URL.Path == caddyRoutesPath:
+		var route map[string]any
+		json.Unmarshal(body, &route)
+		f.routes = append(f.routes, route)
+	case strings.HasPrefix(r.URL.Path, "/id/"):
+		i := routeIndex(strings.TrimPrefix(r.URL.Path, "/id/"))
+		if i < 0 {
+			fail(http.StatusNotFound, "unknown object ID")
+			return
+		}
+		if r.Method == http.MethodDelete {
+			f.routes = append(f.routes[:i], f.routes[i+1:]...)
+			return
+		}
+		var route map[string]any
+		json.Unmarshal(body, &route)
+		f.routes[i] = route
+	default:
+		fail(http.StatusNotFound, "unexpected request "+r.Method+" "+r.URL.Path)
+	}
+}
+
+func setupFakeCaddy(t *testing.T) *fakeCaddy {
+	consts.Testing = true
+	consts.BasePath = t.TempDir()
+
+	f := &fakeCaddy{adapted: map[string]string{successDomainProxy: adaptedProxy}}
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+
+	previous := caddyAdminURL
+	caddyAdminURL = server.URL
+	t.Cleanup(func() { caddyAdminURL = previous })
+
+	os.MkdirAll(path.Join(consts.BasePath, "domains"), 0755)
+	return f
+}
+
+func writePartial(t *testing.T, name, content string) {
+	err := os.WriteFile(getDomainPartialPath(name), []byte(content), 0644)
+	assert.Nil(t, err, "partial should be written")
+}
+
+func TestPartialRoute(t *testing.T) {
+	f := setupFakeCaddy(t)
+	writePartial(t, "test", successDomainProxy)
+
+	route, ok, err := newCaddyClient().partialRoute("test")
+	assert.Nil(t, err, "partial should adapt")
+	assert.True(t, ok)
+	assert.Equal(t, "mole-domains-test", route.ID)
+	assert.Equal(t, []map[string]any{{"host": []string{"www.test.com", "test.com"}}}, route.Match, "hosts are matched at the top level for automatic HTTPS")
+	assert.Equal(t, "subroute", route.Handle[0]["handler"])
+	assert.Len(t, route.Handle[0]["routes"], 2, "every site becomes a route of the subroute")
+
+	writePartial(t, "test", "\n")
+	_, ok, err = newCaddyClient().partialRoute("test")
+	assert.Nil(t, err)
+	assert.False(t, ok, "an empty partial has no route")
+
+	writePartial(t, "test", "test.com {\n    broken\n}")
+	_, _, err = newCaddyClient().partialRoute("test")
+	assert.ErrorContains(t, err, "domains/test.caddy")
+	assert.ErrorContains(t, err, "unrecognized directive")
+
+	f.adapted["http://test.com {\n}"] = `{"apps":{"http":{"servers":{"srv0":{"listen":[":80"],"routes":[{"match":[{"host":["test.com"]}]}]}}}}}`
+	writePartial(t, "test", "http://test.com {\n}")
+	_, _, err = newCaddyClient().partialRoute("test")
+	assert.ErrorContains(t, err, "only HTTPS sites")
+
+	f.adapted["test.com {\n    tls internal\n}"] = `{"apps":{"tls":{}}}`
+	writePartial(t, "test", "test.com {\n    tls internal\n}")
+	_, _, err = newCaddyClient().partialRoute("test")
+	assert.ErrorContains(t, err, "caddy/main.caddy")
+}
+
+func TestUpdateCaddyRoute(t *testing.T) {
+	f := setupFakeCaddy(t)
+	f.server = true
+	f.routes = []map[string]any{{"@id": "mole-domains-other"}}
+	writePartial(t, "test", successDomainProxy)
+
+	err := updateCaddyRoute("test")
+	assert.Nil(t, err, "route should be added")
+	assert.Len(t, f.routes, 2, "a new route is appended")
+	assert.Equal(t, "mole-domains-test", f.routes[1]["@id"])
+
+	switched := strings.Replace(successDomainProxy, "3000", "3001", 1)
+	f.adapted[switched] = strings.Replace(adaptedProxy, "3000", "3001", 1)
+	writePartial(t, "test", switched)
+
+	err = updateCaddyRoute("test")
+	assert.Nil(t, err, "route should be replaced")
+	assert.Len(t, f.routes, 2, "the route is replaced in place")
+	assert.Equal(t, map[string]any{"@id": "mole-domains-other"}, f.routes[0], "other routes are left alone")
+	encoded, _ := json.Marshal(f.routes[1])
+	assert.Contains(t, string(encoded), "127.0.0.1:3001")
+
+	err = removeCaddyRoute("test")
+	assert.Nil(t, err, "route should be removed")
+	assert.Equal(t, []map[string]any{{"@id": "mole-domains-other"}}, f.routes)
+
+	err = removeCaddyRoute("test")
+	assert.Nil(t, err, "removing a missing route is not an error")
+}
+
+func TestUpdateCaddyRouteLoadsConfigWithoutServer(t *testing.T) {
+	f := setupFakeCaddy(t)
+	os.MkdirAll(path.Join(consts.BasePath, "caddy"), 0755)
+	os.WriteFile(path.Join(consts.BasePath, "caddy", "main.caddy"), []byte("{\n\temail test@test.com\n}\n"), 0644)
+	f.adapted["{\n\temail test@test.com\n}\n"] = `{"apps":{"tls":{"automation":{"policies":[{"issuers":[{"module":"acme","email":"test@test.com"}]}]}}}}`
+	writePartial(t, "test", successDomainProxy)
+
+	err := updateCaddyRoute("test")
+	assert.Nil(t, err, "the whole config should be loaded")
+	assert.NotNil(t, f.loaded["apps"].(map[string]any)["tls"], "global options of main.caddy are kept")
+
+	encoded, _ := json.Marshal(f.loaded)
+	assert.Contains(t, string(encoded), `"servers":{"mole":{"listen":[":443"],"routes":[{"@id":"mole-domains-test"`)
+}
+
+func TestReloadCaddy(t *testing.T) {
+	f := setupFakeCaddy(t)
+	os.MkdirAll(path.Join(consts.BasePath, "caddy"), 0755)
+	os.WriteFile(path.Join(consts.BasePath, "caddy", "main.caddy"), []byte{}, 0644)
+	writePartial(t, "empty", "")
+	writePartial(t, "test", successDomainProxy)
+
+	reload, err := ReloadCaddy(true)
+	assert.Nil(t, err, "the partials should validate")
+	assert.True(t, reload.Changed, "nothing is running yet")
+	assert.Contains(t, reload.Diff, "--- running\n+++ domains\n")
+	assert.Contains(t, reload.Diff, "-null\n")
+	assert.Contains(t, reload.Diff, `+              "@id": "mole-domains-test",`)
+	assert.Nil(t, f.loaded, "a dry run applies nothing")
+
+	reload, err = ReloadCaddy(false)
+	assert.Nil(t, err, "caddy should be reloaded")
+	assert.True(t, reload.Changed)
+	routes := f.loaded["apps"].(map[string]any)["http"].(map[string]any)["servers"].(map[string]any)["mole"].(map[string]any)["routes"].([]any)
+	assert.Len(t, routes, 1, "the empty partial has no route")
+	assert.Equal(t, "mole-domains-test", routes[0].(map[string]any)["@id"])
+
+	reload, err = ReloadCaddy(true)
+	assert.Nil(t, err)
+	assert.False(t, reload.Changed, "the running config matches the partials")
+	assert.Empty(t, reload.Diff)
+}
+
+func TestReloadCaddyRefusesInvalidPartials(t *testing.T) {
+	f := setupFakeCaddy(t)
+	os.MkdirAll(path.Join(consts.BasePath, "caddy"), 0755)
+	os.WriteFile(path.Join(consts.BasePath, "caddy", "main.caddy"), []byte{}, 0644)
+	writePartial(t, "broken", "broken.com {\n    broken\n}")
+	writePartial(t, "test", successDomainProxy)
+
+	for _, dryRun := range []bool{true, false} {
+		_, err := ReloadCaddy(dryRun)
+		assert.ErrorContains(t, err, "domains/broken.caddy", "the invalid partial is named")
+		assert.NotContains(t, err.Error(), "domains/test.caddy", "valid partials are not named")
+		assert.Nil(t, f.loaded, "nothing is applied")
+	}
+
+	os.Remove(getDomainPartialPath("broken"))
+	writePartial(t, "copy", "test.com {\n}")
+	_, err := ReloadCaddy(false)
+	assert.ErrorContains(t, err, "the partials conflict")
+	assert.ErrorContains(t, err, "ambiguous site definition: test.com")
+	assert.Nil(t, f.loaded, "nothing is applied")
+}
@@ -0,0 +1,313 @@
+package actions
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/zulubit/mole/pkg/consts"
+)
+
+// composeReadyFile is the rendered compose file every compose command of mole operates on.
+const composeReadyFile = "mole-compose-ready.yaml"
+
+// ComposeService is the status of a container of a project's compose stack.
+type ComposeService struct {
+	Service  string   `json:"service"`
+	Name     string   `json:"name"`
+	Image    string   `json:"image"`
+	State    string   `json:"state"`
+	Health   string   `json:"health,omitempty"`
+	Status   string   `json:"status"`
+	ExitCode int      `json:"exitCode"`
+	Ports    []string `json:"ports,omitempty"`
+}
+
+// ComposeIO connects a compose command to the terminal, or to a buffer in tests.
+type ComposeIO struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// runCompose runs "docker compose" with the given arguments in the directory of a project.
+var runCompose = func(p Project, env []string, args []string, cio ComposeIO) error {
+	cmd := exec.Command("docker", append([]string{"compose"}, args...)...)
+	cmd.Dir = path.Join(consts.GetBasePath(), "projects", p.Name)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdin = cio.Stdin
+	cmd.Stdout = cio.Stdout
+	cmd.Stderr = cio.Stderr
+
+	return cmd.Run()
+}
+
+// composeArgs returns the arguments selecting the compose file and the compose project the stack of a slot runs under.
+// The project name is passed explicitly, so it does not depend on the directory compose is run in.
+func composeArgs(p Project, slot string, args ...string) []string {
+	return append([]string{"-f", composeReadyFile, "-p", composeProjectName(p, slot)}, args...)
+}
+
+// composeEnv returns the environment compose runs with, blue/green projects see the variables of their live slot.
+func composeEnv(p Project) []string {
+	if p.Strategy != StrategyBlueGreen || p.ActiveSlot == "" {
+		return nil
+	}
+	return slotEnv(p, p.ActiveSlot, activeSlotPort(p))
+}
+
+// prepareCompose renders mole-compose-ready.yaml again when the compose template was changed after it was last rendered.
+func prepareCompose(p Project) error {
+	m, err := readManifest(p)
+	if err != nil {
+		return err
+	}
+
+	projectPath := path.Join(consts.GetBasePath(), "projects", p.Name)
+	template, templateErr := os.Stat(path.Join(projectPath, m.composeFile()))
+	ready, readyErr := os.Stat(path.Join(projectPath, composeReadyFile))
+
+	if templateErr != nil && readyErr != nil {
+		return notFoundError{fmt.Sprintf("project %s has no %s", p.Name, m.composeFile())}
+	}
+
+	if templateErr == nil && (readyErr != nil || template.ModTime().After(ready.ModTime())) {
+		if err := TransformCompose(p.Name); err != nil {
+			return fmt.Errorf("failed to render %s: %w", m.composeFile(), err)
+		}
+	}
+
+	return nil
+}
+
+// composeProject looks up a project and prepares its compose file for a compose command.
+func composeProject(projectNOI string) (Project, error) {
+	p, err := FindProject(projectNOI)
+	if err != nil {
+		return Project{}, err
+	}
+
+	return p, prepareCompose(p)
+}
+
+// runProjectCompose runs a compose command on the live stack of a project.
+func runProjectCompose(p Project, cio ComposeIO, args ...string) error {
+	err := runCompose(p, composeEnv(p), composeArgs(p, p.ActiveSlot, args...), cio)
+	if err != nil {
+		return fmt.Errorf("docker compose %s failed: %w", args[0], err)
+	}
+	return nil
+}
+
+// withService appends the service to the arguments of a compose command, if one is given.
+func withService(args []string, service string) []string {
+	if service == "" {
+		return args
+	}
+	return append(args, service)
+}
+
+// ComposeUp creates and starts the stack of a project, or one of its services, in the background.
+func ComposeUp(projectNOI, service string, out io.Writer) error {
+	p, err := composeProject(projectNOI)
+	if err != nil {
+		return err
+	}
+
+	return runProjectCompose(p, ComposeIO{Stdout: out, Stderr: out}, withService([]string{"up", "-d"}, service)...)
+}
+
+// ComposeDown stops and removes the stack of a project. With a service, only the containers of that service are removed.
+func ComposeDown(projectNOI, service string, out io.Writer) error {
+	p, err := composeProject(projectNOI)
+	if err != nil {
+		return err
+	}
+
+	args := []string{"down"}
+	if service != "" {
+		args = []string{"rm", "--stop", "--force", service}
+	}
+
+	return runProjectCompose(p, ComposeIO{Stdout: out, Stderr: out}, args...)
+}
+
+// ComposeRestart restarts the containers of a project, or of one of its services.
+func ComposeRestart(projectNOI, service string, out io.Writer) error {
+	p, err := composeProject(projectNOI)
+	if err != nil {
+		return err
+	}
+
+	return runProjectCompose(p, ComposeIO{Stdout: out, Stderr: out}, withService([]string{"restart"}, service)...)
+}
+
+// ComposePull pulls the images of a project, or of one of its services.
+func ComposePull(projectNOI, service string, out io.Writer) error {
+	p, err := composeProject(projectNOI)
+	if err != nil {
+		return err
+	}
+
+	return runProjectCompose(p, ComposeIO{Stdout: out, Stderr: out}, withService([]string{"pull"}, service)...)
+}
+
+// ComposeLogs writes the logs of a project's containers, or of one of its services, to out.
+// With follow it keeps streaming until compose is interrupted. A tail of "" prints all lines.
+func ComposeLogs(projectNOI, service string, follow bool, tail string, out io.Writer) error {
+	p, err := composeProject(projectNOI)
+	if err != nil {
+		return err
+	}
+
+	args := []string{"logs"}
+	if follow {
+		args = append(args, "--follow")
+	}
+	if tail != "" {
+		args = append(args, "--tail", tail)
+	}
+
+	return runProjectCompose(p, ComposeIO{Stdout: out, Stderr: out}, withService(args, service)...)
+}
+
+// ComposeExec runs a command in the running container of a service of a project.
+// Without tty, no pseudo-terminal is allocated, as needed when stdin is not a terminal.
+func ComposeExec(projectNOI, service string, command []string, tty bool, cio ComposeIO) error {
+	p, err := composeProject(projectNOI)
+	if err != nil {
+		return err
+	}
+
+	args := []string{"exec"}
+	if !tty {
+		args = append(args, "-T")
+	}
+	args = append(append(args, service), command...)
+
+	err = runCompose(p, composeEnv(p), composeArgs(p, p.ActiveSlot, args...), cio)
+
+	// the exit status of the command is passed on as it is, compose already printed its output
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return fmt.Errorf("command exited with status %d: %w", exitErr.ExitCode(), err)
+	}
+	if err != nil {
+		return fmt.Errorf("docker compose exec failed: %w", err)
+	}
+	return nil
+}
+
+// ComposePs returns the status of the containers of a project, or of one of its services, stopped ones included.
+func ComposePs(projectNOI, service string) ([]ComposeService, error) {
+	p, err := composeProject(projectNOI)
+	if err != nil {
+		return nil, err
+	}
+
+	return composeServices(p, service)
+}
+
+// composeServices lists the containers of the live stack of a project as it was last rendered.
+func composeServices(p Project, service string) ([]ComposeService, error) {
+	var stdout, stderr bytes.Buffer
+	err := runProjectCompose(p, ComposeIO{Stdout: &stdout, Stderr: &stderr}, withService([]string{"ps", "--all", "--format", "json"}, service)...)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	return parseComposePs(stdout.Bytes())
+}
+
+// composePsEntry is a container as printed by "docker compose ps --format json".
+type composePsEntry struct {
+	Name       string
+	Service    string
+	Image      string
+	State      string
+	Health     string
+	Status     string
+	ExitCode   int
+	Publishers []struct {
+		URL           string
+		TargetPort    int
+		PublishedPort int
+		Protocol      string
+	}
+}
+
+// parseComposePs parses the output of "docker compose ps --format json".
+// Compose prints a JSON array before version 2.21 and one JSON object per line since.
+func parseComposePs(output []byte) ([]ComposeService, error) {
+	output = bytes.TrimSpace(output)
+
+	entries := []composePsEntry{}
+	if bytes.HasPrefix(output, []byte("[")) {
+		if err := json.Unmarshal(output, &entries); err != nil {
+			return nil, fmt.Errorf("failed to parse docker compose ps output: %w", err)
+		}
+	} else {
+		scanner := bufio.NewScanner(bytes.NewReader(output))
+		for scanner.Scan() {
+			line := bytes.TrimSpace(scanner.Bytes())
+			if len(line) == 0 {
+				continue
+			}
+
+			var e composePsEntry
+			if err := json.Unmarshal(line, &e); err != nil {
+				return nil, fmt.Errorf("failed to parse docker compose ps output: %w", err)
+			}
+			entries = append(entries, e)
+		}
+	}
+
+	services := make([]ComposeService, len(entries))
+	for i, e := range entries {
+		s := ComposeService{
+			Service:  e.Service,
+			Name:     e.Name,
+			Image:    e.Image,
+			State:    e.State,
+			Health:   e.Health,
+			Status:   e.Status,
+			ExitCode: e.ExitCode,
+		}
+
+		for _, pub := range e.Publishers {
+			if pub.PublishedPort == 0 {
+				continue
+			}
+			s.Ports = append(s.Ports, pub.URL+":"+strconv.Itoa(pub.PublishedPort)+"->"+strconv.Itoa(pub.TargetPort)+"/"+pub.Protocol)
+		}
+
+		services[i] = s
+	}
+
+	return services, nil
+}
+
+// Stringify returns a one line description of a container for the table output.
+func (s ComposeService) Stringify() string {
+	state := s.State
+	if s.Health != "" {
+		state += " (" + s.Health + ")"
+	}
+	if s.State == "exited" {
+		state += " with " + strconv.Itoa(s.ExitCode)
+	}
+
+	line := fmt.Sprintf("%-20s %-24s %s", s.Service, state, s.Status)
+	if len(s.Ports) > 0 {
+		line += "  " + strings.Join(s.Ports, ", ")
+	}
+	return line
+}
@@ -0,0 +1,123 @@
+package actions
+
+import (
+	"io"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zulubit/mole/pkg/consts"
+)
+
+func TestComposeCommands(t *testing.T) {
+	consts.Testing = true
+
+	tmp := t.TempDir()
+	consts.BasePath = tmp
+
+	np := Project{Name: "compose-project"}
+	err := addProject(np)
+	assert.Nil(t, err, "project added")
+	err = createProjectSecretsJson(np)
+	assert.Nil(t, err, "project secrets created")
+
+	var calls [][]string
+	var env []string
+	originalRunCompose := runCompose
+	defer func() { runCompose = originalRunCompose }()
+	runCompose = func(p Project, e []string, args []string, cio ComposeIO) error {
+		calls = append(calls, args)
+		env = e
+		if args[4] == "ps" {
+			io.WriteString(cio.Stdout, `{"Name":"compose-project-app-1","Service":"app","State":"running","Health":"healthy","Status":"Up 2 minutes (healthy)","Publishers":[{"URL":"127.0.0.1","TargetPort":8080,"PublishedPort":8000,"Protocol":"tcp"}]}`+"\n")
+		}
+		return nil
+	}
+
+	err = ComposeUp(np.Name, "", io.Discard)
+	assert.ErrorIs(t, err, ErrNotFound, "projects without a compose file can not be started")
+
+	projectPath := path.Join(tmp, "projects", np.Name)
+	os.MkdirAll(projectPath, 0755)
+	composePath := path.Join(projectPath, "mole-compose.yaml")
+	readyPath := path.Join(projectPath, "mole-compose-ready.yaml")
+	os.WriteFile(composePath, []byte("name: {{.ProjectName}}"), 0644)
+
+	err = ComposeUp(np.Name, "app", io.Discard)
+	assert.Nil(t, err, "stack started")
+	assert.Equal(t, []string{"-f", "mole-compose-ready.yaml", "-p", "compose-project", "up", "-d", "app"}, calls[0], "compose runs on the rendered file under the project name")
+	ready, _ := os.ReadFile(readyPath)
+	assert.Equal(t, "name: compose-project", string(ready), "missing compose file is rendered")
+
+	// a compose file rendered after the last change of the template is left alone
+	os.WriteFile(readyPath, []byte("edited"), 0644)
+	err = ComposeRestart(np.Name, "", io.Discard)
+	assert.Nil(t, err, "stack restarted")
+	assert.Equal(t, []string{"restart"}, calls[1][4:])
+	ready, _ = os.ReadFile(readyPath)
+	assert.Equal(t, "edited", string(ready), "up to date compose file is not rendered again")
+
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(composePath, later, later)
+	err = ComposeDown(np.Name, "worker", io.Discard)
+	assert.Nil(t, err, "service removed")
+	assert.Equal(t, []string{"rm", "--stop", "--force", "worker"}, calls[2][4:], "down of a service removes its containers only")
+	ready, _ = os.ReadFile(readyPath)
+	assert.Equal(t, "name: compose-project", string(ready), "compose file is rendered again when the template is newer")
+
+	err = ComposeLogs(np.Name, "app", true, "100", io.Discard)
+	assert.Nil(t, err, "logs printed")
+	assert.Equal(t, []string{"logs", "--follow", "--tail", "100", "app"}, calls[3][4:])
+
+	err = ComposeExec(np.Name, "app", []string{"php", "artisan", "migrate"}, false, ComposeIO{})
+	assert.Nil(t, err, "command run")
+	assert.Equal(t, []string{"exec", "-T", "app", "php", "artisan", "migrate"}, calls[4][4:], "no terminal is allocated without one")
+
+	services, err := ComposePs(np.Name, "")
+	assert.Nil(t, err, "services listed")
+	assert.Equal(t, []ComposeService{{
+		Service: "app",
+		Name:    "compose-project-app-1",
+		State:   "running",
+		Health:  "healthy",
+		Status:  "Up 2 minutes (healthy)",
+		Ports:   []string{"127.0.0.1:8000->8080/tcp"},
+	}}, services, "structured status is returned")
+	assert.Empty(t, env, "projects deployed in place run without slot variables")
+
+	err = editProject(np.Name, func(p *Project) {
+		p.Strategy = StrategyBlueGreen
+		p.ActiveSlot = slotGreen
+	})
+	assert.Nil(t, err, "project switched to blue/green")
+
+	err = ComposePull(np.Name, "", io.Discard)
+	assert.Nil(t, err, "images pulled")
+	assert.Equal(t, "compose-project-green", calls[6][3], "blue/green projects reach the stack of the live slot")
+	assert.Contains(t, env, "COMPOSE_PROJECT_NAME=compose-project-green", "compose sees the variables of the live slot")
+
+	_, err = RundDeplyDown(np.Name)
+	assert.Nil(t, err, "deploy --down still works")
+	assert.Equal(t, []string{"-f", "mole-compose-ready.yaml", "-p", "compose-project-green", "down"}, calls[7])
+}
+
+func TestParseComposePs(t *testing.T) {
+	// compose before 2.21 prints an array
+	services, err := parseComposePs([]byte(`[{"Name":"a-app-1","Service":"app","State":"exited","ExitCode":1,"Status":"Exited (1)"}]`))
+	assert.Nil(t, err, "array parsed")
+	assert.Equal(t, []ComposeService{{Service: "app", Name: "a-app-1", State: "exited", ExitCode: 1, Status: "Exited (1)"}}, services)
+	assert.Contains(t, services[0].Stringify(), "exited with 1", "exit code of stopped containers is shown")
+
+	services, err = parseComposePs([]byte("{\"Service\":\"app\"}\n{\"Service\":\"db\"}\n"))
+	assert.Nil(t, err, "one object per line parsed")
+	assert.Len(t, services, 2)
+
+	services, err = parseComposePs([]byte(""))
+	assert.Nil(t, err, "no containers")
+	assert.Empty(t, services)
+
+	_, err = parseComposePs([]byte("not json"))
+	assert.ErrorContains(t, err, "failed to parse docker compose ps output")
+}
@@ -0,0 +1,83 @@
+package actions
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/zulubit/mole/pkg/helpers"
+)
+
+// validateSecretKey returns an error when key can not be used as the name of a custom secret.
+func validateSecretKey(key string) error {
+	if !helpers.ValidateEnvKey(key) {
+		return fmt.Errorf("invalid secret key %s, keys can only contain letters, digits and underscores and can not start with a digit", key)
+	}
+	return nil
+}
+
+// SetProjectSecret stores a custom secret of a project, replacing its value if it is already set.
+func SetProjectSecret(projectNOI, key, value string) error {
+	if err := validateSecretKey(key); err != nil {
+		return err
+	}
+
+	p, err := FindProject(projectNOI)
+	if err != nil {
+		return err
+	}
+
+	return updateProjectSecrets(p.Name, func(s *projectSecrets) error {
+		if s.Custom == nil {
+			s.Custom = map[string]string{}
+		}
+		s.Custom[key] = value
+		return nil
+	})
+}
+
+// GetProjectSecret returns the value of a custom secret of a project.
+func GetProjectSecret(projectNOI, key string) (string, error) {
+	secrets, err := ReadProjectSecrets(projectNOI)
+	if err != nil {
+		return "", err
+	}
+
+	value, ok := secrets.Custom[key]
+	if !ok {
+		return "", notFoundError{fmt.Sprintf("secret %s is not set", key)}
+	}
+
+	return value, nil
+}
+
+// UnsetProjectSecret removes a custom secret of a project.
+func UnsetProjectSecret(projectNOI, key string) error {
+	p, err := FindProject(projectNOI)
+	if err != nil {
+		return err
+	}
+
+	return updateProjectSecrets(p.Name, func(s *projectSecrets) error {
+		if _, ok := s.Custom[key]; !ok {
+			return notFoundError{fmt.Sprintf("secret %s is not set", key)}
+		}
+		delete(s.Custom, key)
+		return nil
+	})
+}
+
+// ListProjectSecrets returns the keys of the custom secrets of a project, sorted.
+func ListProjectSecrets(projectNOI string) ([]string, error) {
+	secrets, err := ReadProjectSecrets(projectNOI)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := []string{}
+	for k := range secrets.Custom {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys, nil
+}
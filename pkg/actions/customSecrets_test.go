@@ -0,0 +1,62 @@
+package actions
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zulubit/mole/pkg/consts"
+)
+
+func TestCustomSecrets(t *testing.T) {
+	consts.Testing = true
+
+	tmp := t.TempDir()
+	consts.BasePath = tmp
+
+	err := addProject(Project{Name: "custom-secrets"})
+	assert.Nil(t, err, "project added")
+	np, err := FindProject("custom-secrets")
+	assert.Nil(t, err, "project found")
+	err = createProjectSecretsJson(np)
+	assert.Nil(t, err, "project secrets created")
+
+	err = SetProjectSecret(np.Name, "SMTP-PASSWORD", "hunter2")
+	assert.ErrorContains(t, err, "invalid secret key", "keys must be valid environment variable names")
+
+	err = SetProjectSecret(np.Name, "SMTP_PASSWORD", "hunter2")
+	assert.Nil(t, err, "secret set")
+	err = SetProjectSecret(np.ProjectID, "S3_KEY", "s3-key")
+	assert.Nil(t, err, "secret set by project ID")
+	err = SetProjectSecret(np.Name, "SMTP_PASSWORD", "hunter3")
+	assert.Nil(t, err, "secret replaced")
+
+	value, err := GetProjectSecret(np.Name, "SMTP_PASSWORD")
+	assert.Nil(t, err, "secret found")
+	assert.Equal(t, "hunter3", value, "latest value is returned")
+
+	keys, err := ListProjectSecrets(np.Name)
+	assert.Nil(t, err, "secrets listed")
+	assert.Equal(t, []string{"S3_KEY", "SMTP_PASSWORD"}, keys, "keys are sorted")
+
+	secrets, err := readProjectSecrets(np.Name)
+	assert.Nil(t, err, "secrets read")
+	assert.NotEmpty(t, secrets.AppKey, "generated secrets are kept")
+
+	rendered, err := renderTemplate(`{{.Custom.SMTP_PASSWORD}} {{secret "S3_KEY"}}`, secrets)
+	assert.Nil(t, err, "template rendered")
+	assert.Equal(t, "hunter3 s3-key", rendered, "custom secrets are injected")
+
+	_, err = renderTemplate(`{{secret "MISSING"}}`, secrets)
+	assert.ErrorContains(t, err, "secret MISSING is not set", "missing secrets fail the render")
+
+	err = UnsetProjectSecret(np.Name, "SMTP_PASSWORD")
+	assert.Nil(t, err, "secret unset")
+	_, err = GetProjectSecret(np.Name, "SMTP_PASSWORD")
+	assert.ErrorIs(t, err, ErrNotFound, "unset secret is gone")
+	err = UnsetProjectSecret(np.Name, "SMTP_PASSWORD")
+	assert.ErrorIs(t, err, ErrNotFound, "unsetting a missing secret fails")
+
+	keys, err = ListProjectSecrets(np.Name)
+	assert.Nil(t, err, "secrets listed")
+	assert.Equal(t, []string{"S3_KEY"}, keys, "remaining secrets are listed")
+}
@@ -2,32 +2,398 @@ package actions
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path"
 	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
+	"github.com/lithammer/shortuuid/v4"
 	"github.com/zulubit/mole/pkg/consts"
+	"github.com/zulubit/mole/pkg/helpers"
 )
 
+// ErrDeploymentFailed is returned when a deployment ran and one of its stages failed.
+var ErrDeploymentFailed = errors.New("deployment failed")
+
+// ErrDeploymentTimedOut is returned when a deployment exceeds the project's deploy timeout.
+var ErrDeploymentTimedOut = errors.New("deployment timed out")
+
+// failureHookTimeout bounds the failure hook, which runs after the deploy timeout may already have expired.
+const failureHookTimeout = 5 * time.Minute
+
+// DeployOptions controls how a deployment is run.
+type DeployOptions struct {
+	// Ref pins the deployment to a branch, tag or commit instead of the project's branch.
+	Ref string
+	// SkipPull deploys the working tree as it is, without fetching new commits.
+	SkipPull bool
+	// TriggeredBy names who started the deployment, defaults to the current user.
+	TriggeredBy string
+	// Output receives the deployment output while it runs, next to the deployment log.
+	Output io.Writer
+}
+
+// DeployResult describes the outcome of a deployment request.
+type DeployResult struct {
+	ProjectName string `json:"projectName"`
+	// Queued is set when the request was coalesced into a deployment that was already waiting.
+	Queued bool `json:"queued"`
+	// Deployment is the deployment that ran, nil when the request was queued.
+	Deployment *Deployment `json:"deployment,omitempty"`
+	// Rollback is the deployment restoring the previous commit after a failed health check.
+	Rollback *Deployment `json:"rollback,omitempty"`
+}
+
 // RunDeployment executes the deployment process for a given project.
-func RunDeployment(projectNOI string) (string, error) {
+// Unless disabled, the project's working tree is first reset to the latest commit of its branch or the pinned ref.
+func RunDeployment(projectNOI string, opts DeployOptions) (DeployResult, error) {
 
 	p, err := FindProject(projectNOI)
 	if err != nil {
-		return "", fmt.Errorf("failed to find project: %w", err)
+		return DeployResult{}, fmt.Errorf("failed to find project: %w", err)
+	}
+
+	return deployProject(p, opts, Deployment{Branch: p.Branch, Ref: opts.Ref})
+}
+
+// RollbackDeployment checks out the commit recorded by an earlier deployment and deploys it again.
+// The deployment output is streamed to out.
+func RollbackDeployment(projectNOI, deploymentID string, out io.Writer) (DeployResult, error) {
+	p, err := FindProject(projectNOI)
+	if err != nil {
+		return DeployResult{}, fmt.Errorf("failed to find project: %w", err)
 	}
 
-	err = TransformDeploy(projectNOI)
+	target, err := findDeployment(p.Name, deploymentID)
 	if err != nil {
-		return "", err
+		return DeployResult{}, err
 	}
 
-	succ, err := runDeploymentScript(p)
+	if target.Commit == "" {
+		return DeployResult{}, fmt.Errorf("deployment %s has no recorded commit to roll back to", target.DeploymentID)
+	}
+
+	opts := DeployOptions{Ref: target.Commit, Output: out}
 
-	return succ, nil
+	return deployProject(p, opts, Deployment{Branch: target.Branch, Ref: target.Commit, RollbackOf: target.DeploymentID})
+}
+
+// deployProject brings the project's working tree up to date, then transforms and runs its deployment script,
+// recording the run in the project's deployment history.
+// Deployments of the same project are serialized, see acquireDeployLock.
+func deployProject(p Project, opts DeployOptions, d Deployment) (DeployResult, error) {
+	res := DeployResult{ProjectName: p.Name}
+
+	var out io.Writer = io.Discard
+	if opts.Output != nil {
+		out = opts.Output
+	}
+
+	runLock, err := acquireDeployLock(p.Name, opts.Ref == "", out)
+	if errors.Is(err, ErrDeploymentQueued) {
+		res.Queued = true
+		return res, nil
+	}
+	if err != nil {
+		return res, fmt.Errorf("failed to acquire deploy lock: %w", err)
+	}
+	defer releaseDeployLock(p.Name, runLock)
+
+	// the project may have changed while waiting for the lock, e.g. its active slot
+	if fresh, err := FindProject(p.Name); err == nil {
+		p = fresh
+	}
+
+	d, err = runLockedDeployment(p, opts, d)
+	res.Deployment = &d
+
+	// blue/green deployments never switched traffic to the unhealthy release, there is nothing to roll back
+	if hc := projectHealthCheck(p); errors.Is(err, ErrHealthCheckFailed) && hc != nil && hc.Rollback && p.Strategy != StrategyBlueGreen && d.RollbackOf == "" {
+		res.Rollback = autoRollback(p, opts, d)
+	}
+
+	if err != nil {
+		return res, err
+	}
+
+	return res, nil
+}
+
+// Summary returns a one line description of the outcome of a deployment request.
+func (r DeployResult) Summary() string {
+	if r.Queued {
+		return fmt.Sprintf("A deployment of %s is already queued, it will deploy the latest commits.", r.ProjectName)
+	}
+	if r.Deployment == nil {
+		return ""
+	}
+
+	summary := fmt.Sprintf("Deployment %s finished with status %s", r.Deployment.DeploymentID, r.Deployment.Status)
+
+	if rb := r.Rollback; rb != nil && rb.Status == "success" {
+		summary += fmt.Sprintf(", rolled back to %s with deployment %s", shortCommit(rb.Ref), rb.DeploymentID)
+	} else if rb != nil {
+		summary += fmt.Sprintf(", rollback %s to %s finished with status %s", rb.DeploymentID, shortCommit(rb.Ref), rb.Status)
+	}
+
+	return summary
+}
+
+// runLockedDeployment runs a deployment while holding the project's deploy lock and records it in the history.
+// The finished deployment is returned together with the error it failed with.
+func runLockedDeployment(p Project, opts DeployOptions, d Deployment) (Deployment, error) {
+	d.DeploymentID = shortuuid.New()
+	d.ProjectName = p.Name
+	d.TriggeredBy = opts.TriggeredBy
+	if d.TriggeredBy == "" {
+		d.TriggeredBy = triggeredBy()
+	}
+	d.StartedAt = time.Now()
+
+	logFile, err := createDeployLog(p, d)
+	if err != nil {
+		return d, err
+	}
+	d.LogPath = logFile.Name()
+
+	err = writeDeployState(DeployState{ProjectName: p.Name, DeploymentID: d.DeploymentID, Stage: "preparing", StartedAt: d.StartedAt, LogPath: d.LogPath})
+	if err != nil {
+		logFile.Close()
+		return d, err
+	}
+
+	var out io.Writer = logFile
+	if opts.Output != nil {
+		out = io.MultiWriter(logFile, bestEffortWriter{opts.Output})
+	}
+	out = helpers.NewTimestampWriter(out)
+
+	err = runDeployment(p, opts, &d, out)
+
+	d.FinishedAt = time.Now()
+	d.Status = status(err)
+	d.ExitCode = exitCode(err)
+
+	if err != nil {
+		fmt.Fprintf(out, "Deployment failed: %v\n", err)
+	}
+
+	logFile.Close()
+	d.LogPath = finishDeployLog(d)
+
+	if herr := recordDeployment(d); herr != nil {
+		fmt.Printf("Failed to record deployment %s: %v\n", d.DeploymentID, herr)
+	}
+
+	if d.Status == "failure" {
+		return d, fmt.Errorf("%w: %w", ErrDeploymentFailed, err)
+	}
+
+	return d, err
+}
+
+// autoRollback re-deploys the previous successful commit after a deployment failed its health check.
+// It returns the rollback deployment, or nil when there was no earlier successful deployment.
+func autoRollback(p Project, opts DeployOptions, failed Deployment) *Deployment {
+	prev, ok := previousSuccessfulDeployment(p.Name, failed.Commit)
+	if !ok {
+		if opts.Output != nil {
+			fmt.Fprintln(opts.Output, "No earlier successful deployment to roll back to")
+		}
+		return nil
+	}
+
+	if opts.Output != nil {
+		fmt.Fprintf(opts.Output, "Rolling back to %s of deployment %s\n", shortCommit(prev.Commit), prev.DeploymentID)
+	}
+
+	opts.Ref = prev.Commit
+	opts.SkipPull = false
+
+	rb, _ := runLockedDeployment(p, opts, Deployment{Branch: prev.Branch, Ref: prev.Commit, RollbackOf: prev.DeploymentID})
+
+	return &rb
+}
+
+// runDeployment runs the stages of a deployment, writing their output to out.
+func runDeployment(p Project, opts DeployOptions, d *Deployment, out io.Writer) error {
+	if !opts.SkipPull && !consts.Testing {
+		from, to, err := syncProjectSource(p, opts.Ref)
+		if err != nil {
+			return fmt.Errorf("failed to update project source: %w", err)
+		}
+
+		d.PreviousCommit = from
+		fmt.Fprintf(out, "Updated %s: %s\n", refName(p, opts), commitRange(from, to))
+	}
+
+	d.Commit = currentCommit(p)
+
+	m, err := readManifest(p)
+	if err != nil {
+		return err
+	}
+
+	secrets, err := readProjectSecrets(p.Name)
+	if err != nil {
+		return err
+	}
+
+	if err := m.checkSecrets(secrets); err != nil {
+		return err
+	}
+
+	if err := TransformDeploy(p.Name); err != nil {
+		return err
+	}
+
+	files, err := renderManifestTemplates(p)
+	if err != nil {
+		return err
+	}
+	for _, t := range files {
+		fmt.Fprintf(out, "Rendered %s\n", t.Target)
+	}
+
+	added, err := syncProjectEnv(p)
+	if err != nil {
+		return fmt.Errorf("failed to sync .env: %w", err)
+	}
+	for _, c := range added {
+		fmt.Fprintf(out, "Added %s to .env\n", c.Key)
+	}
+
+	ctx, cancel, err := deployContext(p)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	env := []string{
+		"MOLE_DEPLOYMENT_ID=" + d.DeploymentID,
+		"MOLE_PORT_APP=" + strconv.Itoa(secrets.PortApp),
+	}
+
+	stage, err := runDeployStages(ctx, p, secrets, env, out)
+	if err != nil && !errors.Is(err, ErrDeploymentCancelled) {
+		runFailureHook(p, append(env, "MOLE_FAILED_STAGE="+stage), out)
+	}
+
+	return err
+}
+
+// deployContext returns the context bounding a deployment by the project's deploy timeout, if it has one.
+func deployContext(p Project) (context.Context, context.CancelFunc, error) {
+	if p.DeployTimeout == "" {
+		ctx, cancel := context.WithCancel(context.Background())
+		return ctx, cancel, nil
+	}
+
+	timeout, err := time.ParseDuration(p.DeployTimeout)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid deploy timeout %s: %w", p.DeployTimeout, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	return ctx, cancel, nil
+}
+
+// runDeployStages runs the pre hook, the deployment script, the health check and the post hook in order.
+// Blue/green deployments start the release in the next slot and switch traffic to it once it is healthy.
+// When a stage fails, its name is returned together with the error.
+func runDeployStages(ctx context.Context, p Project, secrets *projectSecrets, env []string, out io.Writer) (string, error) {
+	hc := projectHealthCheck(p)
+	port := secrets.PortApp
+
+	slot := ""
+	if p.Strategy == StrategyBlueGreen {
+		slot = nextSlot(p.ActiveSlot)
+		port = slotPort(secrets, slot)
+		env = append(env, slotEnv(p, slot, port)...)
+
+		if hc == nil {
+			// traffic is only switched to a release that answers
+			hc, _ = NewHealthCheck("/", 0, 0, "", false)
+		}
+
+		fmt.Fprintf(out, "Deploying to the %s slot on port %d, the %s stack keeps serving\n", slot, port, slotName(p.ActiveSlot))
+	}
+
+	if hookExists(p, preDeployHook) {
+		fmt.Fprintln(out, "Running pre-deploy hook...")
+		if err := runDeployScript(ctx, p, preDeployHook.Stage, preDeployHook.Ready, env, out); err != nil {
+			return preDeployHook.Stage, err
+		}
+	}
+
+	// a failed release must not keep occupying the port of its slot
+	stopFailedSlot := func() {
+		if slot == "" {
+			return
+		}
+		fmt.Fprintf(out, "Stopping the failed release in the %s slot...\n", slot)
+		if err := stopSlot(p, slot, out); err != nil {
+			fmt.Fprintf(out, "Failed to stop the %s stack: %v\n", slot, err)
+		}
+	}
+
+	fmt.Fprintln(out, "Deploying, this might take a while...")
+	if err := runDeployScript(ctx, p, "deploy", "mole-ready.sh", env, out); err != nil {
+		stopFailedSlot()
+		return "deploy", err
+	}
+
+	if hc != nil {
+		updateDeployState(p.Name, func(st *DeployState) {
+			st.PID = 0
+			st.Stage = "healthcheck"
+		})
+		fmt.Fprintln(out, "Checking health...")
+		if err := runHealthCheck(ctx, p, hc, port, out); err != nil {
+			stopFailedSlot()
+			return "healthcheck", err
+		}
+	}
+
+	if slot != "" {
+		updateDeployState(p.Name, func(st *DeployState) { st.Stage = "switch" })
+		if err := switchSlot(p, slot, secrets, out); err != nil {
+			stopFailedSlot()
+			return "switch", err
+		}
+	}
+
+	if hookExists(p, postDeployHook) {
+		fmt.Fprintln(out, "Running post-deploy hook...")
+		if err := runDeployScript(ctx, p, postDeployHook.Stage, postDeployHook.Ready, env, out); err != nil {
+			return postDeployHook.Stage, err
+		}
+	}
+
+	return "", nil
+}
+
+// runFailureHook runs the failure hook of a project, if it has one, after a deployment stage failed.
+// Its own failure is only reported, the deployment already failed.
+func runFailureHook(p Project, env []string, out io.Writer) {
+	if !hookExists(p, failureDeployHook) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), failureHookTimeout)
+	defer cancel()
+
+	fmt.Fprintln(out, "Running failure hook...")
+	if err := runDeployScript(ctx, p, failureDeployHook.Stage, failureDeployHook.Ready, env, out); err != nil {
+		fmt.Fprintf(out, "Failure hook failed: %v\n", err)
+	}
 }
 
 func RundDeplyDown(projectNOI string) (string, error) {
@@ -36,14 +402,8 @@ func RundDeplyDown(projectNOI string) (string, error) {
 		return "", fmt.Errorf("failed to find project: %w", err)
 	}
 
-	cmd := exec.Command("docker", "compose", "-f", "mole-compose-ready.yaml", "down")
-	cmd.Dir = path.Join(consts.GetBasePath(), "projects", p.Name)
-
 	var output bytes.Buffer
-	cmd.Stdout = &output
-	cmd.Stderr = &output
-
-	err = cmd.Run()
+	err = runCompose(p, composeEnv(p), composeArgs(p, p.ActiveSlot, "down"), ComposeIO{Stdout: &output, Stderr: &output})
 	if err != nil {
 		return output.String(), fmt.Errorf("Failed to down the project: %w", err)
 	}
@@ -51,56 +411,120 @@ func RundDeplyDown(projectNOI string) (string, error) {
 	return output.String(), nil
 }
 
-// runDeploymentScript executes the mole-ready.sh script for the given project.
-// It captures and returns the entire output (both stdout and stderr).
-// The output is also written to a log file in the deploy_logs directory.
-func runDeploymentScript(p Project) (string, error) {
-	scriptPath := path.Join(consts.GetBasePath(), "projects", p.Name, "mole-ready.sh")
-	logsDir := path.Join(consts.GetBasePath(), "deploy_logs")
-	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+// runDeployScript executes a transformed script of the given project, such as mole-ready.sh.
+// Its output (both stdout and stderr) is written to out as it is produced.
+// The script and everything it started are killed when ctx expires.
+func runDeployScript(ctx context.Context, p Project, stage, script string, env []string, out io.Writer) error {
+	scriptPath := path.Join(consts.GetBasePath(), "projects", p.Name, script)
 
-	if err := os.MkdirAll(logsDir, 0755); err != nil {
-		return "", fmt.Errorf("failed to create logs directory: %w", err)
+	if _, err := os.Stat(scriptPath); os.IsNotExist(err) {
+		return fmt.Errorf("deployment script not found at %s", scriptPath)
 	}
 
-	if _, err := os.Stat(scriptPath); os.IsNotExist(err) {
-		logFile := path.Join(logsDir, fmt.Sprintf("%s-%s-failure.log", timestamp, p.Name))
-		writeLog(logFile, "Deployment script not found")
-		return "", fmt.Errorf("deployment script not found at %s", scriptPath)
+	if deployCancelled(p.Name) {
+		return ErrDeploymentCancelled
 	}
 
-	cmd := exec.Command("/bin/bash", scriptPath)
+	cmd := exec.CommandContext(ctx, "/bin/bash", scriptPath)
 	cmd.Dir = path.Join(consts.GetBasePath(), "projects", p.Name) // Set the working directory to the project folder
+	cmd.Env = append(os.Environ(), append(env, "MOLE_STAGE="+stage)...)
+	// Run the script in its own process group so cancelling reaches everything it started
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.Stdout = out
+	cmd.Stderr = out
 
-	var output bytes.Buffer
-	cmd.Stdout = &output
-	cmd.Stderr = &output
+	err := cmd.Start()
+	if err == nil {
+		updateDeployState(p.Name, func(st *DeployState) {
+			st.PID = cmd.Process.Pid
+			st.Stage = stage
+		})
+		err = cmd.Wait()
+	}
+
+	if err != nil && deployCancelled(p.Name) {
+		return fmt.Errorf("%w: %w", ErrDeploymentCancelled, err)
+	}
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%s: %w", stage, ErrDeploymentTimedOut)
+	}
+	if err != nil {
+		return fmt.Errorf("%s script failed: %w", stage, err)
+	}
 
-	fmt.Println("Deploying, this might take a while...")
+	return nil
+}
+
+// createDeployLog creates the log file a deployment writes to while it runs.
+func createDeployLog(p Project, d Deployment) (*os.File, error) {
+	logsDir := path.Join(consts.GetBasePath(), "deploy_logs")
 
-	err := cmd.Run()
-	logFile := path.Join(logsDir, fmt.Sprintf("%s-%s-%s.log", timestamp, p.Name, status(err)))
+	if err := os.MkdirAll(logsDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create logs directory: %w", err)
+	}
 
-	writeLog(logFile, output.String())
+	logPath := path.Join(logsDir, fmt.Sprintf("%d-%s-%s-running.log", d.StartedAt.Unix(), p.Name, d.DeploymentID))
 
+	f, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
 	if err != nil {
-		return output.String(), fmt.Errorf("deployment script failed: %w", err)
+		return nil, fmt.Errorf("failed to create deployment log: %w", err)
 	}
 
-	return output.String(), nil
+	return f, nil
+}
+
+// finishDeployLog renames the log of a finished deployment after its status and returns its new path.
+func finishDeployLog(d Deployment) string {
+	logPath := strings.TrimSuffix(d.LogPath, "running.log") + d.Status + ".log"
+
+	if err := os.Rename(d.LogPath, logPath); err != nil {
+		fmt.Printf("Failed to rename log file: %s, Error: %v\n", d.LogPath, err)
+		return d.LogPath
+	}
+
+	return logPath
 }
 
-// status returns "success" or "failure" based on the error value.
+// bestEffortWriter ignores write errors, so a disconnected terminal does not interrupt a deployment.
+type bestEffortWriter struct {
+	w io.Writer
+}
+
+func (b bestEffortWriter) Write(p []byte) (int, error) {
+	b.w.Write(p)
+	return len(p), nil
+}
+
+// refName names what a deployment checks out, for deploy output.
+func refName(p Project, opts DeployOptions) string {
+	if opts.Ref != "" {
+		return opts.Ref
+	}
+	return p.Branch
+}
+
+// status returns "success", "cancelled" or "failure" based on the error value.
 func status(err error) string {
+	if errors.Is(err, ErrDeploymentCancelled) {
+		return "cancelled"
+	}
 	if err != nil {
 		return "failure"
 	}
 	return "success"
 }
 
-// writeLog writes the given content to the specified log file.
-func writeLog(filePath, content string) {
-	if err := os.WriteFile(filePath, []byte(content), 0644); err != nil {
-		fmt.Printf("Failed to write log file: %s, Error: %v\n", filePath, err)
+// exitCode extracts the exit code of a finished script, -1 if it could not be run at all.
+func exitCode(err error) int {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	if err != nil {
+		return -1
 	}
+	return 0
 }
@@ -0,0 +1,68 @@
+package actions
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// StreamDeploymentLog writes the log of the project's running deployment, or of its latest one, to w.
+// With follow set, output of a running deployment is written as it arrives until the deployment finishes.
+func StreamDeploymentLog(projectNOI string, follow bool, w io.Writer) error {
+	p, err := FindProject(projectNOI)
+	if err != nil {
+		return err
+	}
+
+	st := projectDeployState(p.Name)
+	if !st.Running || st.LogPath == "" {
+		h, err := readDeploymentHistory(p.Name)
+		if err != nil {
+			return err
+		}
+
+		if len(h.Deployments) == 0 {
+			return fmt.Errorf("no deployments recorded for %s", p.Name)
+		}
+
+		return copyLog(h.Deployments[len(h.Deployments)-1].LogPath, w)
+	}
+
+	f, err := os.Open(st.LogPath)
+	if err != nil {
+		return fmt.Errorf("failed to open deployment log: %w", err)
+	}
+	defer f.Close()
+
+	for {
+		if _, err := io.Copy(w, f); err != nil {
+			return err
+		}
+
+		if !follow {
+			return nil
+		}
+
+		current := projectDeployState(p.Name)
+		if !current.Running || current.DeploymentID != st.DeploymentID {
+			// the log is complete once the deployment released its lock, write whatever is left
+			_, err := io.Copy(w, f)
+			return err
+		}
+
+		time.Sleep(250 * time.Millisecond)
+	}
+}
+
+// copyLog writes the log file at logPath to w.
+func copyLog(logPath string, w io.Writer) error {
+	f, err := os.Open(logPath)
+	if err != nil {
+		return fmt.Errorf("failed to open deployment log: %w", err)
+	}
+	defer f.Close()
+
+	_, err = io.Copy(w, f)
+	return err
+}
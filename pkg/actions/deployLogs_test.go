@@ -0,0 +1,54 @@
+package actions
+
+import (
+	"bytes"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zulubit/mole/pkg/consts"
+)
+
+func TestStreamDeploymentLogFollow(t *testing.T) {
+	consts.Testing = true
+
+	tmp := t.TempDir()
+	consts.BasePath = tmp
+
+	projectName := "test-project"
+	np := Project{
+		Name: projectName,
+	}
+	addProject(np)
+
+	err := createProjectSecretsJson(np)
+	assert.Nil(t, err, "Failed to setup project secrets")
+
+	err = StreamDeploymentLog(projectName, false, &bytes.Buffer{})
+	assert.ErrorContains(t, err, "no deployments recorded", "there is no log before the first deployment")
+
+	projectDir := path.Join(tmp, "projects", projectName)
+	os.MkdirAll(projectDir, 0755)
+	err = os.WriteFile(path.Join(projectDir, "mole.sh"), []byte("#!/bin/bash\necho building\nsleep 1\necho built"), 0755)
+	assert.Nil(t, err, "mole.sh written")
+
+	done := make(chan error)
+	go func() {
+		_, err := RunDeployment(projectName, DeployOptions{})
+		done <- err
+	}()
+
+	waitFor(t, func() bool {
+		states, err := DeploymentStatus(projectName)
+		return err == nil && states[0].Running && states[0].PID != 0
+	})
+
+	var followed bytes.Buffer
+	err = StreamDeploymentLog(projectName, true, &followed)
+	assert.Nil(t, err, "running deployment can be followed")
+	assert.Nil(t, <-done, "deployment succeeds")
+
+	assert.Contains(t, followed.String(), "building", "output written before attaching is shown")
+	assert.Contains(t, followed.String(), "built", "output written after attaching is streamed")
+}
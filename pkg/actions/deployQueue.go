@@ -0,0 +1,311 @@
+package actions
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gofrs/flock"
+	"github.com/zulubit/mole/pkg/consts"
+	"github.com/zulubit/mole/pkg/helpers"
+)
+
+// ErrDeploymentQueued is returned when a deployment is coalesced into one that is already waiting.
+var ErrDeploymentQueued = errors.New("a deployment is already queued")
+
+// ErrDeploymentCancelled is returned when a running deployment was cancelled.
+var ErrDeploymentCancelled = errors.New("deployment was cancelled")
+
+// DeployState describes the deployment a project is currently running.
+type DeployState struct {
+	ProjectName  string    `json:"projectName"`
+	DeploymentID string    `json:"deploymentId"`
+	PID          int       `json:"pid"`
+	Stage        string    `json:"stage"`
+	StartedAt    time.Time `json:"startedAt"`
+	LogPath      string    `json:"logPath"`
+	Cancelled    bool      `json:"cancelled"`
+	Running      bool      `json:"running"`
+	Queued       bool      `json:"queued"`
+}
+
+// getDeployLocksPath returns the directory holding deploy locks and state files.
+func getDeployLocksPath() string {
+	return path.Join(consts.GetBasePath(), "deploy_locks")
+}
+
+// getDeployStatePath returns the path to the state file of the project's running deployment.
+func getDeployStatePath(projectName string) string {
+	return path.Join(getDeployLocksPath(), projectName+".json")
+}
+
+// getDeployLockPath returns the path to a lock of the project's deployments, named by kind:
+//   - "queue" and "run" are the locks deployments compete for, only one waits and one runs at a time
+//   - "queued" and "running" mark the deployment holding them, for the status to probe without taking the others
+//   - "state" guards the state file of the running deployment
+func getDeployLockPath(projectName, kind string) string {
+	if kind == "run" {
+		return path.Join(getDeployLocksPath(), projectName+".lock")
+	}
+	return path.Join(getDeployLocksPath(), projectName+"."+kind+".lock")
+}
+
+// deployLock is held by the running deployment of a project.
+type deployLock struct {
+	run     *flock.Flock
+	running *flock.Flock
+}
+
+// acquireDeployLock waits until no other deployment of the project is running and takes its deploy lock.
+// Only one deployment waits at a time. When coalesce is set and another deployment is already waiting,
+// ErrDeploymentQueued is returned instead, as the waiting deployment fetches the latest commits anyway.
+// While waiting, a message is written to out.
+func acquireDeployLock(projectName string, coalesce bool, out io.Writer) (*deployLock, error) {
+	if err := os.MkdirAll(getDeployLocksPath(), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create deploy locks directory: %w", err)
+	}
+
+	queueLock := flock.New(getDeployLockPath(projectName, "queue"))
+	if coalesce {
+		locked, err := queueLock.TryLock()
+		if err != nil {
+			return nil, err
+		}
+		if !locked {
+			return nil, ErrDeploymentQueued
+		}
+	} else if err := queueLock.Lock(); err != nil {
+		return nil, err
+	}
+	defer queueLock.Unlock()
+
+	runLock := flock.New(getDeployLockPath(projectName, "run"))
+	locked, err := runLock.TryLock()
+	if err != nil {
+		return nil, err
+	}
+
+	if !locked {
+		// the markers are only probed with TryLock, so taking them never waits long
+		queued := flock.New(getDeployLockPath(projectName, "queued"))
+		if err := queued.Lock(); err != nil {
+			return nil, err
+		}
+		defer queued.Unlock()
+
+		fmt.Fprintf(out, "Another deployment of %s is running, waiting for it to finish...\n", projectName)
+		if err := runLock.Lock(); err != nil {
+			return nil, err
+		}
+	}
+
+	running := flock.New(getDeployLockPath(projectName, "running"))
+	if err := running.Lock(); err != nil {
+		runLock.Unlock()
+		return nil, err
+	}
+
+	return &deployLock{run: runLock, running: running}, nil
+}
+
+// releaseDeployLock clears the project's deploy state and releases its deploy lock.
+func releaseDeployLock(projectName string, l *deployLock) {
+	if stateLock, err := lockDeployState(projectName); err == nil {
+		os.Remove(getDeployStatePath(projectName))
+		stateLock.Unlock()
+	}
+	l.running.Unlock()
+	l.run.Unlock()
+}
+
+// isLocked reports whether someone else holds the lock file at lockPath.
+// Only the marker locks are probed, probing takes the lock for a moment.
+func isLocked(lockPath string) bool {
+	l := flock.New(lockPath)
+	locked, err := l.TryLock()
+	if err != nil {
+		return false
+	}
+	if locked {
+		l.Unlock()
+		return false
+	}
+	return true
+}
+
+// readDeployState reads the state of the project's running deployment.
+func readDeployState(projectName string) (DeployState, error) {
+	f, err := os.ReadFile(getDeployStatePath(projectName))
+	if err != nil {
+		return DeployState{}, err
+	}
+
+	var st DeployState
+	if err := json.Unmarshal(f, &st); err != nil {
+		return DeployState{}, fmt.Errorf("failed to unmarshal deploy state: %w", err)
+	}
+
+	return st, nil
+}
+
+// lockDeployState takes the lock guarding the state file of the project's running deployment.
+func lockDeployState(projectName string) (*flock.Flock, error) {
+	fileLock, locked, err := lockWithTimeout(getDeployLockPath(projectName, "state"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock the deploy state of %s: %w", projectName, err)
+	}
+	if !locked {
+		return nil, fmt.Errorf("someone else is working with the deploy state of %s, gave up after %s, please try again", projectName, projectStoreLockTimeout)
+	}
+	return fileLock, nil
+}
+
+// writeDeployState stores the state of the project's running deployment.
+func writeDeployState(st DeployState) error {
+	stateLock, err := lockDeployState(st.ProjectName)
+	if err != nil {
+		return err
+	}
+	defer stateLock.Unlock()
+
+	return saveDeployState(st)
+}
+
+// saveDeployState writes the state file of the project's running deployment, the caller holds the state lock.
+func saveDeployState(st DeployState) error {
+	f, err := json.MarshalIndent(st, "", " ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal deploy state: %w", err)
+	}
+
+	if err := helpers.WriteFileAtomic(getDeployStatePath(st.ProjectName), f, 0644); err != nil {
+		return fmt.Errorf("failed to write deploy state: %w", err)
+	}
+
+	return nil
+}
+
+// updateDeployState applies fn to the state of the project's running deployment,
+// holding the state lock from reading to writing so concurrent updates are not lost.
+func updateDeployState(projectName string, fn func(*DeployState)) error {
+	stateLock, err := lockDeployState(projectName)
+	if err != nil {
+		return err
+	}
+	defer stateLock.Unlock()
+
+	st, err := readDeployState(projectName)
+	if err != nil {
+		return err
+	}
+
+	fn(&st)
+
+	return saveDeployState(st)
+}
+
+// projectDeployState returns the deployment state of a single project.
+func projectDeployState(projectName string) DeployState {
+	st := DeployState{ProjectName: projectName}
+
+	st.Running = isLocked(getDeployLockPath(projectName, "running"))
+	if st.Running {
+		if running, err := readDeployState(projectName); err == nil {
+			st = running
+			st.Running = true
+		}
+	}
+
+	st.Queued = isLocked(getDeployLockPath(projectName, "queued"))
+
+	return st
+}
+
+// DeploymentStatus reports running and queued deployments of a project, or of all projects when projectNOI is empty.
+func DeploymentStatus(projectNOI string) ([]DeployState, error) {
+	if projectNOI != "" {
+		p, err := FindProject(projectNOI)
+		if err != nil {
+			return nil, err
+		}
+		return []DeployState{projectDeployState(p.Name)}, nil
+	}
+
+	ps, err := readProjectsFromFile()
+	if err != nil {
+		return nil, err
+	}
+
+	states := []DeployState{}
+	for _, p := range ps.Projects {
+		states = append(states, projectDeployState(p.Name))
+	}
+
+	return states, nil
+}
+
+// CancelDeployment stops the running deployment of a project by signalling the process group of its script.
+// SIGTERM is sent unless force is set, in which case the scripts are killed.
+func CancelDeployment(projectNOI string, force bool) error {
+	p, err := FindProject(projectNOI)
+	if err != nil {
+		return err
+	}
+
+	st := projectDeployState(p.Name)
+	if !st.Running {
+		return fmt.Errorf("no deployment of %s is running", p.Name)
+	}
+
+	if err := updateDeployState(p.Name, func(st *DeployState) { st.Cancelled = true }); err != nil {
+		return err
+	}
+
+	if st.PID == 0 {
+		// the script has not started yet, the deployment stops before running it
+		return nil
+	}
+
+	sig := syscall.SIGTERM
+	if force {
+		sig = syscall.SIGKILL
+	}
+
+	if err := syscall.Kill(-st.PID, sig); err != nil && !errors.Is(err, syscall.ESRCH) {
+		return fmt.Errorf("failed to signal deployment %s: %w", st.DeploymentID, err)
+	}
+
+	return nil
+}
+
+// deployCancelled reports whether the running deployment of the project was cancelled.
+func deployCancelled(projectName string) bool {
+	st, err := readDeployState(projectName)
+	return err == nil && st.Cancelled
+}
+
+// Stringify returns a string representation of the DeployState.
+func (st DeployState) Stringify() string {
+	var b strings.Builder
+	b.WriteString("\n")
+	b.WriteString(" |Project    : " + st.ProjectName + "\n")
+	if !st.Running {
+		b.WriteString(" |Status     : idle\n")
+	} else {
+		b.WriteString(" |Status     : " + st.Stage + "\n")
+		b.WriteString(" |Deployment : " + st.DeploymentID + "\n")
+		b.WriteString(" |PID        : " + strconv.Itoa(st.PID) + "\n")
+		b.WriteString(" |Running for: " + time.Since(st.StartedAt).Round(time.Second).String() + "\n")
+	}
+	if st.Queued {
+		b.WriteString(" |Queued     : another deployment is waiting\n")
+	}
+	return b.String()
+}
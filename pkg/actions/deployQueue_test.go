@@ -0,0 +1,181 @@
+package actions
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zulubit/mole/pkg/consts"
+)
+
+// waitFor polls cond until it holds or the timeout expires.
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition was not met in time")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestAcquireDeployLock(t *testing.T) {
+	consts.Testing = true
+
+	tmp := t.TempDir()
+	consts.BasePath = tmp
+
+	first, err := acquireDeployLock("test-project", true, io.Discard)
+	assert.Nil(t, err, "first deployment takes the lock")
+
+	acquired := make(chan bool)
+	released := make(chan bool)
+	go func() {
+		second, err := acquireDeployLock("test-project", true, io.Discard)
+		assert.Nil(t, err, "second deployment waits for the lock")
+		acquired <- true
+		releaseDeployLock("test-project", second)
+		released <- true
+	}()
+
+	waitFor(t, func() bool { return projectDeployState("test-project").Queued })
+
+	_, err = acquireDeployLock("test-project", true, io.Discard)
+	assert.ErrorIs(t, err, ErrDeploymentQueued, "third deployment is coalesced into the queued one")
+
+	other, err := acquireDeployLock("other-project", true, io.Discard)
+	assert.Nil(t, err, "other projects are not blocked")
+	releaseDeployLock("other-project", other)
+
+	select {
+	case <-acquired:
+		t.Fatal("queued deployment must wait for the running one")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	releaseDeployLock("test-project", first)
+	assert.True(t, <-acquired, "queued deployment runs once the lock is released")
+	<-released
+}
+
+func TestCancelDeployment(t *testing.T) {
+	consts.Testing = true
+
+	tmp := t.TempDir()
+	consts.BasePath = tmp
+
+	projectName := "test-project"
+	np := Project{
+		Name: projectName,
+	}
+	addProject(np)
+
+	err := createProjectSecretsJson(np)
+	assert.Nil(t, err, "Failed to setup project secrets")
+
+	projectDir := path.Join(tmp, "projects", projectName)
+	os.MkdirAll(projectDir, 0755)
+	err = os.WriteFile(path.Join(projectDir, "mole.sh"), []byte("#!/bin/bash\necho started\nsleep 30\necho completed"), 0755)
+	assert.Nil(t, err, "mole.sh written")
+
+	err = CancelDeployment(projectName, false)
+	assert.ErrorContains(t, err, "no deployment of test-project is running", "nothing to cancel")
+
+	done := make(chan error)
+	go func() {
+		_, err := RunDeployment(projectName, DeployOptions{})
+		done <- err
+	}()
+
+	waitFor(t, func() bool {
+		states, err := DeploymentStatus(projectName)
+		return err == nil && states[0].Running && states[0].PID != 0
+	})
+
+	started := time.Now()
+	err = CancelDeployment(projectName, false)
+	assert.Nil(t, err, "running deployment can be cancelled")
+
+	err = <-done
+	assert.ErrorIs(t, err, ErrDeploymentCancelled, "deployment reports cancellation")
+	assert.Less(t, time.Since(started), 10*time.Second, "script did not run to completion")
+
+	deployments, err := ListDeployments(projectName)
+	assert.Nil(t, err, "history can be listed")
+	assert.Equal(t, "cancelled", deployments[0].Status, "cancelled deployment is recorded")
+
+	var log bytes.Buffer
+	err = StreamDeploymentLog(projectName, false, &log)
+	assert.Nil(t, err, "log of the cancelled deployment can be read")
+	assert.Contains(t, log.String(), "started", "log contains output written before cancellation")
+	assert.NotContains(t, log.String(), "completed", "script was stopped")
+
+	states, err := DeploymentStatus("")
+	assert.Nil(t, err, "status of all projects can be read")
+	assert.False(t, states[0].Running, "no deployment is running after cancellation")
+}
+
+func TestDeployStatusDoesNotBlockDeployments(t *testing.T) {
+	consts.Testing = true
+	consts.BasePath = t.TempDir()
+
+	stop := make(chan bool)
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					projectDeployState("test-project")
+				}
+			}
+		}()
+	}
+
+	for range 500 {
+		l, err := acquireDeployLock("test-project", true, io.Discard)
+		if !assert.Nil(t, err, "probing the status never makes a deployment look queued") {
+			break
+		}
+		releaseDeployLock("test-project", l)
+	}
+	close(stop)
+	wg.Wait()
+}
+
+func TestUpdateDeployStateIsSerialized(t *testing.T) {
+	consts.Testing = true
+	consts.BasePath = t.TempDir()
+	os.MkdirAll(getDeployLocksPath(), 0755)
+
+	err := writeDeployState(DeployState{ProjectName: "test-project", Stage: "preparing"})
+	assert.Nil(t, err, "deploy state written")
+
+	var wg sync.WaitGroup
+	for i := range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 50 {
+				updateDeployState("test-project", func(st *DeployState) { st.PID = i })
+			}
+		}()
+	}
+	time.Sleep(5 * time.Millisecond)
+	err = updateDeployState("test-project", func(st *DeployState) { st.Cancelled = true })
+	assert.Nil(t, err, "deployment cancelled")
+	wg.Wait()
+
+	st, _ := readDeployState("test-project")
+	assert.True(t, st.Cancelled, "the cancellation is not lost to concurrent updates")
+}
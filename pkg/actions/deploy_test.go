@@ -1,9 +1,11 @@
 package actions
 
 import (
+	"bytes"
 	"os"
 	"path"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/zulubit/mole/pkg/consts"
@@ -13,7 +15,7 @@ func TestPrepareDeployment(t *testing.T) {
 	consts.Testing = true
 
 	// Create a temporary directory for testing
-	tmp := os.TempDir()
+	tmp := t.TempDir()
 	consts.BasePath = tmp
 	defer os.RemoveAll(tmp)
 
@@ -37,7 +39,7 @@ func TestPrepareDeployment(t *testing.T) {
 	}
 
 	// Simulate missing .env file and check for failure
-	_, err = RunDeployment(projectName)
+	_, err = RunDeployment(projectName, DeployOptions{})
 	assert.NotNil(t, err, "deployment should fail due to missing .env file")
 
 	// Create a placeholder `mole-ready.sh` file
@@ -47,8 +49,127 @@ func TestPrepareDeployment(t *testing.T) {
 		t.Fatalf("failed to write mole-ready.sh: %v", err)
 	}
 
+	// Secrets required by mole.yaml have to be set
+	err = os.WriteFile(path.Join(projectDir, "mole.yaml"), []byte("secrets:\n  - API_TOKEN\n"), 0644)
+	assert.Nil(t, err, "mole.yaml written")
+	_, err = RunDeployment(projectName, DeployOptions{})
+	assert.ErrorContains(t, err, "requires the secrets API_TOKEN", "deployment fails without the required secrets")
+	err = SetProjectSecret(projectName, "API_TOKEN", "token")
+	assert.Nil(t, err, "required secret set")
+
 	// Test successful deployment
-	output, err := RunDeployment(projectName)
+	var output bytes.Buffer
+	res, err := RunDeployment(projectName, DeployOptions{Output: &output})
 	assert.Nil(t, err, "deployment should be prepared and executed without error")
-	assert.Contains(t, output, "Deployment script running", "output should indicate that the deployment script ran")
+	assert.Contains(t, output.String(), "Deployment script running", "output should indicate that the deployment script ran")
+	assert.Contains(t, res.Summary(), "finished with status success", "summary should report the deployment status")
+	assert.Equal(t, "success", res.Deployment.Status, "result contains the finished deployment")
+
+	// The output is written to the deployment log as well
+	deployments, err := ListDeployments(projectName)
+	assert.Nil(t, err, "deployments can be listed")
+	assert.Contains(t, deployments[0].LogPath, "-success.log", "log is named after the deployment status")
+
+	var log bytes.Buffer
+	err = StreamDeploymentLog(projectName, false, &log)
+	assert.Nil(t, err, "latest deployment log can be read")
+	assert.Contains(t, log.String(), "Deployment script running", "log should contain the script output")
+	assert.Regexp(t, `^\[\d\d:\d\d:\d\d\] `, log.String(), "log lines are timestamped")
+}
+
+func TestDeploymentHooks(t *testing.T) {
+	consts.Testing = true
+
+	tmp := t.TempDir()
+	consts.BasePath = tmp
+
+	projectName := "test-project"
+	np := Project{
+		Name: projectName,
+	}
+	addProject(np)
+
+	err := createProjectSecretsJson(np)
+	assert.Nil(t, err, "Failed to setup project secrets")
+
+	projectDir := path.Join(tmp, "projects", projectName)
+	os.MkdirAll(projectDir, 0755)
+
+	scripts := map[string]string{
+		"mole.sh":         "#!/bin/bash\necho \"deploy $MOLE_STAGE\"",
+		"mole.pre.sh":     "#!/bin/bash\necho \"pre $MOLE_STAGE\"",
+		"mole.post.sh":    "#!/bin/bash\necho \"post $MOLE_STAGE\"\nexit 3",
+		"mole.failure.sh": "#!/bin/bash\necho \"$MOLE_DEPLOYMENT_ID failed in $MOLE_FAILED_STAGE\" > failure.txt",
+	}
+	for name, content := range scripts {
+		err := os.WriteFile(path.Join(projectDir, name), []byte(content), 0755)
+		assert.Nil(t, err, name+" written")
+	}
+
+	var output bytes.Buffer
+	_, err = RunDeployment(projectName, DeployOptions{Output: &output})
+	assert.ErrorContains(t, err, "post script failed", "failing post hook fails the deployment")
+	assert.ErrorIs(t, err, ErrDeploymentFailed, "failed deployment is reported as such")
+	assert.Regexp(t, `(?s)pre pre.*deploy deploy.*post post`, output.String(), "hooks run around the deployment script")
+
+	deployments, err := ListDeployments(projectName)
+	assert.Nil(t, err, "deployments can be listed")
+	assert.Equal(t, "failure", deployments[0].Status, "deployment is recorded as failed")
+	assert.Equal(t, 3, deployments[0].ExitCode, "exit code of the failed hook is recorded")
+
+	marker, err := os.ReadFile(path.Join(projectDir, "failure.txt"))
+	assert.Nil(t, err, "failure hook ran")
+	assert.Equal(t, deployments[0].DeploymentID+" failed in post\n", string(marker), "failure hook knows the failed stage")
+
+	// removing a hook stops it from running
+	os.Remove(path.Join(projectDir, "mole.post.sh"))
+	os.Remove(path.Join(projectDir, "failure.txt"))
+
+	_, err = RunDeployment(projectName, DeployOptions{})
+	assert.Nil(t, err, "deployment succeeds without the post hook")
+	assert.NoFileExists(t, path.Join(projectDir, "failure.txt"), "failure hook does not run for successful deployments")
+}
+
+func TestDeploymentTimeout(t *testing.T) {
+	consts.Testing = true
+
+	tmp := t.TempDir()
+	consts.BasePath = tmp
+
+	projectName := "test-project"
+	np := Project{
+		Name: projectName,
+	}
+	addProject(np)
+
+	err := createProjectSecretsJson(np)
+	assert.Nil(t, err, "Failed to setup project secrets")
+
+	err = EditProject(projectName, "", "", "forever")
+	assert.ErrorContains(t, err, "invalid deploy timeout", "timeout must be a duration")
+
+	err = EditProject(projectName, "", "", "500ms")
+	assert.Nil(t, err, "timeout can be set")
+
+	projectDir := path.Join(tmp, "projects", projectName)
+	os.MkdirAll(projectDir, 0755)
+	err = os.WriteFile(path.Join(projectDir, "mole.sh"), []byte("#!/bin/bash\necho started\nsleep 30 &\nwait\necho completed"), 0755)
+	assert.Nil(t, err, "mole.sh written")
+	err = os.WriteFile(path.Join(projectDir, "mole.failure.sh"), []byte("#!/bin/bash\necho \"cleanup after $MOLE_FAILED_STAGE\""), 0755)
+	assert.Nil(t, err, "mole.failure.sh written")
+
+	var output bytes.Buffer
+	started := time.Now()
+	_, err = RunDeployment(projectName, DeployOptions{Output: &output})
+	assert.ErrorIs(t, err, ErrDeploymentTimedOut, "deployment exceeding the timeout is stopped")
+	assert.Less(t, time.Since(started), 10*time.Second, "script did not run to completion")
+	assert.NotContains(t, output.String(), "completed", "script was killed")
+	assert.Contains(t, output.String(), "cleanup after deploy", "failure hook runs after a timeout")
+
+	err = EditProject(projectName, "", "", "0")
+	assert.Nil(t, err, "timeout can be removed")
+
+	p, err := FindProject(projectName)
+	assert.Nil(t, err, "project can be found")
+	assert.Empty(t, p.DeployTimeout, "timeout was removed")
 }
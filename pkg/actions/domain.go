@@ -4,11 +4,9 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
-	"io"
-	"net/http"
 	"os"
 	"path"
-	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"text/template"
@@ -19,154 +17,327 @@ import (
 )
 
 type domainData struct {
-	Domain      string
+	Domain string
+	// Sites is the site address of the domain and its aliases.
+	Sites string
+	// Redirects is the site address of the domains redirected to the domain, empty without any.
+	Redirects string
+	// RedirectTo is the target of the redirects and Status their status code, with a leading space.
+	RedirectTo  string
+	Status      string
 	Port        string
 	Location    string
 	ProjectName string
+	Kind        string
+	Routes      []routeData
+	// ServesFiles reports whether the domain or one of its routes serves files of the project.
+	ServesFiles bool
+}
+
+// routeData is a route of a domain, with the port it forwards to resolved.
+type routeData struct {
+	Path     string
+	Kind     string
+	Port     string
+	Location string
 }
 
 type domainSetup struct {
 	Email string
 }
 
-// AddDomainProxy generates and adds a reverse proxy configuration for the specified domain and port.
-func AddDomainProxy(projectNOI, domain string, port int) error {
-	if !helpers.ValidateCaddyDomain(domain) {
-		return fmt.Errorf("invalid domain format: %s", domain)
-	}
+const proxyDomainTemplate = `{{if .Redirects}}{{.Redirects}} {
+    redir {{.RedirectTo}}{{.Status}}
+}
 
-	project, err := FindProject(projectNOI)
-	if err != nil {
-		return fmt.Errorf("failed to find project %s: %w", projectNOI, err)
-	}
+{{end}}{{.Sites}} {
+    reverse_proxy 127.0.0.1:{{.Port}}
+}`
 
-	var templatePort int
-	if port == 0 {
-		templatePort = readDefaultProtFromEnv(projectNOI)
-	} else {
-		templatePort = port
-	}
+const staticDomainTemplate = `{{if .Redirects}}{{.Redirects}} {
+    redir {{.RedirectTo}}{{.Status}}
+}
+
+{{end}}{{.Sites}} {
+    root * /home/mole/projects/{{.ProjectName}}/{{.Location}}
+    file_server
 
-	domainTemplate := `www.{{.Domain}} {
-    redir https://{{.Domain}}{uri}
+    encode gzip zstd
+
+    @htmlFiles {
+        file {
+            try_files {path}.html
+        }
+    }
+
+    @blockedFiles {
+        path *.env
+    }
+    respond @blockedFiles 403
+
+    rewrite @htmlFiles {path}.html
+}`
+
+// routedDomainTemplate renders a domain with routes, each route is a handle block and the domain itself is the last one.
+const routedDomainTemplate = `{{if .Redirects}}{{.Redirects}} {
+    redir {{.RedirectTo}}{{.Status}}
 }
 
-{{.Domain}} {
-    reverse_proxy 127.0.0.1:{{.Port}}
+{{end}}{{.Sites}} {
+{{- if .ServesFiles}}
+    encode gzip zstd
+
+    @blockedFiles {
+        path *.env
+    }
+    respond @blockedFiles 403
+{{end}}
+{{- range .Routes}}
+    handle {{.Path}} {
+{{- if eq .Kind "static"}}
+        root * /home/mole/projects/{{$.ProjectName}}/{{.Location}}
+        file_server
+{{- else}}
+        reverse_proxy 127.0.0.1:{{.Port}}
+{{- end}}
+    }
+{{end}}
+    handle {
+{{- if eq .Kind "static"}}
+        root * /home/mole/projects/{{.ProjectName}}/{{.Location}}
+        file_server
+
+        @htmlFiles {
+            file {
+                try_files {path}.html
+            }
+        }
+        rewrite @htmlFiles {path}.html
+{{- else}}
+        reverse_proxy 127.0.0.1:{{.Port}}
+{{- end}}
+    }
 }`
 
+// redirectDomainTemplate renders a domain that only redirects, all of its hosts are a single site.
+const redirectDomainTemplate = `{{.Sites}} {
+    redir {{.RedirectTo}}{{.Status}}
+}`
+
+// getDomainPartialPath returns the path to the Caddy partial of a project.
+func getDomainPartialPath(projectName string) string {
+	return path.Join(consts.GetBasePath(), "domains", projectName+".caddy")
+}
+
+// AddDomainProxy adds a domain to the project that proxies to the port, see AddDomain.
+// With port 0, the domain proxies to MOLE_PORT_APP, or to the live slot of a blue/green project.
+func AddDomainProxy(projectNOI, domain string, port int) error {
+	return AddDomain(projectNOI, Domain{Domain: domain, Kind: DomainProxy, Port: port})
+}
+
+// AddDomainStatic adds a domain to the project that serves the files in location, see AddDomain.
+func AddDomainStatic(projectNOI, domain, location string) error {
+	return AddDomain(projectNOI, Domain{Domain: domain, Kind: DomainStatic, Location: location})
+}
+
+// renderDomain renders the Caddy site blocks of a domain of a project, proxy domains forward to port.
+// routes are the routes of the domain with their ports resolved.
+func renderDomain(projectName string, d Domain, port int, routes []routeData) (string, error) {
+	domainTemplate := proxyDomainTemplate
+	if d.Kind == DomainStatic {
+		domainTemplate = staticDomainTemplate
+	}
+	if len(routes) > 0 {
+		domainTemplate = routedDomainTemplate
+	}
+
 	domainData := domainData{
-		Domain: domain,
-		Port:   strconv.Itoa(templatePort),
+		Domain:      d.Domain,
+		Sites:       strings.Join(d.siteHosts(), ", "),
+		Redirects:   strings.Join(d.redirectHosts(), ", "),
+		RedirectTo:  d.redirectTarget(),
+		Port:        strconv.Itoa(port),
+		Location:    d.Location,
+		ProjectName: projectName,
+		Routes:      routes,
+		Kind:        d.Kind,
+		ServesFiles: d.Kind == DomainStatic,
+	}
+	for _, r := range routes {
+		domainData.ServesFiles = domainData.ServesFiles || r.Kind == DomainStatic
+	}
+	if d.RedirectStatus != 0 {
+		domainData.Status = " " + strconv.Itoa(d.RedirectStatus)
+	}
+	if d.Kind == DomainRedirect {
+		domainTemplate = redirectDomainTemplate
+		domainData.Sites = strings.Join(d.hosts(), ", ")
 	}
 
-	templateInstance, err := template.New("proxy").Parse(domainTemplate)
+	templateInstance, err := template.New(d.Kind).Parse(domainTemplate)
 	if err != nil {
-		return fmt.Errorf("failed to parse proxy template: %w", err)
+		return "", fmt.Errorf("failed to parse %s template: %w", d.Kind, err)
 	}
 
 	var configBuffer bytes.Buffer
 	if err := templateInstance.Execute(&configBuffer, domainData); err != nil {
-		return fmt.Errorf("failed to execute template for domain %s: %w", domain, err)
+		return "", fmt.Errorf("failed to execute template for domain %s: %w", d.Domain, err)
 	}
 
-	domainFilePath := path.Join(consts.GetBasePath(), "domains", project.Name+".caddy")
-	domainDirPath := path.Join(consts.GetBasePath(), "domains")
+	return configBuffer.String(), nil
+}
 
+// renderProjectDomains renders all domains of a project into its Caddy partial, the partial is removed when it has none.
+func renderProjectDomains(p Project) error {
+	domainFilePath := getDomainPartialPath(p.Name)
+
+	if len(p.Domains) == 0 {
+		if err := os.Remove(domainFilePath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to delete project domain configuration %s: %w", domainFilePath, err)
+		}
+		return nil
+	}
+
+	blocks := make([]string, len(p.Domains))
+	for i, d := range p.Domains {
+		routes := make([]routeData, len(d.Routes))
+		for j, r := range d.Routes {
+			routes[j] = routeData{Path: r.Path, Kind: r.Kind, Location: r.Location}
+			if r.Kind == DomainProxy {
+				routes[j].Port = strconv.Itoa(routePort(p, r.Port))
+			}
+		}
+
+		block, err := renderDomain(p.Name, d, upstreamPort(p, d.Port), routes)
+		if err != nil {
+			return err
+		}
+		blocks[i] = block
+	}
+
+	domainDirPath := path.Join(consts.GetBasePath(), "domains")
 	if err := os.MkdirAll(domainDirPath, 0755); err != nil {
 		return fmt.Errorf("failed to create directory %s: %w", domainDirPath, err)
 	}
 
-	if err := os.WriteFile(domainFilePath, configBuffer.Bytes(), 0644); err != nil {
+	if err := helpers.WriteFileAtomic(domainFilePath, []byte(strings.Join(blocks, "\n\n")), 0644); err != nil {
 		return fmt.Errorf("failed to write domain configuration file %s: %w", domainFilePath, err)
 	}
 
 	return nil
 }
 
-func readDefaultProtFromEnv(projectNOI string) int {
-	p, err := FindProject(projectNOI)
-	if err != nil {
-		return 0
+// upstreamPort returns the port a proxy domain of a project forwards to. Port 0 is MOLE_PORT_APP.
+// For blue/green projects, 0 and the ports of both slots are the port of the live slot, so every domain follows a switch.
+func upstreamPort(p Project, port int) int {
+	if p.Strategy == StrategyBlueGreen {
+		secrets, err := readProjectSecrets(p.Name)
+		if err != nil {
+			return port
+		}
+		if port == 0 || port == secrets.PortApp || port == secrets.PortTwo {
+			return slotPort(secrets, p.ActiveSlot)
+		}
+		return port
 	}
 
-	projectEnv := path.Join(consts.GetBasePath(), "projects", p.Name, ".env")
-	env, err := godotenv.Read(projectEnv)
-	if err != nil {
-		return 0
+	if port == 0 {
+		return defaultPortFromEnv(p.Name)
 	}
+	return port
+}
 
-	port := env["MOLE_PORT_APP"]
-	i, err := strconv.Atoi(port)
-	if err != nil {
-		return 0
+// routePort returns the port a proxy route of a project forwards to, port is PortApp, PortTwo, PortThree or a number.
+// PortApp is the port of the domains with port 0, see upstreamPort.
+func routePort(p Project, port string) int {
+	switch port {
+	case "", "PortApp":
+		return upstreamPort(p, 0)
+	case "PortTwo", "PortThree":
+		secrets, err := readProjectSecrets(p.Name)
+		if err != nil {
+			return 0
+		}
+		if port == "PortTwo" {
+			return upstreamPort(p, secrets.PortTwo)
+		}
+		return upstreamPort(p, secrets.PortThree)
+	default:
+		n, _ := strconv.Atoi(port)
+		return upstreamPort(p, n)
 	}
-	return i
 }
 
-// AddDomainStatic creates a static file server configuration for a specified domain and location.
-func AddDomainStatic(projectNOI, domain, location string) error {
-	if !helpers.ValidateCaddyDomain(domain) {
-		return fmt.Errorf("invalid domain format: %s", domain)
+// applyDomainPartial updates the route of a project in the running Caddy after its partial was written or removed.
+func applyDomainPartial(projectName string) error {
+	if err := syncDomainRoute(projectName); err != nil {
+		return fmt.Errorf("domain configuration saved, but failed to apply it, run mole domains reload once Caddy is running: %w", err)
 	}
+	return nil
+}
 
-	project, err := FindProject(projectNOI)
-	if err != nil {
-		return fmt.Errorf("failed to find project %s: %w", projectNOI, err)
+// syncDomainRoute replaces the route of a project in the running Caddy with its partial, or removes it without one.
+var syncDomainRoute = func(projectName string) error {
+	if consts.Testing {
+		return nil
 	}
 
-	staticDomainTemplate := `www.{{.Domain}} {
-    redir https://{{.Domain}}{uri}
+	if _, err := os.Stat(getDomainPartialPath(projectName)); errors.Is(err, os.ErrNotExist) {
+		return removeCaddyRoute(projectName)
+	}
+	return updateCaddyRoute(projectName)
 }
 
-{{.Domain}} {
-    root * /home/mole/projects/{{.ProjectName}}/{{.Location}}
-    file_server
-
-    encode gzip zstd
-
-    @htmlFiles {
-        file {
-            try_files {path}.html
-        }
-    }
-
-    @blockedFiles {
-        path *.env
-    }
-    respond @blockedFiles 403
-
-    rewrite @htmlFiles {path}.html
-}`
-
-	domainData := domainData{
-		Domain:      domain,
-		Location:    location,
-		ProjectName: project.Name,
+// configuredDomains returns the domains a project is served on, leaving out the domains that only redirect to one of them.
+// Partials mole does not manage the domains of are read instead.
+func configuredDomains(p Project) []string {
+	if len(p.Domains) > 0 {
+		domains := []string{}
+		for _, d := range p.Domains {
+			if d.Kind != DomainRedirect {
+				domains = append(domains, d.siteHosts()...)
+			}
+		}
+		return domains
 	}
 
-	templateInstance, err := template.New("static").Parse(staticDomainTemplate)
+	content, err := os.ReadFile(getDomainPartialPath(p.Name))
 	if err != nil {
-		return fmt.Errorf("failed to parse static template: %w", err)
+		return []string{}
 	}
 
-	var configBuffer bytes.Buffer
-	if err := templateInstance.Execute(&configBuffer, domainData); err != nil {
-		return fmt.Errorf("failed to execute template for static domain %s: %w", domain, err)
+	sites := []string{}
+	for _, line := range strings.Split(string(content), "\n") {
+		// site blocks start at the beginning of a line, matchers and directives inside them are indented
+		if site, ok := strings.CutSuffix(line, " {"); ok && site != "" && !strings.ContainsAny(site[:1], " \t") {
+			sites = append(sites, strings.Split(site, ", ")...)
+		}
 	}
 
-	domainFilePath := path.Join(consts.GetBasePath(), "domains", project.Name+".caddy")
-	domainDirPath := path.Join(consts.GetBasePath(), "domains")
-
-	if err := os.MkdirAll(domainDirPath, 0755); err != nil {
-		return fmt.Errorf("failed to create directory %s: %w", domainDirPath, err)
+	domains := []string{}
+	for _, site := range sites {
+		if apex, ok := strings.CutPrefix(site, "www."); ok && slices.Contains(sites, apex) {
+			continue
+		}
+		domains = append(domains, site)
 	}
+	return domains
+}
 
-	if err := os.WriteFile(domainFilePath, configBuffer.Bytes(), 0644); err != nil {
-		return fmt.Errorf("failed to write static domain configuration file %s: %w", domainFilePath, err)
+// defaultPortFromEnv returns MOLE_PORT_APP from the .env of a project, 0 if it is not set.
+func defaultPortFromEnv(projectName string) int {
+	projectEnv := path.Join(consts.GetBasePath(), "projects", projectName, ".env")
+	env, err := godotenv.Read(projectEnv)
+	if err != nil {
+		return 0
 	}
 
-	return nil
+	port := env["MOLE_PORT_APP"]
+	i, err := strconv.Atoi(port)
+	if err != nil {
+		return 0
+	}
+	return i
 }
 
 // SetupDomains initializes the main Caddy configuration, enabling domain support with TLS.
@@ -217,7 +388,8 @@ func SetupDomains(email string) error {
 	return nil
 }
 
-// DeleteProjectDomain removes the Caddy configuration file for the specified project domain.
+// DeleteProjectDomain removes the Caddy configuration file for the specified project domain, all domains of the project
+// and its route from Caddy. Partials that do not belong to a project, such as the one of the webhook server, can be deleted as well.
 func DeleteProjectDomain(projectName string) error {
 	domainFilePath := path.Join(consts.GetBasePath(), "domains", projectName+".caddy")
 
@@ -225,54 +397,15 @@ func DeleteProjectDomain(projectName string) error {
 		return fmt.Errorf("failed to delete project domain configuration %s: %w", domainFilePath, err)
 	}
 
-	return nil
-}
-
-// ReloadCaddy reads the main Caddyfile and partials, consolidates them, and sends to the API.
-func ReloadCaddy() error {
-	mainFilePath := path.Join(consts.GetBasePath(), "caddy", "main.caddy")
-	domainsDir := path.Join(consts.GetBasePath(), "domains")
-	apiURL := "http://localhost:2019"
-
-	var caddyfileBuilder strings.Builder
-
-	// Read the main Caddyfile
-	mainCaddyContent, err := os.ReadFile(mainFilePath)
-	if err != nil {
-		return fmt.Errorf("failed to read main Caddyfile %s: %w", mainFilePath, err)
+	err := editProject(projectName, func(p *Project) { p.Domains = nil })
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("failed to remove the domains of %s: %w", projectName, err)
 	}
-	caddyfileBuilder.Write(mainCaddyContent)
-	caddyfileBuilder.WriteString("\n\n")
 
-	// Read all partial Caddyfiles in the domains directory
-	err = filepath.Walk(domainsDir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
+	if !consts.Testing {
+		if err := removeCaddyRoute(projectName); err != nil {
+			return fmt.Errorf("failed to remove the route of %s from caddy: %w", projectName, err)
 		}
-		if !info.IsDir() && strings.HasSuffix(info.Name(), ".caddy") {
-			content, readErr := os.ReadFile(path)
-			if readErr != nil {
-				return fmt.Errorf("failed to read Caddyfile fragment %s: %w", path, readErr)
-			}
-			caddyfileBuilder.Write(content)
-			caddyfileBuilder.WriteString("\n\n")
-		}
-		return nil
-	})
-	if err != nil {
-		return fmt.Errorf("failed to gather Caddyfiles: %w", err)
-	}
-
-	// Send the consolidated Caddyfile to the API
-	resp, err := http.Post(fmt.Sprintf("%s/load", apiURL), "text/caddyfile", bytes.NewBufferString(caddyfileBuilder.String()))
-	if err != nil {
-		return fmt.Errorf("failed to send consolidated Caddyfile to Caddy API: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("Caddy API returned status: %s\nDetails: %s", resp.Status, string(body))
 	}
 
 	return nil
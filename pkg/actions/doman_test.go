@@ -15,7 +15,7 @@ var successDomainStatic = "www.test.com {\n    redir https://test.com{uri}\n}\n\
 func TestAddDomainProxy(t *testing.T) {
 	consts.Testing = true
 
-	tmp := os.TempDir()
+	tmp := t.TempDir()
 	consts.BasePath = tmp
 	defer os.RemoveAll(tmp)
 
@@ -49,7 +49,7 @@ func TestAddDomainProxy(t *testing.T) {
 func TestAddDomainStatic(t *testing.T) {
 	consts.Testing = true
 
-	tmp := os.TempDir()
+	tmp := t.TempDir()
 	consts.BasePath = tmp
 	defer os.RemoveAll(tmp)
 
@@ -84,7 +84,7 @@ func TestAddDomainStatic(t *testing.T) {
 func TestSetupDomains(t *testing.T) {
 	consts.Testing = true
 
-	tmp := os.TempDir()
+	tmp := t.TempDir()
 	consts.BasePath = tmp
 	defer os.RemoveAll(tmp)
 
@@ -108,7 +108,7 @@ func TestSetupDomains(t *testing.T) {
 func TestDeleteDomain(t *testing.T) {
 	consts.Testing = true
 
-	tmp := os.TempDir()
+	tmp := t.TempDir()
 	consts.BasePath = tmp
 	defer os.RemoveAll(tmp)
 
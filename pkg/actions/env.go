@@ -0,0 +1,552 @@
+package actions
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+	"github.com/zulubit/mole/pkg/consts"
+	"github.com/zulubit/mole/pkg/helpers"
+)
+
+// maxEnvHistory is the number of .env revisions kept per project.
+const maxEnvHistory = 100
+
+// bareEnvValue matches values that can be written to .env without quotes.
+var bareEnvValue = regexp.MustCompile(`^[A-Za-z0-9_./:@%+,=-]*$`)
+
+// EnvVar is a variable of a project's .env file.
+type EnvVar struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// EnvChange records how a single variable changed, values are not recorded since .env files hold credentials.
+type EnvChange struct {
+	Key    string `json:"key"`
+	Action string `json:"action"` // added, changed or removed
+}
+
+// EnvRevision records a change of a project's .env file made through mole.
+type EnvRevision struct {
+	ChangedAt time.Time   `json:"changedAt"`
+	ChangedBy string      `json:"changedBy"`
+	Command   string      `json:"command"`
+	Changes   []EnvChange `json:"changes"`
+}
+
+// envHistory is the on-disk list of .env revisions for a single project, oldest first.
+type envHistory struct {
+	Revisions []EnvRevision `json:"revisions"`
+}
+
+// envLine is a statement, comment or blank line of a .env file.
+// Quoted values spanning several lines are kept together in a single envLine.
+type envLine struct {
+	raw string
+	key string // empty for comments and blank lines
+}
+
+// envFile is a .env file that can be edited without losing its comments and the order of its variables.
+type envFile struct {
+	lines []envLine
+}
+
+// getEnvPath returns the path to the .env file of a project.
+func getEnvPath(projectName string) string {
+	return path.Join(consts.GetBasePath(), "projects", projectName, ".env")
+}
+
+// getEnvHistoryPath returns the path to the .env history file of a project.
+func getEnvHistoryPath(projectName string) string {
+	return path.Join(consts.GetBasePath(), "env_history", projectName+".json")
+}
+
+// getEnvLockPath returns the path to the lock guarding the .env file of a project.
+func getEnvLockPath(projectName string) string {
+	return path.Join(consts.GetBasePath(), "env_history", projectName+".lock")
+}
+
+// parseEnvFile splits the content of a .env file into lines, keeping track of the variable each statement sets.
+func parseEnvFile(content string) *envFile {
+	content = strings.ReplaceAll(content, "\r\n", "\n")
+	content = strings.TrimSuffix(content, "\n")
+
+	e := &envFile{}
+	if content == "" {
+		return e
+	}
+
+	lines := strings.Split(content, "\n")
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			e.lines = append(e.lines, envLine{raw: line})
+			continue
+		}
+
+		statement := strings.TrimPrefix(trimmed, "export ")
+		sep := strings.IndexAny(statement, "=:")
+		if sep == -1 {
+			e.lines = append(e.lines, envLine{raw: line})
+			continue
+		}
+
+		key := strings.TrimSpace(statement[:sep])
+		value := strings.TrimLeft(statement[sep+1:], " \t")
+
+		// a quoted value runs until its closing quote, which may be on a later line
+		if len(value) > 0 && (value[0] == '"' || value[0] == '\'') {
+			for !closesQuote(value) && i+1 < len(lines) {
+				i++
+				line += "\n" + lines[i]
+				value += "\n" + lines[i]
+			}
+		}
+
+		e.lines = append(e.lines, envLine{raw: line, key: key})
+	}
+
+	return e
+}
+
+// closesQuote reports whether a value starting with a quote also contains its unescaped closing quote.
+func closesQuote(value string) bool {
+	quote := value[0]
+	for i := 1; i < len(value); i++ {
+		if value[i] == quote && value[i-1] != '\\' {
+			return true
+		}
+	}
+	return false
+}
+
+// String returns the content of the .env file.
+func (e *envFile) String() string {
+	if len(e.lines) == 0 {
+		return ""
+	}
+
+	raw := make([]string, len(e.lines))
+	for i, l := range e.lines {
+		raw[i] = l.raw
+	}
+
+	return strings.Join(raw, "\n") + "\n"
+}
+
+// keys returns the variables set by the file, in the order they first appear.
+func (e *envFile) keys() []string {
+	seen := map[string]bool{}
+	keys := []string{}
+	for _, l := range e.lines {
+		if l.key != "" && !seen[l.key] {
+			seen[l.key] = true
+			keys = append(keys, l.key)
+		}
+	}
+	return keys
+}
+
+// values returns the variables set by the file, the way godotenv reads them.
+func (e *envFile) values() (map[string]string, error) {
+	values, err := godotenv.Unmarshal(e.String())
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse .env: %w", err)
+	}
+	return values, nil
+}
+
+// vars returns the variables set by the file with their values, in file order.
+func (e *envFile) vars() ([]EnvVar, error) {
+	values, err := e.values()
+	if err != nil {
+		return nil, err
+	}
+
+	vars := []EnvVar{}
+	for _, k := range e.keys() {
+		vars = append(vars, EnvVar{Key: k, Value: values[k]})
+	}
+	return vars, nil
+}
+
+// set sets a variable, in place where the file already sets it, otherwise at the end of the file.
+func (e *envFile) set(key, value string) {
+	found := false
+	for i, l := range e.lines {
+		if l.key != key {
+			continue
+		}
+
+		prefix := ""
+		if strings.HasPrefix(strings.TrimSpace(l.raw), "export ") {
+			prefix = "export "
+		}
+		e.lines[i] = envLine{raw: prefix + key + "=" + formatEnvValue(value), key: key}
+		found = true
+	}
+
+	if !found {
+		e.lines = append(e.lines, envLine{raw: key + "=" + formatEnvValue(value), key: key})
+	}
+}
+
+// unset removes every statement setting the variable and reports whether there was one.
+func (e *envFile) unset(key string) bool {
+	lines := e.lines[:0]
+	found := false
+	for _, l := range e.lines {
+		if l.key == key {
+			found = true
+			continue
+		}
+		lines = append(lines, l)
+	}
+	e.lines = lines
+	return found
+}
+
+// formatEnvValue quotes a value so godotenv reads it back unchanged.
+func formatEnvValue(value string) string {
+	if bareEnvValue.MatchString(value) {
+		return value
+	}
+
+	// single quoted values are read literally
+	if !strings.ContainsAny(value, "'\n\r") {
+		return "'" + value + "'"
+	}
+
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`, "$", `\$`)
+	return `"` + r.Replace(value) + `"`
+}
+
+// readEnvFile reads the .env file of a project. A missing file is an empty one.
+func readEnvFile(projectName string) (*envFile, error) {
+	content, err := os.ReadFile(getEnvPath(projectName))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read .env: %w", err)
+	}
+
+	return parseEnvFile(string(content)), nil
+}
+
+// save writes the .env file of a project atomically, keeping the permissions of the existing file.
+func (e *envFile) save(projectName string) error {
+	perm := os.FileMode(0644)
+	if info, err := os.Stat(getEnvPath(projectName)); err == nil {
+		perm = info.Mode().Perm()
+	}
+
+	if err := helpers.WriteFileAtomic(getEnvPath(projectName), []byte(e.String()), perm); err != nil {
+		return fmt.Errorf("failed to write .env: %w", err)
+	}
+
+	return nil
+}
+
+// diffEnv lists the variables that were added, changed or removed between two versions of a .env file, sorted by key.
+func diffEnv(before, after map[string]string) []EnvChange {
+	changes := []EnvChange{}
+	for k, v := range after {
+		old, ok := before[k]
+		if !ok {
+			changes = append(changes, EnvChange{Key: k, Action: "added"})
+		} else if old != v {
+			changes = append(changes, EnvChange{Key: k, Action: "changed"})
+		}
+	}
+	for k := range before {
+		if _, ok := after[k]; !ok {
+			changes = append(changes, EnvChange{Key: k, Action: "removed"})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Key < changes[j].Key })
+
+	return changes
+}
+
+// updateEnv applies fn to the .env file of a project, writes it and records the change in the project's .env history.
+// The .env lock of the project is held from reading to writing. Nothing is written when fn returns an error or nothing changed.
+func updateEnv(projectNOI, command string, fn func(*envFile) error) ([]EnvChange, error) {
+	p, err := FindProject(projectNOI)
+	if err != nil {
+		return nil, err
+	}
+
+	return updateProjectEnv(p, command, fn)
+}
+
+// updateProjectEnv is updateEnv for a project that was already looked up, or is not in the project store yet.
+func updateProjectEnv(p Project, command string, fn func(*envFile) error) ([]EnvChange, error) {
+	if err := os.MkdirAll(path.Join(consts.GetBasePath(), "env_history"), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create .env history directory: %w", err)
+	}
+
+	fileLock, locked, err := lockWithTimeout(getEnvLockPath(p.Name))
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock the .env of %s: %w", p.Name, err)
+	}
+	if !locked {
+		return nil, fmt.Errorf("someone else is working with the .env of %s, gave up after %s, please try again", p.Name, projectStoreLockTimeout)
+	}
+	defer fileLock.Unlock()
+
+	e, err := readEnvFile(p.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	before, err := e.values()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := fn(e); err != nil {
+		return nil, err
+	}
+
+	after, err := e.values()
+	if err != nil {
+		return nil, err
+	}
+
+	changes := diffEnv(before, after)
+	if len(changes) == 0 {
+		return changes, nil
+	}
+
+	if err := e.save(p.Name); err != nil {
+		return nil, err
+	}
+
+	return changes, recordEnvRevision(p.Name, EnvRevision{
+		ChangedAt: time.Now(),
+		ChangedBy: triggeredBy(),
+		Command:   command,
+		Changes:   changes,
+	})
+}
+
+// readEnvHistory reads the .env history of a project.
+func readEnvHistory(projectName string) (envHistory, error) {
+	f, err := os.ReadFile(getEnvHistoryPath(projectName))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return envHistory{}, nil
+		}
+		return envHistory{}, fmt.Errorf("failed to read .env history: %w", err)
+	}
+
+	var h envHistory
+	if err := json.Unmarshal(f, &h); err != nil {
+		return envHistory{}, fmt.Errorf("failed to unmarshal .env history: %w", err)
+	}
+
+	return h, nil
+}
+
+// recordEnvRevision adds a revision to the .env history of a project, keeping the latest maxEnvHistory revisions.
+func recordEnvRevision(projectName string, r EnvRevision) error {
+	h, err := readEnvHistory(projectName)
+	if err != nil {
+		return err
+	}
+
+	h.Revisions = append(h.Revisions, r)
+	if len(h.Revisions) > maxEnvHistory {
+		h.Revisions = h.Revisions[len(h.Revisions)-maxEnvHistory:]
+	}
+
+	f, err := json.MarshalIndent(h, "", " ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal .env history: %w", err)
+	}
+
+	if err := helpers.WriteFileAtomic(getEnvHistoryPath(projectName), f, 0644); err != nil {
+		return fmt.Errorf("failed to write .env history: %w", err)
+	}
+
+	return nil
+}
+
+// validateEnvVars checks the keys of the variables about to be set.
+func validateEnvVars(vars []EnvVar) error {
+	for _, v := range vars {
+		if !helpers.ValidateEnvKey(v.Key) {
+			return fmt.Errorf("invalid variable name %s, names can only contain letters, digits and underscores and can not start with a digit", v.Key)
+		}
+	}
+	return nil
+}
+
+// setEnvVars sets vars in e and checks godotenv reads every value back unchanged.
+func setEnvVars(e *envFile, vars []EnvVar) error {
+	for _, v := range vars {
+		e.set(v.Key, v.Value)
+	}
+
+	values, err := e.values()
+	if err != nil {
+		return err
+	}
+
+	for _, v := range vars {
+		if values[v.Key] != v.Value {
+			return fmt.Errorf("the value of %s can not be written to .env so that it reads back unchanged", v.Key)
+		}
+	}
+
+	return nil
+}
+
+// ListEnv returns the variables of a project's .env file, in file order.
+func ListEnv(projectNOI string) ([]EnvVar, error) {
+	p, err := FindProject(projectNOI)
+	if err != nil {
+		return nil, err
+	}
+
+	e, err := readEnvFile(p.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.vars()
+}
+
+// GetEnv returns the value of a variable of a project's .env file.
+func GetEnv(projectNOI, key string) (string, error) {
+	vars, err := ListEnv(projectNOI)
+	if err != nil {
+		return "", err
+	}
+
+	for _, v := range vars {
+		if v.Key == key {
+			return v.Value, nil
+		}
+	}
+
+	return "", notFoundError{fmt.Sprintf("variable %s is not set", key)}
+}
+
+// SetEnv sets variables of a project's .env file, replacing the values of variables that are already set.
+func SetEnv(projectNOI string, vars []EnvVar) ([]EnvChange, error) {
+	if err := validateEnvVars(vars); err != nil {
+		return nil, err
+	}
+
+	return updateEnv(projectNOI, "set", func(e *envFile) error {
+		return setEnvVars(e, vars)
+	})
+}
+
+// UnsetEnv removes variables from a project's .env file. Nothing is removed when one of them is not set.
+func UnsetEnv(projectNOI string, keys []string) ([]EnvChange, error) {
+	return updateEnv(projectNOI, "unset", func(e *envFile) error {
+		for _, k := range keys {
+			if !e.unset(k) {
+				return notFoundError{fmt.Sprintf("variable %s is not set", k)}
+			}
+		}
+		return nil
+	})
+}
+
+// ImportEnv sets the variables of a dotenv document read from r in a project's .env file.
+// Variables of the .env file missing from the document are kept.
+func ImportEnv(projectNOI string, r io.Reader) ([]EnvChange, error) {
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read variables to import: %w", err)
+	}
+
+	vars, err := parseEnvFile(string(content)).vars()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := validateEnvVars(vars); err != nil {
+		return nil, err
+	}
+
+	return updateEnv(projectNOI, "import", func(e *envFile) error {
+		return setEnvVars(e, vars)
+	})
+}
+
+// ExportEnv returns the variables of a project's .env file as a dotenv document, without comments.
+func ExportEnv(projectNOI string) (string, error) {
+	vars, err := ListEnv(projectNOI)
+	if err != nil {
+		return "", err
+	}
+
+	return formatEnvVars(vars), nil
+}
+
+// formatEnvVars writes variables as a dotenv document.
+func formatEnvVars(vars []EnvVar) string {
+	var b strings.Builder
+	for _, v := range vars {
+		b.WriteString(v.Key + "=" + formatEnvValue(v.Value) + "\n")
+	}
+	return b.String()
+}
+
+// ListEnvHistory returns the .env history of a project, newest first.
+func ListEnvHistory(projectNOI string) ([]EnvRevision, error) {
+	p, err := FindProject(projectNOI)
+	if err != nil {
+		return nil, err
+	}
+
+	h, err := readEnvHistory(p.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	revisions := make([]EnvRevision, 0, len(h.Revisions))
+	for i := len(h.Revisions) - 1; i >= 0; i-- {
+		revisions = append(revisions, h.Revisions[i])
+	}
+
+	return revisions, nil
+}
+
+// Stringify returns a string representation of the EnvRevision.
+func (r EnvRevision) Stringify() string {
+	var b strings.Builder
+	b.WriteString("\n")
+	b.WriteString(" |Changed : " + r.ChangedAt.Format(time.RFC3339) + "\n")
+	b.WriteString(" |By      : " + r.ChangedBy + "\n")
+	b.WriteString(" |Command : " + r.Command + "\n")
+	for _, c := range r.Changes {
+		b.WriteString(" |  " + envChangeSymbol(c.Action) + " " + c.Key + "\n")
+	}
+	return b.String()
+}
+
+// envChangeSymbol returns the diff marker of a change.
+func envChangeSymbol(action string) string {
+	switch action {
+	case "added":
+		return "+"
+	case "removed":
+		return "-"
+	default:
+		return "~"
+	}
+}
@@ -0,0 +1,176 @@
+package actions
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+
+	"github.com/zulubit/mole/pkg/consts"
+)
+
+// envTemplates are the files the .env of a project is rendered from, the first one found is used.
+var envTemplates = []string{"mole.env", ".env.example"}
+
+// findEnvTemplate returns the path to the .env template of a project, or "" if it has none.
+func findEnvTemplate(p Project) string {
+	for _, name := range envTemplates {
+		templatePath := path.Join(consts.GetBasePath(), "projects", p.Name, name)
+		if _, err := os.Stat(templatePath); err == nil {
+			return templatePath
+		}
+	}
+	return ""
+}
+
+// renderEnvTemplate renders the .env template of a project with its secrets.
+// MOLE_PORT_APP is added when the template does not set it. It returns nil if the project has no template.
+func renderEnvTemplate(p Project) (*envFile, error) {
+	templatePath := findEnvTemplate(p)
+	if templatePath == "" {
+		return nil, nil
+	}
+
+	secrets, err := readProjectSecrets(p.Name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load secrets for project %s: %w", p.Name, err)
+	}
+
+	content, err := os.ReadFile(templatePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read template file %s: %w", templatePath, err)
+	}
+
+	rendered, err := renderTemplate(string(content), secrets)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render %s: %w", path.Base(templatePath), err)
+	}
+
+	tmpl := parseEnvFile(rendered)
+
+	values, err := tmpl.values()
+	if err != nil {
+		return nil, fmt.Errorf("rendered %s: %w", path.Base(templatePath), err)
+	}
+	if _, ok := values["MOLE_PORT_APP"]; !ok {
+		tmpl.set("MOLE_PORT_APP", strconv.Itoa(secrets.PortApp))
+	}
+
+	return tmpl, nil
+}
+
+// mergeEnvTemplate returns a function adding the variables of the rendered .env template missing from a .env file.
+// Variables the .env file already sets are left alone, so edits made on the server survive.
+func mergeEnvTemplate(p Project) (func(*envFile) error, error) {
+	tmpl, err := renderEnvTemplate(p)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(e *envFile) error {
+		if tmpl == nil {
+			return nil
+		}
+
+		// a new .env is the rendered template, comments included
+		if len(e.lines) == 0 {
+			e.lines = tmpl.lines
+			return nil
+		}
+
+		existing, err := e.values()
+		if err != nil {
+			return err
+		}
+
+		vars, err := tmpl.vars()
+		if err != nil {
+			return err
+		}
+
+		for _, v := range vars {
+			if _, ok := existing[v.Key]; !ok {
+				e.set(v.Key, v.Value)
+			}
+		}
+
+		return nil
+	}, nil
+}
+
+// refreshEnvSecret returns a function setting the variables of a .env file whose line in the .env template uses secret,
+// e.g. DB_PASSWORD={{.DatabasePass}}, to their rendered value, so the .env follows a rotation of the secret.
+// Other variables are left alone, and so is the .env of a project without a template.
+func refreshEnvSecret(p Project, secret string) (func(*envFile) error, error) {
+	templatePath := findEnvTemplate(p)
+	if templatePath == "" {
+		return func(*envFile) error { return nil }, nil
+	}
+
+	content, err := os.ReadFile(templatePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read template file %s: %w", templatePath, err)
+	}
+
+	// .Previous.<secret> is the old value, only the secret itself is refreshed
+	uses := regexp.MustCompile(`(^|[^.\w])\.` + regexp.QuoteMeta(secret) + `\b`)
+	keys := map[string]bool{}
+	for _, l := range parseEnvFile(string(content)).lines {
+		if l.key != "" && uses.MatchString(l.raw) {
+			keys[l.key] = true
+		}
+	}
+
+	tmpl, err := renderEnvTemplate(p)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(e *envFile) error {
+		vars, err := tmpl.vars()
+		if err != nil {
+			return err
+		}
+
+		for _, v := range vars {
+			if keys[v.Key] {
+				e.set(v.Key, v.Value)
+			}
+		}
+
+		return nil
+	}, nil
+}
+
+// createProjectBaseEnv renders the base environment file of a new project from its .env template, if it has one.
+func createProjectBaseEnv(project Project) error {
+	merge, err := mergeEnvTemplate(project)
+	if err != nil {
+		return err
+	}
+
+	_, err = updateProjectEnv(project, "create", merge)
+	return err
+}
+
+// SyncEnv adds the variables of a project's .env template that are missing from its .env file.
+// It runs on every deployment, so variables added to the template in the repository reach the server.
+func SyncEnv(projectNOI string) ([]EnvChange, error) {
+	p, err := FindProject(projectNOI)
+	if err != nil {
+		return nil, err
+	}
+
+	return syncProjectEnv(p)
+}
+
+// syncProjectEnv is SyncEnv for a project that was already looked up.
+func syncProjectEnv(p Project) ([]EnvChange, error) {
+	merge, err := mergeEnvTemplate(p)
+	if err != nil {
+		return nil, err
+	}
+
+	return updateProjectEnv(p, "sync", merge)
+}
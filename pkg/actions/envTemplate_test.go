@@ -0,0 +1,70 @@
+package actions
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zulubit/mole/pkg/consts"
+)
+
+func TestSyncEnv(t *testing.T) {
+	consts.Testing = true
+
+	tmp := t.TempDir()
+	consts.BasePath = tmp
+
+	np := Project{Name: "sync-project"}
+	err := addProject(np)
+	assert.Nil(t, err, "project added")
+	err = createProjectSecretsJson(np)
+	assert.Nil(t, err, "project secrets created")
+
+	projectPath := path.Join(tmp, "projects", np.Name)
+	err = os.MkdirAll(projectPath, 0755)
+	assert.Nil(t, err, "project directory created")
+
+	changes, err := SyncEnv(np.Name)
+	assert.Nil(t, err, "projects without a template can be synced")
+	assert.Empty(t, changes, "nothing is synced without a template")
+	_, err = os.Stat(getEnvPath(np.Name))
+	assert.True(t, os.IsNotExist(err), "no .env is created without a template")
+
+	err = os.WriteFile(path.Join(projectPath, ".env.example"), []byte("FROM_EXAMPLE=1\n"), 0644)
+	assert.Nil(t, err, ".env.example written")
+	err = os.WriteFile(path.Join(projectPath, "mole.env"), []byte("APP_ENV=production\nDB_PASSWORD={{.DatabasePass}}\nMOLE_PORT_APP=8080\n"), 0644)
+	assert.Nil(t, err, "mole.env written")
+
+	changes, err = SyncEnv(np.Name)
+	assert.Nil(t, err, "env synced")
+	assert.Len(t, changes, 3, "all variables of the template are added")
+
+	secrets, _ := readProjectSecrets(np.Name)
+	password, _ := GetEnv(np.Name, "DB_PASSWORD")
+	assert.Equal(t, secrets.DatabasePass, password, "secrets are injected")
+	port, _ := GetEnv(np.Name, "MOLE_PORT_APP")
+	assert.Equal(t, "8080", port, "templates can set the app port themselves")
+	_, err = GetEnv(np.Name, "FROM_EXAMPLE")
+	assert.ErrorIs(t, err, ErrNotFound, "mole.env takes precedence over .env.example")
+
+	_, err = SetEnv(np.Name, []EnvVar{{Key: "APP_ENV", Value: "staging"}})
+	assert.Nil(t, err, "variable edited on the server")
+
+	err = os.WriteFile(path.Join(projectPath, "mole.env"), []byte("APP_ENV=production\nDB_PASSWORD={{.DatabasePass}}\nMOLE_PORT_APP=8080\nNEW_KEY=new\n"), 0644)
+	assert.Nil(t, err, "mole.env updated")
+
+	changes, err = SyncEnv(np.Name)
+	assert.Nil(t, err, "env synced")
+	assert.Equal(t, []EnvChange{{Key: "NEW_KEY", Action: "added"}}, changes, "only new variables are added")
+
+	appEnv, _ := GetEnv(np.Name, "APP_ENV")
+	assert.Equal(t, "staging", appEnv, "edits made on the server are kept")
+
+	changes, err = SyncEnv(np.Name)
+	assert.Nil(t, err, "env synced")
+	assert.Empty(t, changes, "syncing again changes nothing")
+
+	history, _ := ListEnvHistory(np.Name)
+	assert.Equal(t, "sync", history[0].Command, "syncs are recorded in the history")
+}
@@ -0,0 +1,140 @@
+package actions
+
+import (
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/joho/godotenv"
+	"github.com/stretchr/testify/assert"
+	"github.com/zulubit/mole/pkg/consts"
+)
+
+func TestEnvFilePreservesLayout(t *testing.T) {
+	content := `# database
+DB_HOST=localhost
+export DB_PORT=3306
+
+# multi line
+CERT="-----BEGIN-----
+abc
+-----END-----"
+APP_NAME='my app' # inline comment
+`
+
+	e := parseEnvFile(content)
+	assert.Equal(t, content, e.String(), "unchanged file is written back as it was")
+	assert.Equal(t, []string{"DB_HOST", "DB_PORT", "CERT", "APP_NAME"}, e.keys(), "keys are read in order")
+
+	e.set("DB_PORT", "3307")
+	e.set("NEW_KEY", "value")
+	assert.True(t, e.unset("DB_HOST"), "existing key is removed")
+	assert.False(t, e.unset("MISSING"), "missing key is reported")
+
+	expected := `# database
+export DB_PORT=3307
+
+# multi line
+CERT="-----BEGIN-----
+abc
+-----END-----"
+APP_NAME='my app' # inline comment
+NEW_KEY=value
+`
+	assert.Equal(t, expected, e.String(), "comments, order and export prefixes are kept")
+}
+
+func TestFormatEnvValue(t *testing.T) {
+	values := []string{
+		"",
+		"plain",
+		"postgres://user:pass@db:5432/app",
+		"with spaces",
+		"hash # not a comment",
+		"${NOT_EXPANDED}",
+		`single ' quote`,
+		`double " quote`,
+		"multi\nline",
+		`back\slash`,
+		`it's $HOME`,
+	}
+
+	for _, v := range values {
+		t.Run(v, func(t *testing.T) {
+			parsed, err := godotenv.Unmarshal("KEY=" + formatEnvValue(v))
+			assert.Nil(t, err, "formatted value can be parsed")
+			assert.Equal(t, v, parsed["KEY"], "value reads back unchanged")
+		})
+	}
+}
+
+func TestEnvCommands(t *testing.T) {
+	consts.Testing = true
+
+	tmp := t.TempDir()
+	consts.BasePath = tmp
+
+	err := addProject(Project{Name: "env-project"})
+	assert.Nil(t, err, "project added")
+
+	err = os.MkdirAll(path.Join(tmp, "projects", "env-project"), 0755)
+	assert.Nil(t, err, "project directory created")
+	err = os.WriteFile(getEnvPath("env-project"), []byte("# app\nAPP_ENV=local\nAPP_DEBUG=true\n"), 0600)
+	assert.Nil(t, err, ".env written")
+
+	_, err = SetEnv("env-project", []EnvVar{{Key: "BAD-KEY", Value: "x"}})
+	assert.ErrorContains(t, err, "invalid variable name", "keys are validated")
+
+	changes, err := SetEnv("env-project", []EnvVar{{Key: "APP_ENV", Value: "production"}, {Key: "MAIL_PASSWORD", Value: "p@ss word"}})
+	assert.Nil(t, err, "variables set")
+	assert.Equal(t, []EnvChange{{Key: "APP_ENV", Action: "changed"}, {Key: "MAIL_PASSWORD", Action: "added"}}, changes)
+
+	value, err := GetEnv("env-project", "MAIL_PASSWORD")
+	assert.Nil(t, err, "variable found")
+	assert.Equal(t, "p@ss word", value)
+
+	_, err = GetEnv("env-project", "MISSING")
+	assert.ErrorIs(t, err, ErrNotFound, "missing variable is not found")
+
+	changes, err = SetEnv("env-project", []EnvVar{{Key: "APP_ENV", Value: "production"}})
+	assert.Nil(t, err, "setting the same value succeeds")
+	assert.Empty(t, changes, "setting the same value changes nothing")
+
+	_, err = UnsetEnv("env-project", []string{"APP_DEBUG", "MISSING"})
+	assert.ErrorIs(t, err, ErrNotFound, "unsetting a missing variable fails")
+	_, err = GetEnv("env-project", "APP_DEBUG")
+	assert.Nil(t, err, "nothing is removed when one variable is missing")
+
+	changes, err = UnsetEnv("env-project", []string{"APP_DEBUG"})
+	assert.Nil(t, err, "variable unset")
+	assert.Equal(t, []EnvChange{{Key: "APP_DEBUG", Action: "removed"}}, changes)
+
+	changes, err = ImportEnv("env-project", strings.NewReader("APP_ENV=staging\n# comment\nS3_BUCKET=assets\n"))
+	assert.Nil(t, err, "variables imported")
+	assert.Equal(t, []EnvChange{{Key: "APP_ENV", Action: "changed"}, {Key: "S3_BUCKET", Action: "added"}}, changes)
+
+	content, err := os.ReadFile(getEnvPath("env-project"))
+	assert.Nil(t, err, ".env can be read")
+	assert.Equal(t, "# app\nAPP_ENV=staging\nMAIL_PASSWORD='p@ss word'\nS3_BUCKET=assets\n", string(content), ".env keeps its comments and order")
+
+	info, err := os.Stat(getEnvPath("env-project"))
+	assert.Nil(t, err, ".env exists")
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm(), "permissions of .env are kept")
+
+	exported, err := ExportEnv("env-project")
+	assert.Nil(t, err, "variables exported")
+	assert.Equal(t, "APP_ENV=staging\nMAIL_PASSWORD='p@ss word'\nS3_BUCKET=assets\n", exported)
+
+	history, err := ListEnvHistory("env-project")
+	assert.Nil(t, err, "history listed")
+	assert.Len(t, history, 3, "only changes are recorded")
+	assert.Equal(t, "import", history[0].Command, "newest revision first")
+	assert.Equal(t, "unset", history[1].Command)
+	assert.Equal(t, "set", history[2].Command)
+	assert.NotEmpty(t, history[0].ChangedBy, "who changed .env is recorded")
+
+	raw, err := os.ReadFile(getEnvHistoryPath("env-project"))
+	assert.Nil(t, err, "history file can be read")
+	assert.NotContains(t, string(raw), "p@ss word", "values are not recorded in the history")
+}
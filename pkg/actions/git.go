@@ -0,0 +1,87 @@
+package actions
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path"
+	"strings"
+
+	"github.com/zulubit/mole/pkg/consts"
+)
+
+// runGit runs git with the given arguments inside dir and returns its trimmed output.
+func runGit(dir string, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+
+	c := exec.Command("git", args...)
+	c.Dir = dir
+	c.Stdout = &stdout
+	c.Stderr = &stderr
+
+	if err := c.Run(); err != nil {
+		return "", fmt.Errorf("git %s failed: %s", args[0], strings.TrimSpace(stderr.String()))
+	}
+
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// currentCommit returns the commit checked out in the project's working tree.
+// An empty string is returned when the commit can't be determined.
+func currentCommit(p Project) string {
+	sha, err := runGit(path.Join(consts.GetBasePath(), "projects", p.Name), "rev-parse", "HEAD")
+	if err != nil {
+		return ""
+	}
+	return sha
+}
+
+// syncProjectSource fetches ref from origin and hard-resets the project's working tree to it.
+// Without a ref the project's configured branch is checked out, otherwise the ref (a branch, tag or commit) is
+// checked out detached. Explicit refs that can't be fetched but already exist locally, such as commits of earlier
+// deployments, are still checked out, while a branch that can't be fetched fails the sync. The commits checked out before and after the sync are returned.
+func syncProjectSource(p Project, ref string) (string, string, error) {
+	dir := path.Join(consts.GetBasePath(), "projects", p.Name)
+
+	from := currentCommit(p)
+
+	fetchRef := ref
+	if fetchRef == "" {
+		fetchRef = p.Branch
+	}
+
+	target := "FETCH_HEAD"
+	if _, err := runGit(dir, "fetch", "--depth", "1", "origin", fetchRef); err != nil {
+		// a branch deploy must not silently redeploy a stale local copy of the branch
+		if ref == "" {
+			return from, "", err
+		}
+		if _, lerr := runGit(dir, "rev-parse", "--verify", "--quiet", fetchRef+"^{commit}"); lerr != nil {
+			return from, "", err
+		}
+		target = fetchRef
+	}
+
+	var err error
+	if ref == "" {
+		_, err = runGit(dir, "checkout", "--force", "-B", p.Branch, target)
+	} else {
+		_, err = runGit(dir, "checkout", "--force", "--detach", target)
+	}
+	if err != nil {
+		return from, "", err
+	}
+
+	return from, currentCommit(p), nil
+}
+
+// commitRange describes the change between two commits for deploy output.
+func commitRange(from, to string) string {
+	if from == to {
+		return "already at " + shortCommit(to)
+	}
+	if from == "" {
+		return "checked out " + shortCommit(to)
+	}
+	return shortCommit(from) + ".." + shortCommit(to)
+}
@@ -0,0 +1,81 @@
+package actions
+
+import (
+	"os"
+	"os/exec"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zulubit/mole/pkg/consts"
+)
+
+func TestSyncProjectSource(t *testing.T) {
+	consts.Testing = true
+
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	tmp := t.TempDir()
+	consts.BasePath = tmp
+
+	originDir := path.Join(tmp, "origin")
+	os.MkdirAll(originDir, 0755)
+
+	commit := func(message string) string {
+		err := os.WriteFile(path.Join(originDir, "mole.sh"), []byte("echo '"+message+"'"), 0755)
+		assert.Nil(t, err, "mole.sh written")
+		_, err = runGit(originDir, "add", "mole.sh")
+		assert.Nil(t, err, "mole.sh staged")
+		_, err = runGit(originDir, "-c", "user.name=mole", "-c", "user.email=mole@example.com", "commit", "-m", message)
+		assert.Nil(t, err, "commit created")
+		sha, err := runGit(originDir, "rev-parse", "HEAD")
+		assert.Nil(t, err, "commit can be resolved")
+		return sha
+	}
+
+	_, err := runGit(originDir, "init", "-b", "main")
+	assert.Nil(t, err, "origin initialised")
+	first := commit("first")
+	_, err = runGit(originDir, "tag", "v1")
+	assert.Nil(t, err, "tag created")
+
+	p := Project{
+		Name:   "test-project",
+		Branch: "main",
+	}
+
+	projectDir := path.Join(tmp, "projects", p.Name)
+	_, err = runGit(tmp, "clone", "--depth", "1", "-b", "main", "file://"+originDir, projectDir)
+	assert.Nil(t, err, "project cloned")
+
+	second := commit("second")
+
+	from, to, err := syncProjectSource(p, "")
+	assert.Nil(t, err, "branch is synced")
+	assert.Equal(t, first, from, "previous commit is reported")
+	assert.Equal(t, second, to, "latest commit of the branch is checked out")
+	assert.Equal(t, first[:7]+".."+second[:7], commitRange(from, to))
+
+	_, to, err = syncProjectSource(p, "v1")
+	assert.Nil(t, err, "tag is synced")
+	assert.Equal(t, first, to, "pinned tag is checked out")
+
+	_, to, err = syncProjectSource(p, "")
+	assert.Nil(t, err, "branch is synced again after pinning")
+	assert.Equal(t, second, to, "unpinned deploy returns to the branch")
+
+	_, _, err = syncProjectSource(p, "does-not-exist")
+	assert.Error(t, err, "unknown refs fail")
+
+	err = os.Rename(originDir, originDir+"-gone")
+	assert.Nil(t, err, "origin made unreachable")
+
+	_, _, err = syncProjectSource(p, "")
+	assert.Error(t, err, "branch deploys fail when the branch can't be fetched")
+
+	_, to, err = syncProjectSource(p, first)
+	assert.Nil(t, err, "local commit is synced without origin")
+	assert.Equal(t, first, to, "commit of an earlier deployment is checked out")
+}
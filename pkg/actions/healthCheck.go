@@ -0,0 +1,175 @@
+package actions
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrHealthCheckFailed is returned when a deployed project does not pass its health check.
+var ErrHealthCheckFailed = errors.New("health check failed")
+
+// healthCheckRequestTimeout bounds a single health check request.
+const healthCheckRequestTimeout = 5 * time.Second
+
+// HealthCheck describes how to verify a project is up after its deployment script finished.
+type HealthCheck struct {
+	// Path is requested on the project's app port, e.g. /healthz.
+	Path string `json:"path" yaml:"path"`
+	// ExpectedStatus is the HTTP status a healthy project answers with.
+	ExpectedStatus int `json:"expectedStatus" yaml:"expectedStatus"`
+	// Retries is the number of requests made before the check fails.
+	Retries int `json:"retries" yaml:"retries"`
+	// Interval is the time waited between requests, as a duration such as 3s.
+	Interval string `json:"interval" yaml:"interval"`
+	// Rollback re-deploys the previous successful commit when the check fails.
+	Rollback bool `json:"rollback" yaml:"rollback"`
+}
+
+// NewHealthCheck returns a health check with the defaults filled in for any zero values.
+func NewHealthCheck(path string, expectedStatus, retries int, interval string, rollback bool) (*HealthCheck, error) {
+	hc := &HealthCheck{
+		Path:           path,
+		ExpectedStatus: expectedStatus,
+		Retries:        retries,
+		Interval:       interval,
+		Rollback:       rollback,
+	}
+
+	if hc.Path == "" {
+		hc.Path = "/"
+	}
+	if !strings.HasPrefix(hc.Path, "/") {
+		hc.Path = "/" + hc.Path
+	}
+	if hc.ExpectedStatus == 0 {
+		hc.ExpectedStatus = http.StatusOK
+	}
+	if hc.Retries == 0 {
+		hc.Retries = 10
+	}
+	if hc.Interval == "" {
+		hc.Interval = "3s"
+	}
+
+	if hc.ExpectedStatus < 100 || hc.ExpectedStatus > 599 {
+		return nil, fmt.Errorf("invalid expected status %d", hc.ExpectedStatus)
+	}
+	if hc.Retries < 1 {
+		return nil, fmt.Errorf("invalid number of retries %d", hc.Retries)
+	}
+	if _, err := time.ParseDuration(hc.Interval); err != nil {
+		return nil, fmt.Errorf("invalid health check interval %s, use a duration such as 3s: %w", hc.Interval, err)
+	}
+
+	return hc, nil
+}
+
+// SetHealthCheck configures the health check of a project, a nil health check disables it.
+func SetHealthCheck(projectNOI string, hc *HealthCheck) error {
+	return editProject(projectNOI, func(p *Project) { p.HealthCheck = hc })
+}
+
+// projectHealthCheck returns the health check configured for a project, or the one declared in its mole.yaml.
+func projectHealthCheck(p Project) *HealthCheck {
+	if p.HealthCheck != nil {
+		return p.HealthCheck
+	}
+
+	// an invalid mole.yaml already failed the deployment before its health check
+	m, err := readManifest(p)
+	if err != nil {
+		return nil
+	}
+	return m.HealthCheck
+}
+
+// runHealthCheck requests the health check path on the given port until it answers with the expected status.
+// It fails with ErrHealthCheckFailed once all retries are used up.
+func runHealthCheck(ctx context.Context, p Project, hc *HealthCheck, port int, out io.Writer) error {
+	interval, err := time.ParseDuration(hc.Interval)
+	if err != nil {
+		return fmt.Errorf("invalid health check interval %s: %w", hc.Interval, err)
+	}
+
+	url := "http://127.0.0.1:" + strconv.Itoa(port) + hc.Path
+	client := &http.Client{Timeout: healthCheckRequestTimeout}
+
+	var lastErr error
+	for attempt := 1; attempt <= hc.Retries; attempt++ {
+		if deployCancelled(p.Name) {
+			return ErrDeploymentCancelled
+		}
+
+		lastErr = checkHealth(ctx, client, url, hc.ExpectedStatus)
+		if lastErr == nil {
+			fmt.Fprintf(out, "Health check passed: %s answered %d\n", url, hc.ExpectedStatus)
+			return nil
+		}
+
+		fmt.Fprintf(out, "Health check %d/%d failed: %v\n", attempt, hc.Retries, lastErr)
+
+		if attempt == hc.Retries {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("healthcheck: %w", ErrDeploymentTimedOut)
+		case <-time.After(interval):
+		}
+	}
+
+	return fmt.Errorf("%w: %s: %w", ErrHealthCheckFailed, url, lastErr)
+}
+
+// checkHealth makes a single health check request.
+func checkHealth(ctx context.Context, client *http.Client, url string, expectedStatus int) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != expectedStatus {
+		return fmt.Errorf("got status %d, expected %d", resp.StatusCode, expectedStatus)
+	}
+
+	return nil
+}
+
+// previousSuccessfulDeployment returns the latest successful deployment of a different commit than the given one.
+func previousSuccessfulDeployment(projectName, commit string) (Deployment, bool) {
+	h, err := readDeploymentHistory(projectName)
+	if err != nil {
+		return Deployment{}, false
+	}
+
+	for i := len(h.Deployments) - 1; i >= 0; i-- {
+		d := h.Deployments[i]
+		if d.Status == "success" && d.Commit != "" && d.Commit != commit {
+			return d, true
+		}
+	}
+
+	return Deployment{}, false
+}
+
+// Stringify returns a string representation of the HealthCheck.
+func (hc HealthCheck) Stringify() string {
+	s := "GET " + hc.Path + " = " + strconv.Itoa(hc.ExpectedStatus) + ", " + strconv.Itoa(hc.Retries) + "x every " + hc.Interval
+	if hc.Rollback {
+		s += ", rollback on failure"
+	}
+	return s
+}
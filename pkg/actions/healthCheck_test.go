@@ -0,0 +1,144 @@
+package actions
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zulubit/mole/pkg/consts"
+)
+
+func TestNewHealthCheck(t *testing.T) {
+	hc, err := NewHealthCheck("healthz", 0, 0, "", true)
+	assert.Nil(t, err, "defaults are filled in")
+	assert.Equal(t, HealthCheck{Path: "/healthz", ExpectedStatus: 200, Retries: 10, Interval: "3s", Rollback: true}, *hc)
+
+	_, err = NewHealthCheck("/", 200, 3, "soon", false)
+	assert.ErrorContains(t, err, "invalid health check interval", "interval must be a duration")
+
+	_, err = NewHealthCheck("/", 42, 3, "1s", false)
+	assert.ErrorContains(t, err, "invalid expected status", "status must be a HTTP status")
+}
+
+func TestProjectHealthCheck(t *testing.T) {
+	consts.Testing = true
+
+	tmp := t.TempDir()
+	consts.BasePath = tmp
+
+	p := Project{Name: "manifest-health"}
+	projectPath := path.Join(tmp, "projects", p.Name)
+	os.MkdirAll(projectPath, 0755)
+
+	assert.Nil(t, projectHealthCheck(p), "no health check without configuration")
+
+	os.WriteFile(path.Join(projectPath, "mole.yaml"), []byte("healthCheck:\n  path: /up\n"), 0644)
+	assert.Equal(t, "/up", projectHealthCheck(p).Path, "health check of mole.yaml is used")
+
+	p.HealthCheck = &HealthCheck{Path: "/server"}
+	assert.Equal(t, "/server", projectHealthCheck(p).Path, "health check configured on the server takes precedence")
+}
+
+func TestHealthCheckRollback(t *testing.T) {
+	// git operations are skipped in testing mode, rollbacks depend on them
+	consts.Testing = false
+	defer func() { consts.Testing = true }()
+
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	tmp := t.TempDir()
+	consts.BasePath = tmp
+
+	projectName := "test-project"
+	np := Project{
+		Name:   projectName,
+		Branch: "main",
+	}
+	addProject(np)
+
+	err := createProjectSecretsJson(np)
+	assert.Nil(t, err, "Failed to setup project secrets")
+
+	projectDir := path.Join(tmp, "projects", projectName)
+	os.MkdirAll(projectDir, 0755)
+
+	// the app is healthy as long as the release deployed last is not broken
+	app := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		release, _ := os.ReadFile(path.Join(projectDir, "release.txt"))
+		if r.URL.Path != "/healthz" || strings.Contains(string(release), "broken") {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer app.Close()
+
+	u, _ := url.Parse(app.URL)
+	port, _ := strconv.Atoi(u.Port())
+
+	secrets, err := readProjectSecrets(projectName)
+	assert.Nil(t, err, "secrets can be read")
+	secrets.PortApp = port
+	err = saveProjectSecrets(projectName, secrets)
+	assert.Nil(t, err, "app port points at the test server")
+
+	hc, err := NewHealthCheck("/healthz", 200, 2, "10ms", true)
+	assert.Nil(t, err, "health check is valid")
+	err = SetHealthCheck(projectName, hc)
+	assert.Nil(t, err, "health check is configured")
+
+	commit := func(release string) {
+		err := os.WriteFile(path.Join(projectDir, "mole.sh"), []byte("#!/bin/bash\necho '"+release+"' > release.txt\necho \"port $MOLE_PORT_APP\""), 0755)
+		assert.Nil(t, err, "mole.sh written")
+		_, err = runGit(projectDir, "add", "mole.sh")
+		assert.Nil(t, err, "mole.sh staged")
+		_, err = runGit(projectDir, "-c", "user.name=mole", "-c", "user.email=mole@example.com", "commit", "-m", release)
+		assert.Nil(t, err, "commit created")
+	}
+
+	_, err = runGit(projectDir, "init", "-b", "main")
+	assert.Nil(t, err, "repository initialised")
+	// deployments fetch the branch from origin, the repository serves as its own
+	_, err = runGit(projectDir, "remote", "add", "origin", "file://"+projectDir)
+	assert.Nil(t, err, "origin added")
+
+	var output bytes.Buffer
+
+	commit("working release")
+	_, err = RunDeployment(projectName, DeployOptions{Output: &output})
+	assert.Nil(t, err, "healthy deployment succeeds")
+	assert.Contains(t, output.String(), "Health check passed", "health check ran")
+	assert.Contains(t, output.String(), "port "+u.Port(), "scripts know the app port")
+
+	commit("broken release")
+	output.Reset()
+	res, err := RunDeployment(projectName, DeployOptions{Output: &output})
+	assert.ErrorIs(t, err, ErrHealthCheckFailed, "unhealthy deployment fails")
+	assert.Contains(t, output.String(), "Health check 2/2 failed", "all retries are used")
+	assert.Contains(t, res.Summary(), "rolled back to", "failed deployment is rolled back")
+	assert.Equal(t, "success", res.Rollback.Status, "result contains the rollback")
+
+	deployments, err := ListDeployments(projectName)
+	assert.Nil(t, err, "history can be listed")
+	assert.Len(t, deployments, 3, "rollback is recorded as a deployment")
+	assert.Equal(t, "failure", deployments[1].Status, "unhealthy deployment is recorded as failed")
+	assert.Contains(t, deployments[1].LogPath, "-failure.log", "log is named after the failure")
+	assert.Equal(t, "success", deployments[0].Status, "rollback passed the health check")
+	assert.Equal(t, deployments[2].Commit, deployments[0].Commit, "rollback deployed the healthy commit")
+	assert.Equal(t, deployments[2].DeploymentID, deployments[0].RollbackOf, "rollback references the healthy deployment")
+
+	err = SetHealthCheck(projectName, nil)
+	assert.Nil(t, err, "health check can be removed")
+
+	p, err := FindProject(projectName)
+	assert.Nil(t, err, "project can be found")
+	assert.Nil(t, p.HealthCheck, "health check was removed")
+}
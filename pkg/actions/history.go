@@ -0,0 +1,192 @@
+package actions
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zulubit/mole/pkg/consts"
+)
+
+// maxDeploymentHistory is the number of deployments kept per project.
+const maxDeploymentHistory = 100
+
+// Deployment records a single run of a project's deployment script.
+type Deployment struct {
+	DeploymentID   string    `json:"deploymentId"`
+	ProjectName    string    `json:"projectName"`
+	Commit         string    `json:"commit"`
+	PreviousCommit string    `json:"previousCommit,omitempty"`
+	Branch         string    `json:"branch"`
+	Ref            string    `json:"ref,omitempty"`
+	StartedAt      time.Time `json:"startedAt"`
+	FinishedAt     time.Time `json:"finishedAt"`
+	Status         string    `json:"status"`
+	ExitCode       int       `json:"exitCode"`
+	LogPath        string    `json:"logPath"`
+	TriggeredBy    string    `json:"triggeredBy"`
+	RollbackOf     string    `json:"rollbackOf,omitempty"`
+}
+
+// deploymentHistory is the on-disk list of deployments for a single project, oldest first.
+type deploymentHistory struct {
+	Deployments []Deployment `json:"deployments"`
+}
+
+// getDeploymentHistoryPath returns the path to the history file of the given project.
+func getDeploymentHistoryPath(projectName string) string {
+	return path.Join(consts.GetBasePath(), "deploy_history", projectName+".json")
+}
+
+// readDeploymentHistory reads the deployment history of a project.
+// A project that was never deployed has an empty history.
+func readDeploymentHistory(projectName string) (deploymentHistory, error) {
+	f, err := os.ReadFile(getDeploymentHistoryPath(projectName))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return deploymentHistory{}, nil
+		}
+		return deploymentHistory{}, fmt.Errorf("failed to read deployment history: %w", err)
+	}
+
+	var h deploymentHistory
+	if err := json.Unmarshal(f, &h); err != nil {
+		return deploymentHistory{}, fmt.Errorf("failed to unmarshal deployment history: %w", err)
+	}
+
+	return h, nil
+}
+
+// save writes the deployment history of a project to disk.
+func (h deploymentHistory) save(projectName string) error {
+	f, err := json.MarshalIndent(h, "", " ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal deployment history: %w", err)
+	}
+
+	if err := os.MkdirAll(path.Join(consts.GetBasePath(), "deploy_history"), 0755); err != nil {
+		return fmt.Errorf("failed to create deployment history directory: %w", err)
+	}
+
+	if err := os.WriteFile(getDeploymentHistoryPath(projectName), f, 0644); err != nil {
+		return fmt.Errorf("failed to write deployment history: %w", err)
+	}
+
+	return nil
+}
+
+// recordDeployment adds the deployment to its project's history, replacing an earlier record with the same ID.
+// Only the latest maxDeploymentHistory deployments are kept.
+func recordDeployment(d Deployment) error {
+	h, err := readDeploymentHistory(d.ProjectName)
+	if err != nil {
+		return err
+	}
+
+	replaced := false
+	for i, existing := range h.Deployments {
+		if existing.DeploymentID == d.DeploymentID {
+			h.Deployments[i] = d
+			replaced = true
+			break
+		}
+	}
+
+	if !replaced {
+		h.Deployments = append(h.Deployments, d)
+	}
+
+	if len(h.Deployments) > maxDeploymentHistory {
+		h.Deployments = h.Deployments[len(h.Deployments)-maxDeploymentHistory:]
+	}
+
+	return h.save(d.ProjectName)
+}
+
+// ListDeployments returns the deployment history of a project, newest first.
+func ListDeployments(projectNOI string) ([]Deployment, error) {
+	p, err := FindProject(projectNOI)
+	if err != nil {
+		return nil, err
+	}
+
+	h, err := readDeploymentHistory(p.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	deployments := make([]Deployment, 0, len(h.Deployments))
+	for i := len(h.Deployments) - 1; i >= 0; i-- {
+		deployments = append(deployments, h.Deployments[i])
+	}
+
+	return deployments, nil
+}
+
+// findDeployment looks up a deployment of the given project by its ID.
+func findDeployment(projectName, deploymentID string) (Deployment, error) {
+	h, err := readDeploymentHistory(projectName)
+	if err != nil {
+		return Deployment{}, err
+	}
+
+	for _, d := range h.Deployments {
+		if d.DeploymentID == deploymentID {
+			return d, nil
+		}
+	}
+
+	return Deployment{}, notFoundError{fmt.Sprintf("deployment %s was not found for project %s\nYou can use the \"mole deploy history\" command to see all deployments", deploymentID, projectName)}
+}
+
+// triggeredBy identifies who started a deployment.
+// MOLE_TRIGGERED_BY takes precedence so automation can name itself, otherwise the local user
+// is used together with the SSH client address, since everyone logs in as the same user.
+func triggeredBy() string {
+	if by := os.Getenv("MOLE_TRIGGERED_BY"); by != "" {
+		return by
+	}
+
+	name := "unknown"
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+
+	if client := strings.Fields(os.Getenv("SSH_CLIENT")); len(client) > 0 {
+		return name + "@" + client[0]
+	}
+
+	return name
+}
+
+// shortCommit shortens a commit SHA for display.
+func shortCommit(sha string) string {
+	if len(sha) > 7 {
+		return sha[:7]
+	}
+	return sha
+}
+
+// Stringify returns a string representation of the Deployment.
+func (d Deployment) Stringify() string {
+	var b strings.Builder
+	b.WriteString("\n")
+	b.WriteString(" |ID       : " + d.DeploymentID + "\n")
+	b.WriteString(" |Status   : " + d.Status + " (exit " + strconv.Itoa(d.ExitCode) + ")\n")
+	b.WriteString(" |Commit   : " + shortCommit(d.Commit) + "\n")
+	b.WriteString(" |Branch   : " + d.Branch + "\n")
+	b.WriteString(" |Started  : " + d.StartedAt.Format(time.RFC3339) + "\n")
+	b.WriteString(" |Finished : " + d.FinishedAt.Format(time.RFC3339) + "\n")
+	b.WriteString(" |By       : " + d.TriggeredBy + "\n")
+	b.WriteString(" |Log      : " + d.LogPath + "\n")
+	if d.RollbackOf != "" {
+		b.WriteString(" |Rollback : " + d.RollbackOf + "\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,121 @@
+package actions
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zulubit/mole/pkg/consts"
+)
+
+func TestRecordAndListDeployments(t *testing.T) {
+	consts.Testing = true
+
+	tmp := t.TempDir()
+	consts.BasePath = tmp
+
+	np := Project{
+		Name: "test-project",
+	}
+	err := addProject(np)
+	assert.Nil(t, err, "project should be added")
+
+	deployments, err := ListDeployments(np.Name)
+	assert.Nil(t, err, "empty history can be listed")
+	assert.Empty(t, deployments, "no deployments were recorded yet")
+
+	err = recordDeployment(Deployment{DeploymentID: "one", ProjectName: np.Name, Status: "running"})
+	assert.Nil(t, err, "first deployment recorded")
+	err = recordDeployment(Deployment{DeploymentID: "two", ProjectName: np.Name, Status: "success"})
+	assert.Nil(t, err, "second deployment recorded")
+	err = recordDeployment(Deployment{DeploymentID: "one", ProjectName: np.Name, Status: "failure"})
+	assert.Nil(t, err, "first deployment updated")
+
+	deployments, err = ListDeployments(np.Name)
+	assert.Nil(t, err, "history can be listed")
+	assert.Len(t, deployments, 2, "updating a deployment does not duplicate it")
+	assert.Equal(t, "two", deployments[0].DeploymentID, "newest deployment is listed first")
+	assert.Equal(t, "failure", deployments[1].Status, "updated status is stored")
+
+	_, err = findDeployment(np.Name, "missing")
+	assert.ErrorContains(t, err, "deployment missing was not found", "unknown deployment is reported")
+	assert.ErrorIs(t, err, ErrNotFound, "unknown deployment is not found")
+}
+
+func TestRollbackDeployment(t *testing.T) {
+	// git operations are skipped in testing mode, rollbacks depend on them
+	consts.Testing = false
+	defer func() { consts.Testing = true }()
+
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	tmp := t.TempDir()
+	consts.BasePath = tmp
+
+	projectName := "test-project"
+	np := Project{
+		Name:   projectName,
+		Branch: "main",
+	}
+	addProject(np)
+
+	err := createProjectSecretsJson(np)
+	assert.Nil(t, err, "Failed to setup project secrets")
+
+	projectDir := path.Join(tmp, "projects", projectName)
+	os.MkdirAll(projectDir, 0755)
+
+	commit := func(message string) {
+		err := os.WriteFile(path.Join(projectDir, "mole.sh"), []byte("#!/bin/bash\necho '"+message+"'"), 0755)
+		assert.Nil(t, err, "mole.sh written")
+		_, err = runGit(projectDir, "add", "mole.sh")
+		assert.Nil(t, err, "mole.sh staged")
+		_, err = runGit(projectDir, "-c", "user.name=mole", "-c", "user.email=mole@example.com", "commit", "-m", message)
+		assert.Nil(t, err, "commit created")
+	}
+
+	_, err = runGit(projectDir, "init", "-b", "main")
+	assert.Nil(t, err, "repository initialised")
+	// deployments fetch the branch from origin, the repository serves as its own
+	_, err = runGit(projectDir, "remote", "add", "origin", "file://"+projectDir)
+	assert.Nil(t, err, "origin added")
+
+	var output bytes.Buffer
+
+	commit("first release")
+	_, err = RunDeployment(projectName, DeployOptions{Output: &output})
+	assert.Nil(t, err, "first deployment succeeds")
+	assert.Contains(t, output.String(), "first release")
+
+	commit("second release")
+	output.Reset()
+	_, err = RunDeployment(projectName, DeployOptions{Output: &output})
+	assert.Nil(t, err, "second deployment succeeds")
+	assert.Contains(t, output.String(), "second release")
+
+	deployments, err := ListDeployments(projectName)
+	assert.Nil(t, err, "history can be listed")
+	assert.Len(t, deployments, 2, "both deployments are recorded")
+	assert.NotEqual(t, deployments[0].Commit, deployments[1].Commit, "each deployment records its commit")
+	assert.Equal(t, "success", deployments[1].Status)
+	assert.FileExists(t, deployments[1].LogPath, "deployment log is recorded")
+
+	output.Reset()
+	_, err = RollbackDeployment(projectName, deployments[1].DeploymentID, &output)
+	assert.Nil(t, err, "rollback succeeds")
+	assert.Contains(t, output.String(), "first release", "rollback deploys the earlier commit")
+
+	deployments, err = ListDeployments(projectName)
+	assert.Nil(t, err, "history can be listed")
+	assert.Len(t, deployments, 3, "rollback is recorded as a deployment")
+	assert.Equal(t, deployments[2].Commit, deployments[0].Commit, "rollback records the commit it deployed")
+	assert.Equal(t, deployments[2].DeploymentID, deployments[0].RollbackOf, "rollback references the deployment it restored")
+
+	_, err = RollbackDeployment(projectName, "missing", nil)
+	assert.Error(t, err, "rolling back to an unknown deployment fails")
+}
@@ -0,0 +1,262 @@
+package actions
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/zulubit/mole/pkg/consts"
+	"github.com/zulubit/mole/pkg/helpers"
+	"gopkg.in/yaml.v3"
+)
+
+// manifestFile is the name of the optional manifest in the root of a project.
+const manifestFile = "mole.yaml"
+
+// manifestVersion is the latest version of mole.yaml this version of mole understands.
+const manifestVersion = 1
+
+// maxProjectPorts is the number of ports reserved for every project, PortApp, PortTwo and PortThree.
+const maxProjectPorts = 3
+
+// Manifest is the mole.yaml of a project, documented in docs/manifest.md.
+type Manifest struct {
+	// Version is the version of the manifest format, 1 if it is not set.
+	Version int `yaml:"version,omitempty" json:"version,omitempty"`
+	// Deploy is the deployment script template, mole.sh if it is not set.
+	Deploy string `yaml:"deploy,omitempty" json:"deploy,omitempty"`
+	// Compose is the docker compose template, mole-compose.yaml if it is not set.
+	Compose   string         `yaml:"compose,omitempty" json:"compose,omitempty"`
+	Templates []TemplateFile `yaml:"templates,omitempty" json:"templates,omitempty"`
+	// HealthCheck is used when no health check is configured for the project with "mole projects healthcheck".
+	HealthCheck *HealthCheck `yaml:"healthCheck,omitempty" json:"healthCheck,omitempty"`
+	// Domains are the domains the app is served on.
+	Domains []string `yaml:"domains,omitempty" json:"domains,omitempty"`
+	// Secrets are the custom secrets that have to be set before the project can be deployed.
+	Secrets []string `yaml:"secrets,omitempty" json:"secrets,omitempty"`
+	// Ports is the number of reserved ports the app uses, from PortApp on.
+	Ports int `yaml:"ports,omitempty" json:"ports,omitempty"`
+}
+
+// TemplateFile is a file of a project rendered with the project secrets on every deployment.
+type TemplateFile struct {
+	Source string `yaml:"source" json:"source"`
+	Target string `yaml:"target" json:"target"`
+	// Mode is the octal file mode of the target, e.g. "0600". Defaults to the mode of the source.
+	Mode string `yaml:"mode,omitempty" json:"mode,omitempty"`
+}
+
+// UnmarshalYAML accepts a plain path ending in .tmpl as a shorthand for a template rendered next to it without the suffix.
+func (t *TemplateFile) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		if !strings.HasSuffix(node.Value, ".tmpl") {
+			return fmt.Errorf("line %d: template %s needs a target, or has to end in .tmpl", node.Line, node.Value)
+		}
+		t.Source = node.Value
+		t.Target = strings.TrimSuffix(node.Value, ".tmpl")
+		return nil
+	}
+
+	// node.Decode does not inherit KnownFields from the decoder, so unknown fields are checked here
+	if node.Kind == yaml.MappingNode {
+		for i := 0; i < len(node.Content); i += 2 {
+			switch key := node.Content[i]; key.Value {
+			case "source", "target", "mode":
+			default:
+				return fmt.Errorf("line %d: field %s not found in template", key.Line, key.Value)
+			}
+		}
+	}
+
+	type plain TemplateFile
+	return node.Decode((*plain)(t))
+}
+
+// getManifestPath returns the path to the manifest of a project.
+func getManifestPath(projectName string) string {
+	return path.Join(consts.GetBasePath(), "projects", projectName, manifestFile)
+}
+
+// readManifest reads and validates the manifest of a project. A project without mole.yaml has an empty manifest.
+func readManifest(p Project) (Manifest, error) {
+	content, err := os.ReadFile(getManifestPath(p.Name))
+	if errors.Is(err, os.ErrNotExist) {
+		return Manifest{}, nil
+	}
+	if err != nil {
+		return Manifest{}, fmt.Errorf("failed to read %s: %w", manifestFile, err)
+	}
+
+	m, err := parseManifest(content)
+	if err != nil {
+		return Manifest{}, fmt.Errorf("invalid %s: %w", manifestFile, err)
+	}
+
+	return m, nil
+}
+
+// parseManifest decodes and validates a manifest, unknown fields are an error so typos do not go unnoticed.
+func parseManifest(content []byte) (Manifest, error) {
+	var m Manifest
+
+	dec := yaml.NewDecoder(bytes.NewReader(content))
+	dec.KnownFields(true)
+	if err := dec.Decode(&m); err != nil && !errors.Is(err, io.EOF) {
+		return Manifest{}, err
+	}
+
+	if err := m.validate(); err != nil {
+		return Manifest{}, err
+	}
+
+	if m.HealthCheck != nil {
+		hc, err := NewHealthCheck(m.HealthCheck.Path, m.HealthCheck.ExpectedStatus, m.HealthCheck.Retries, m.HealthCheck.Interval, m.HealthCheck.Rollback)
+		if err != nil {
+			return Manifest{}, fmt.Errorf("healthCheck: %w", err)
+		}
+		m.HealthCheck = hc
+	}
+
+	return m, nil
+}
+
+// deployScript returns the deployment script template of the project.
+func (m Manifest) deployScript() string {
+	if m.Deploy == "" {
+		return "mole.sh"
+	}
+	return m.Deploy
+}
+
+// composeFile returns the docker compose template of the project.
+func (m Manifest) composeFile() string {
+	if m.Compose == "" {
+		return "mole-compose.yaml"
+	}
+	return m.Compose
+}
+
+// missingSecrets returns the secrets required by the manifest that are not set, in the order they are declared.
+func (m Manifest) missingSecrets(s *projectSecrets) []string {
+	missing := []string{}
+	for _, key := range m.Secrets {
+		if _, ok := s.Custom[key]; !ok {
+			missing = append(missing, key)
+		}
+	}
+	return missing
+}
+
+// checkSecrets fails when secrets required by the manifest are not set.
+func (m Manifest) checkSecrets(s *projectSecrets) error {
+	missing := m.missingSecrets(s)
+	if len(missing) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%s requires the secrets %s, set them with mole secrets set", manifestFile, strings.Join(missing, ", "))
+}
+
+// validate checks the manifest for mistakes that can be found without rendering it.
+func (m Manifest) validate() error {
+	if m.Version < 0 {
+		return fmt.Errorf("invalid version %d", m.Version)
+	}
+	if m.Version > manifestVersion {
+		return fmt.Errorf("version %d requires a newer version of mole, this one supports version %d", m.Version, manifestVersion)
+	}
+
+	// files rendered by mole itself can not be templates or their targets
+	rendered := map[string]bool{"mole-ready.sh": true, "mole-compose-ready.yaml": true}
+	for _, hook := range deployHooks {
+		rendered[hook.Ready] = true
+	}
+
+	for _, f := range []struct{ field, path string }{{"deploy", m.Deploy}, {"compose", m.Compose}} {
+		if f.path == "" {
+			continue
+		}
+		if !insideProject(f.path) {
+			return fmt.Errorf("%s path %s has to be relative and stay inside the project", f.field, f.path)
+		}
+		if rendered[filepath.Clean(f.path)] {
+			return fmt.Errorf("%s can not be %s, it is rendered by mole", f.field, f.path)
+		}
+	}
+
+	targets := map[string]bool{}
+
+	for _, t := range m.Templates {
+		if t.Source == "" || t.Target == "" {
+			return errors.New("templates need a source and a target")
+		}
+
+		for _, p := range []string{t.Source, t.Target} {
+			if !insideProject(p) {
+				return fmt.Errorf("template path %s has to be relative and stay inside the project", p)
+			}
+		}
+
+		if filepath.Clean(t.Source) == filepath.Clean(t.Target) {
+			return fmt.Errorf("template %s would overwrite itself", t.Source)
+		}
+
+		if rendered[filepath.Clean(t.Target)] {
+			return fmt.Errorf("template %s can not render to %s, it is rendered by mole", t.Source, t.Target)
+		}
+
+		if targets[filepath.Clean(t.Target)] {
+			return fmt.Errorf("more than one template renders to %s", t.Target)
+		}
+		targets[filepath.Clean(t.Target)] = true
+
+		if t.Mode != "" {
+			if _, err := t.fileMode(); err != nil {
+				return err
+			}
+		}
+	}
+
+	domains := map[string]bool{}
+	for _, d := range m.Domains {
+		if !helpers.ValidateCaddyDomain(d) {
+			return fmt.Errorf("invalid domain %s", d)
+		}
+		if domains[strings.ToLower(d)] {
+			return fmt.Errorf("domain %s is listed more than once", d)
+		}
+		domains[strings.ToLower(d)] = true
+	}
+
+	for _, key := range m.Secrets {
+		if err := validateSecretKey(key); err != nil {
+			return err
+		}
+	}
+
+	if m.Ports < 0 || m.Ports > maxProjectPorts {
+		return fmt.Errorf("invalid ports %d, mole reserves %d ports per project", m.Ports, maxProjectPorts)
+	}
+
+	return nil
+}
+
+// insideProject reports whether a path from the manifest is relative and does not leave the project directory.
+func insideProject(p string) bool {
+	clean := filepath.Clean(p)
+	return !filepath.IsAbs(clean) && clean != "." && clean != ".." && !strings.HasPrefix(clean, "../")
+}
+
+// fileMode parses the mode of the template target.
+func (t TemplateFile) fileMode() (os.FileMode, error) {
+	mode, err := strconv.ParseUint(t.Mode, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, fmt.Errorf("invalid mode %s of template %s, use an octal mode such as 0644", t.Mode, t.Source)
+	}
+	return os.FileMode(mode), nil
+}
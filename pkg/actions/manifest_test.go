@@ -0,0 +1,72 @@
+package actions
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseManifest(t *testing.T) {
+	m, err := parseManifest([]byte(`
+templates:
+  - nginx.conf.tmpl
+  - source: config/prod.yaml.tmpl
+    target: config/prod.yaml
+    mode: "0600"
+`))
+	assert.Nil(t, err, "manifest parsed")
+	assert.Equal(t, []TemplateFile{
+		{Source: "nginx.conf.tmpl", Target: "nginx.conf"},
+		{Source: "config/prod.yaml.tmpl", Target: "config/prod.yaml", Mode: "0600"},
+	}, m.Templates)
+
+	m, err = parseManifest([]byte(`
+version: 1
+deploy: deploy/mole.sh
+compose: docker/compose.yaml
+healthCheck:
+  path: healthz
+  rollback: true
+domains:
+  - example.com
+secrets:
+  - SMTP_PASSWORD
+ports: 2
+`))
+	assert.Nil(t, err, "full manifest parsed")
+	assert.Equal(t, "deploy/mole.sh", m.deployScript())
+	assert.Equal(t, "docker/compose.yaml", m.composeFile())
+	assert.Equal(t, &HealthCheck{Path: "/healthz", ExpectedStatus: 200, Retries: 10, Interval: "3s", Rollback: true}, m.HealthCheck, "health check defaults are filled in")
+	assert.Equal(t, []string{"example.com"}, m.Domains)
+	assert.Equal(t, []string{"SMTP_PASSWORD"}, m.missingSecrets(&projectSecrets{}), "required secrets are reported as missing")
+	assert.Empty(t, m.missingSecrets(&projectSecrets{Custom: map[string]string{"SMTP_PASSWORD": "x"}}))
+
+	m, err = parseManifest([]byte{})
+	assert.Nil(t, err, "empty manifest is valid")
+	assert.Empty(t, m.Templates)
+
+	invalid := map[string]string{
+		"templates:\n  - nginx.conf":                                   "needs a target",
+		"templates:\n  - source: a.tmpl\n    target: /etc/a":           "stay inside the project",
+		"templates:\n  - source: ../a.tmpl\n    target: a":             "stay inside the project",
+		"templates:\n  - source: a\n    target: ./a":                   "overwrite itself",
+		"templates:\n  - a.tmpl\n  - source: b\n    target: a":         "more than one template",
+		"templates:\n  - source: a.tmpl\n    target: a\n    mode: 999": "invalid mode",
+		"templates:\n  - source: a.tmpl\n    taget: a":                 "field taget not found",
+		"tempaltes:\n  - a.tmpl":                                       "field tempaltes not found",
+		"version: 2":                                                   "requires a newer version of mole",
+		"deploy: ../mole.sh":                                           "stay inside the project",
+		"compose: mole-compose-ready.yaml":                             "rendered by mole",
+		"templates:\n  - source: a\n    target: mole-ready.sh":         "rendered by mole",
+		"healthCheck:\n  expectedStatus: 42":                           "invalid expected status",
+		"healthCheck:\n  paht: /":                                      "field paht not found",
+		"domains:\n  - not a domain":                                   "invalid domain",
+		"domains:\n  - a.com\n  - A.com":                               "more than once",
+		"secrets:\n  - 1KEY":                                           "invalid secret key",
+		"ports: 4":                                                     "reserves 3 ports",
+	}
+	for content, expected := range invalid {
+		_, err := parseManifest([]byte(content))
+		assert.ErrorContains(t, err, expected, content)
+	}
+}
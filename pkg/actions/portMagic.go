@@ -7,6 +7,7 @@ import (
 	"os"
 	"path"
 	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/zulubit/mole/pkg/consts"
@@ -115,23 +116,33 @@ func getReservedAndUsedPorts() (ports, error) {
 	return uniquePorts, nil
 }
 
-// PortReport generates a comma-separated report of all active TCP ports, sorted in ascending order.
-func PortReport() (string, error) {
+// ListActivePorts returns the local ports of all active TCP connections, sorted in ascending order.
+func ListActivePorts() ([]int, error) {
 	connections, err := net.Connections("tcp")
 	if err != nil {
-		return "", fmt.Errorf("failed to retrieve TCP connections: %w", err)
+		return nil, fmt.Errorf("failed to retrieve TCP connections: %w", err)
 	}
 
-	uniquePorts := map[string]bool{}
+	uniquePorts := map[int]bool{}
 	for _, conn := range connections {
-		uniquePorts[fmt.Sprintf("%d", conn.Laddr.Port)] = true
+		uniquePorts[int(conn.Laddr.Port)] = true
 	}
 
-	sortedPorts := []string{}
+	sortedPorts := []int{}
 	for port := range uniquePorts {
 		sortedPorts = append(sortedPorts, port)
 	}
 
-	sort.Strings(sortedPorts)
-	return strings.Join(sortedPorts, ", "), nil
+	sort.Ints(sortedPorts)
+	return sortedPorts, nil
+}
+
+// PortReport generates a comma-separated report of the active TCP ports listed by ListActivePorts.
+func PortReport(activePorts []int) string {
+	sortedPorts := []string{}
+	for _, port := range activePorts {
+		sortedPorts = append(sortedPorts, strconv.Itoa(port))
+	}
+
+	return strings.Join(sortedPorts, ", ")
 }
@@ -11,7 +11,7 @@ import (
 func TestSaveReservedPorts(t *testing.T) {
 	consts.Testing = true
 
-	tmp := os.TempDir()
+	tmp := t.TempDir()
 	consts.BasePath = tmp
 	defer os.RemoveAll(tmp)
 
@@ -24,7 +24,7 @@ func TestSaveReservedPorts(t *testing.T) {
 func TestGetReservedAndUsedPorts(t *testing.T) {
 	consts.Testing = true
 
-	tmp := os.TempDir()
+	tmp := t.TempDir()
 	consts.BasePath = tmp
 	defer os.RemoveAll(tmp)
 
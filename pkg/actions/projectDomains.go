@@ -0,0 +1,534 @@
+package actions
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/zulubit/mole/pkg/helpers"
+)
+
+// Kinds of domains, a proxy domain forwards to a port of the project, a static domain serves files of the project
+// and a redirect domain redirects to another URL.
+const (
+	DomainProxy    = "proxy"
+	DomainStatic   = "static"
+	DomainRedirect = "redirect"
+)
+
+// Policies for www.<domain>, apex redirects it to the domain, www redirects the domain to it and none leaves it out.
+const (
+	WWWApex = "apex"
+	WWWWWW  = "www"
+	WWWNone = "none"
+)
+
+// redirectStatuses are the status codes a redirect can use.
+var redirectStatuses = []int{301, 302, 303, 307, 308}
+
+// Domain is a domain a project is served on. All domains of a project are rendered into its Caddy partial.
+type Domain struct {
+	Domain string `json:"domain"`
+	Kind   string `json:"kind"`
+	// Port is the upstream port of a proxy domain, 0 for MOLE_PORT_APP or the live slot of a blue/green project.
+	Port int `json:"port,omitempty"`
+	// Location is the directory a static domain serves, relative to the project.
+	Location string `json:"location,omitempty"`
+	// Primary marks the main domain of the project, it is listed and rendered first.
+	Primary bool `json:"primary,omitempty"`
+	// Aliases are served the same way as the domain.
+	Aliases []string `json:"aliases,omitempty"`
+	// Redirects are redirected to the domain, next to www.<domain> unless WWW leaves it out.
+	Redirects []string `json:"redirects,omitempty"`
+	// WWW is the policy for www.<domain>, empty is WWWApex. Wildcard domains never have a www host.
+	WWW string `json:"www,omitempty"`
+	// RedirectStatus is the status code of the redirects, 0 for the default of Caddy, a temporary 302.
+	RedirectStatus int `json:"redirectStatus,omitempty"`
+	// Target is the URL a redirect domain redirects to. Without a path, the path of the request is kept.
+	Target string `json:"target,omitempty"`
+	// Routes send the requests to paths of the domain elsewhere, the rest are handled by the domain itself.
+	Routes []Route `json:"routes,omitempty"`
+}
+
+// Route sends the requests to a path of a domain to another port, or serves them from a directory of the project.
+type Route struct {
+	// Path is a Caddy path matcher, e.g. /api/*.
+	Path string `json:"path"`
+	Kind string `json:"kind"`
+	// Port is the port a proxy route forwards to, PortApp, PortTwo, PortThree or a port number. Defaults to PortApp.
+	Port string `json:"port,omitempty"`
+	// Location is the directory a static route serves, relative to the project. The path is kept, so with the
+	// location public, /assets/app.css is served from public/assets/app.css.
+	Location string `json:"location,omitempty"`
+}
+
+// validRoutePath matches the paths a route can match, a leading slash and no whitespace, quotes or braces.
+var validRoutePath = regexp.MustCompile(`^/[^\s"'{}#]*$`)
+
+// validate checks a route before it is added to a domain.
+func (r Route) validate() error {
+	if !validRoutePath.MatchString(r.Path) {
+		return fmt.Errorf("invalid path %s, use a path starting with / such as /api/*", r.Path)
+	}
+
+	switch r.Kind {
+	case DomainProxy:
+		if r.Location != "" {
+			return errors.New("a route forwards to a port or serves a location, not both")
+		}
+		switch r.Port {
+		case "", "PortApp", "PortTwo", "PortThree":
+		default:
+			if n, err := strconv.Atoi(r.Port); err != nil || n < 1 || n > 65535 {
+				return fmt.Errorf("invalid port %s, use PortApp, PortTwo, PortThree or a port number", r.Port)
+			}
+		}
+	case DomainStatic:
+		if r.Port != "" {
+			return errors.New("a route forwards to a port or serves a location, not both")
+		}
+		if r.Location != "" && !insideProject(r.Location) {
+			return fmt.Errorf("location %s has to be relative and stay inside the project", r.Location)
+		}
+	default:
+		return fmt.Errorf("invalid kind of route %s, use %s or %s", r.Kind, DomainProxy, DomainStatic)
+	}
+
+	return nil
+}
+
+// ProjectDomains are the domains of a project.
+type ProjectDomains struct {
+	ProjectName string   `json:"projectName"`
+	Domains     []Domain `json:"domains"`
+}
+
+// hosts returns every host the domain answers to, redirects included.
+func (d Domain) hosts() []string {
+	return append(d.siteHosts(), d.redirectHosts()...)
+}
+
+// canonicalHost returns the host the domain is served on, www.<domain> with the www policy.
+func (d Domain) canonicalHost() string {
+	if d.WWW == WWWWWW {
+		return "www." + d.Domain
+	}
+	return d.Domain
+}
+
+// siteHosts returns the hosts serving the domain, the canonical one and the aliases.
+func (d Domain) siteHosts() []string {
+	return append([]string{d.canonicalHost()}, d.Aliases...)
+}
+
+// redirectHosts returns the hosts redirected to the canonical one, the www host or the domain itself first.
+func (d Domain) redirectHosts() []string {
+	hosts := []string{}
+	switch {
+	case d.WWW == WWWWWW:
+		hosts = append(hosts, d.Domain)
+	case d.WWW != WWWNone && !strings.HasPrefix(d.Domain, "*."):
+		hosts = append(hosts, "www."+d.Domain)
+	}
+	return append(hosts, d.Redirects...)
+}
+
+// redirectTarget returns the URL the redirects of the domain point at, with the Caddy placeholder for the path of the request.
+func (d Domain) redirectTarget() string {
+	if d.Kind != DomainRedirect {
+		return "https://" + d.canonicalHost() + "{uri}"
+	}
+
+	target, _ := url.Parse(d.Target)
+	if target.Path == "" || target.Path == "/" {
+		return strings.TrimSuffix(d.Target, "/") + "{uri}"
+	}
+	return d.Target
+}
+
+// validate checks a domain before it is added to a project.
+func (d Domain) validate() error {
+	for _, host := range append([]string{d.Domain}, append(d.Aliases, d.Redirects...)...) {
+		if !helpers.ValidateCaddyDomain(host) {
+			return fmt.Errorf("invalid domain format: %s", host)
+		}
+	}
+
+	switch d.Kind {
+	case DomainProxy:
+		if d.Port < 0 || d.Port > 65535 {
+			return fmt.Errorf("invalid port %d", d.Port)
+		}
+	case DomainStatic:
+		if d.Location != "" && !insideProject(d.Location) {
+			return fmt.Errorf("location %s has to be relative and stay inside the project", d.Location)
+		}
+	case DomainRedirect:
+		target, err := url.Parse(d.Target)
+		validURL := err == nil && (target.Scheme == "http" || target.Scheme == "https") && target.Host != ""
+		if !validURL || strings.ContainsAny(d.Target, " \t\n\"'{}#") {
+			return fmt.Errorf("invalid redirect target %s, use a URL such as https://example.com", d.Target)
+		}
+		if d.WWW == WWWWWW {
+			return errors.New("a redirect domain is not served, it can not redirect to its www host")
+		}
+		if len(d.Routes) > 0 {
+			return errors.New("a redirect domain has no routes")
+		}
+	default:
+		return fmt.Errorf("invalid kind of domain %s, use %s, %s or %s", d.Kind, DomainProxy, DomainStatic, DomainRedirect)
+	}
+
+	switch d.WWW {
+	case "", WWWNone:
+	case WWWApex, WWWWWW:
+		if strings.HasPrefix(d.Domain, "*.") {
+			return fmt.Errorf("wildcard domain %s has no www host, use the www policy %s", d.Domain, WWWNone)
+		}
+	default:
+		return fmt.Errorf("invalid www policy %s, use %s, %s or %s", d.WWW, WWWApex, WWWWWW, WWWNone)
+	}
+
+	if d.RedirectStatus != 0 && !slices.Contains(redirectStatuses, d.RedirectStatus) {
+		return fmt.Errorf("invalid redirect status %d, use 301, 302, 303, 307 or 308", d.RedirectStatus)
+	}
+
+	hosts := d.hosts()
+	for i, host := range hosts {
+		if slices.IndexFunc(hosts[:i], func(h string) bool { return strings.EqualFold(h, host) }) >= 0 {
+			return fmt.Errorf("domain %s is listed more than once", host)
+		}
+	}
+
+	return nil
+}
+
+// updateProjectDomains applies fn to a project found by its name or ID, holding the project store lock, and returns the project.
+// fn also receives the other projects, to check the domains against. Nothing is saved when fn returns an error.
+func updateProjectDomains(projectNOI string, fn func(p *Project, others []Project) error) (Project, error) {
+	var updated Project
+	err := updateProjects(func(ps *Projects) error {
+		for i, pro := range ps.Projects {
+			if strings.EqualFold(pro.Name, projectNOI) || pro.ProjectID == projectNOI {
+				others := append(slices.Clone(ps.Projects[:i]), ps.Projects[i+1:]...)
+				if err := fn(&ps.Projects[i], others); err != nil {
+					return err
+				}
+				updated = ps.Projects[i]
+				return nil
+			}
+		}
+
+		return notFoundError{fmt.Sprintf("project with ID %s not found", projectNOI)}
+	})
+
+	return updated, err
+}
+
+// AddDomain adds a domain to a project, or replaces the domain of the project with the same name,
+// renders all domains of the project into its Caddy partial and applies it to the running Caddy.
+// The first domain of a project is its primary one. A domain can only be used by one project.
+func AddDomain(projectNOI string, d Domain) error {
+	if err := d.validate(); err != nil {
+		return err
+	}
+
+	p, err := updateProjectDomains(projectNOI, func(p *Project, others []Project) error {
+		taken := map[string]string{}
+		for _, other := range others {
+			for _, od := range other.Domains {
+				for _, host := range od.hosts() {
+					taken[strings.ToLower(host)] = fmt.Sprintf("%s of project %s", od.Domain, other.Name)
+				}
+			}
+		}
+		for _, pd := range p.Domains {
+			if strings.EqualFold(pd.Domain, d.Domain) {
+				continue
+			}
+			for _, host := range pd.hosts() {
+				taken[strings.ToLower(host)] = pd.Domain
+			}
+		}
+		for _, host := range d.hosts() {
+			if owner, ok := taken[strings.ToLower(host)]; ok {
+				return fmt.Errorf("domain %s is already used by %s", host, owner)
+			}
+		}
+
+		i := slices.IndexFunc(p.Domains, func(pd Domain) bool { return strings.EqualFold(pd.Domain, d.Domain) })
+		if i >= 0 {
+			// routes are managed with AddDomainRoute, replacing a domain keeps them
+			d.Primary = d.Primary || p.Domains[i].Primary
+			if d.Routes == nil && d.Kind != DomainRedirect {
+				d.Routes = p.Domains[i].Routes
+			}
+			p.Domains[i] = d
+		} else {
+			p.Domains = append(p.Domains, d)
+			i = len(p.Domains) - 1
+		}
+
+		if d.Primary || len(p.Domains) == 1 {
+			for j := range p.Domains {
+				p.Domains[j].Primary = false
+			}
+			primary := p.Domains[i]
+			primary.Primary = true
+			p.Domains = append([]Domain{primary}, slices.Delete(p.Domains, i, i+1)...)
+		}
+
+		return renderProjectDomains(*p)
+	})
+	if err != nil {
+		return err
+	}
+
+	return applyDomainPartial(p.Name)
+}
+
+// RemoveDomain removes a domain from a project and renders the remaining ones. The domain may also be an alias or
+// a redirect of a domain of the project, then only that is removed. Without domains left, the partial of the project is removed.
+func RemoveDomain(projectNOI, domain string) error {
+	p, err := updateProjectDomains(projectNOI, func(p *Project, _ []Project) error {
+		equal := func(h string) bool { return strings.EqualFold(h, domain) }
+
+		for i, d := range p.Domains {
+			switch {
+			case equal(d.Domain):
+				p.Domains = slices.Delete(p.Domains, i, i+1)
+				if d.Primary && len(p.Domains) > 0 {
+					p.Domains[0].Primary = true
+				}
+			case slices.ContainsFunc(d.Aliases, equal):
+				p.Domains[i].Aliases = slices.DeleteFunc(slices.Clone(d.Aliases), equal)
+			case slices.ContainsFunc(d.Redirects, equal):
+				p.Domains[i].Redirects = slices.DeleteFunc(slices.Clone(d.Redirects), equal)
+			default:
+				continue
+			}
+
+			return renderProjectDomains(*p)
+		}
+
+		return notFoundError{fmt.Sprintf("project %s has no domain %s", p.Name, domain)}
+	})
+	if err != nil {
+		return err
+	}
+
+	return applyDomainPartial(p.Name)
+}
+
+// AddDomainRoute adds a route to a domain of a project, or replaces the route of the domain with the same path,
+// renders the domains of the project into its partial and applies it to the running Caddy.
+func AddDomainRoute(projectNOI, domain string, r Route) error {
+	if err := r.validate(); err != nil {
+		return err
+	}
+
+	return updateDomainRoutes(projectNOI, domain, func(d *Domain) error {
+		if d.Kind == DomainRedirect {
+			return fmt.Errorf("domain %s redirects to %s, it has no routes", d.Domain, d.Target)
+		}
+
+		i := slices.IndexFunc(d.Routes, func(dr Route) bool { return dr.Path == r.Path })
+		if i >= 0 {
+			d.Routes[i] = r
+		} else {
+			d.Routes = append(d.Routes, r)
+		}
+		return nil
+	})
+}
+
+// RemoveDomainRoute removes the route with the path from a domain of a project and applies the partial of the project.
+func RemoveDomainRoute(projectNOI, domain, routePath string) error {
+	return updateDomainRoutes(projectNOI, domain, func(d *Domain) error {
+		i := slices.IndexFunc(d.Routes, func(dr Route) bool { return dr.Path == routePath })
+		if i < 0 {
+			return notFoundError{fmt.Sprintf("domain %s has no route %s", d.Domain, routePath)}
+		}
+		d.Routes = slices.Delete(d.Routes, i, i+1)
+		return nil
+	})
+}
+
+// updateDomainRoutes applies fn to a domain of a project, renders the partial of the project and applies it.
+func updateDomainRoutes(projectNOI, domain string, fn func(d *Domain) error) error {
+	p, err := updateProjectDomains(projectNOI, func(p *Project, _ []Project) error {
+		i := slices.IndexFunc(p.Domains, func(d Domain) bool { return strings.EqualFold(d.Domain, domain) })
+		if i < 0 {
+			return notFoundError{fmt.Sprintf("project %s has no domain %s", p.Name, domain)}
+		}
+
+		if err := fn(&p.Domains[i]); err != nil {
+			return err
+		}
+
+		return renderProjectDomains(*p)
+	})
+	if err != nil {
+		return err
+	}
+
+	return applyDomainPartial(p.Name)
+}
+
+// ListDomains returns the domains of a project, or of all projects when projectNOI is empty.
+func ListDomains(projectNOI string) ([]ProjectDomains, error) {
+	projects := []Project{}
+	if projectNOI != "" {
+		p, err := FindProject(projectNOI)
+		if err != nil {
+			return nil, err
+		}
+		projects = append(projects, p)
+	} else {
+		ps, err := readProjectsFromFile()
+		if err != nil {
+			return nil, err
+		}
+		projects = append(projects, ps.Projects...)
+	}
+
+	list := make([]ProjectDomains, len(projects))
+	for i, p := range projects {
+		list[i] = ProjectDomains{ProjectName: p.Name, Domains: p.Domains}
+		if list[i].Domains == nil {
+			list[i].Domains = []Domain{}
+		}
+	}
+
+	return list, nil
+}
+
+// adoptRenderedPartials migrates the project store to version 2, which keeps the domains of a project in the store.
+// A partial is only adopted when it is exactly what mole rendered for a single domain, so rendering it again does not change it.
+// Partials that were edited by hand are left alone until a domain is added to the project.
+func adoptRenderedPartials(store map[string]any) error {
+	projects, _ := store["projects"].([]any)
+	for _, raw := range projects {
+		pro, ok := raw.(map[string]any)
+		if !ok {
+			continue
+		}
+
+		name, _ := pro["name"].(string)
+		if name == "" {
+			continue
+		}
+
+		content, err := os.ReadFile(getDomainPartialPath(name))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read domain configuration of %s: %w", name, err)
+		}
+
+		if d, ok := parseRenderedPartial(name, string(content)); ok {
+			pro["domains"] = []Domain{d}
+		}
+	}
+
+	return nil
+}
+
+var renderedUpstream = regexp.MustCompile(`\n    reverse_proxy 127\.0\.0\.1:(\d+)\n`)
+
+// parseRenderedPartial reads the domain of a partial rendered by mole before domains were kept in the project store.
+func parseRenderedPartial(projectName, content string) (Domain, bool) {
+	redirect, _, _ := strings.Cut(content, " {")
+	domain, ok := strings.CutPrefix(redirect, "www.")
+	if !ok {
+		return Domain{}, false
+	}
+
+	d := Domain{Domain: domain, Primary: true}
+	port := 0
+	staticRoot := regexp.MustCompile(`\n    root \* /home/mole/projects/` + regexp.QuoteMeta(projectName) + `/(.*)\n`)
+
+	if m := renderedUpstream.FindStringSubmatch(content); m != nil {
+		d.Kind = DomainProxy
+		d.Port, _ = strconv.Atoi(m[1])
+		port = d.Port
+	} else if m := staticRoot.FindStringSubmatch(content); m != nil {
+		d.Kind = DomainStatic
+		d.Location = m[1]
+	} else {
+		return Domain{}, false
+	}
+
+	rendered, err := renderDomain(projectName, d, port, nil)
+	return d, err == nil && rendered == content
+}
+
+// Stringify returns a one line description of a domain for the table output.
+func (d Domain) Stringify() string {
+	status := ""
+	if d.RedirectStatus != 0 {
+		status = " (" + strconv.Itoa(d.RedirectStatus) + ")"
+	}
+
+	if d.Kind == DomainRedirect {
+		line := strings.Join(d.hosts(), ", ") + " -> " + d.Target + status
+		if d.Primary {
+			line += " (primary)"
+		}
+		return line
+	}
+
+	line := d.canonicalHost()
+	if d.Kind == DomainStatic {
+		line += " -> files in /" + d.Location
+	} else if d.Port == 0 {
+		line += " -> app port"
+	} else {
+		line += " -> port " + strconv.Itoa(d.Port)
+	}
+
+	if d.Primary {
+		line += " (primary)"
+	}
+	if len(d.Aliases) > 0 {
+		line += ", aliases " + strings.Join(d.Aliases, ", ")
+	}
+	if redirects := d.redirectHosts(); len(redirects) > 0 {
+		line += ", redirects " + strings.Join(redirects, ", ") + status
+	}
+	return line
+}
+
+// Stringify returns a one line description of a route for the table output.
+func (r Route) Stringify() string {
+	if r.Kind == DomainStatic {
+		return r.Path + " -> files in /" + r.Location
+	}
+	if r.Port == "" {
+		return r.Path + " -> PortApp"
+	}
+	return r.Path + " -> " + r.Port
+}
+
+// Stringify returns a string representation of the ProjectDomains.
+func (pd ProjectDomains) Stringify() string {
+	var b strings.Builder
+	b.WriteString("\n")
+	b.WriteString(" |Project    : " + pd.ProjectName + "\n")
+	if len(pd.Domains) == 0 {
+		b.WriteString(" |Domain     : none\n")
+	}
+	for _, d := range pd.Domains {
+		b.WriteString(" |Domain     : " + d.Stringify() + "\n")
+		for _, r := range d.Routes {
+			b.WriteString(" |  Route    : " + r.Stringify() + "\n")
+		}
+	}
+	return b.String()
+}
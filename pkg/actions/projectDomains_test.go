@@ -0,0 +1,261 @@
+package actions
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zulubit/mole/pkg/consts"
+)
+
+func TestAddDomain(t *testing.T) {
+	consts.Testing = true
+	consts.BasePath = t.TempDir()
+
+	addProject(Project{Name: "test"})
+	addProject(Project{Name: "other"})
+
+	err := AddDomainProxy("test", "test.com", 3000)
+	assert.Nil(t, err, "domain should be added")
+
+	err = AddDomain("test", Domain{Domain: "api.test.com", Kind: DomainProxy, Port: 3001, Aliases: []string{"api.test.org"}, Redirects: []string{"api.old.com"}})
+	assert.Nil(t, err, "a second domain should be added")
+
+	d, _ := os.ReadFile(getDomainPartialPath("test"))
+	assert.Equal(t, successDomainProxy+"\n\nwww.api.test.com, api.old.com {\n    redir https://api.test.com{uri}\n}\n\napi.test.com, api.test.org {\n    reverse_proxy 127.0.0.1:3001\n}", string(d), "all domains are rendered into the partial")
+
+	p, _ := FindProject("test")
+	assert.Len(t, p.Domains, 2)
+	assert.True(t, p.Domains[0].Primary, "the first domain is the primary one")
+	assert.Equal(t, []string{"test.com", "api.test.com", "api.test.org"}, configuredDomains(p))
+
+	err = AddDomain("test", Domain{Domain: "api.test.com", Kind: DomainProxy, Port: 3002, Primary: true})
+	assert.Nil(t, err, "a domain can be replaced")
+	p, _ = FindProject("test")
+	assert.Equal(t, "api.test.com", p.Domains[0].Domain, "the primary domain comes first")
+	assert.True(t, p.Domains[0].Primary)
+	assert.False(t, p.Domains[1].Primary, "there is only one primary domain")
+	assert.Empty(t, p.Domains[0].Aliases, "the replaced domain lost its aliases")
+
+	err = AddDomainProxy("other", "test.com", 4000)
+	assert.ErrorContains(t, err, "domain test.com is already used by test.com of project test")
+	err = AddDomain("other", Domain{Domain: "other.com", Kind: DomainProxy, Aliases: []string{"www.api.test.com"}})
+	assert.ErrorContains(t, err, "already used by api.test.com of project test", "redirects are taken as well")
+	err = AddDomain("test", Domain{Domain: "www.test.com", Kind: DomainProxy})
+	assert.ErrorContains(t, err, "already used by test.com", "domains of the same project can not overlap")
+
+	err = AddDomain("test", Domain{Domain: "files.test.com", Kind: DomainStatic, Location: "../etc"})
+	assert.ErrorContains(t, err, "stay inside the project")
+	err = AddDomain("test", Domain{Domain: "files.test.com", Kind: "tunnel"})
+	assert.ErrorContains(t, err, "invalid kind")
+	err = AddDomain("test", Domain{Domain: "files.test.com", Kind: DomainStatic, Aliases: []string{"files.test.com"}})
+	assert.ErrorContains(t, err, "listed more than once")
+}
+
+func TestRemoveDomain(t *testing.T) {
+	consts.Testing = true
+	consts.BasePath = t.TempDir()
+
+	addProject(Project{Name: "test"})
+	AddDomainProxy("test", "test.com", 3000)
+	AddDomain("test", Domain{Domain: "two.com", Kind: DomainStatic, Aliases: []string{"alias.com"}})
+
+	err := RemoveDomain("test", "alias.com")
+	assert.Nil(t, err, "an alias can be removed")
+	p, _ := FindProject("test")
+	assert.Empty(t, p.Domains[1].Aliases)
+
+	err = RemoveDomain("test", "test.com")
+	assert.Nil(t, err, "the primary domain can be removed")
+	p, _ = FindProject("test")
+	assert.Equal(t, "two.com", p.Domains[0].Domain)
+	assert.True(t, p.Domains[0].Primary, "the next domain becomes the primary one")
+
+	err = RemoveDomain("test", "test.com")
+	assert.ErrorIs(t, err, ErrNotFound, "a removed domain is gone")
+
+	err = RemoveDomain("test", "two.com")
+	assert.Nil(t, err, "the last domain can be removed")
+	_, err = os.Stat(getDomainPartialPath("test"))
+	assert.ErrorIs(t, err, os.ErrNotExist, "the partial is removed with the last domain")
+}
+
+func TestBlueGreenDomainFollowsLiveSlot(t *testing.T) {
+	consts.Testing = true
+	consts.BasePath = t.TempDir()
+
+	np := Project{Name: "test"}
+	addProject(np)
+	createProjectSecretsJson(np)
+	SetDeployStrategy("test", StrategyBlueGreen)
+	editProject("test", func(p *Project) { p.ActiveSlot = slotGreen })
+
+	secrets, _ := readProjectSecrets("test")
+	p, _ := FindProject("test")
+	assert.Equal(t, secrets.PortTwo, upstreamPort(p, 0), "the app port is the live slot")
+	assert.Equal(t, secrets.PortTwo, upstreamPort(p, secrets.PortApp), "the port of the other slot follows the live one")
+	assert.Equal(t, 9999, upstreamPort(p, 9999), "other ports are kept")
+}
+
+func TestAdoptRenderedPartials(t *testing.T) {
+	consts.Testing = true
+	consts.BasePath = t.TempDir()
+
+	os.MkdirAll(consts.BasePath+"/domains", 0755)
+	os.WriteFile(getDomainPartialPath("proxy"), []byte(successDomainProxy), 0644)
+	// successDomainStatic serves the files of the project test
+	os.WriteFile(getDomainPartialPath("test"), []byte(successDomainStatic), 0644)
+	edited := successDomainProxy + "\n\nadmin.test.com {\n    reverse_proxy 127.0.0.1:9000\n}"
+	os.WriteFile(getDomainPartialPath("edited"), []byte(edited), 0644)
+
+	legacy := `{"version":1,"projects":[{"projectId":"a","name":"proxy"},{"projectId":"b","name":"test"},{"projectId":"c","name":"edited"},{"projectId":"d","name":"none"}]}`
+	os.WriteFile(getMoleJSONPath(), []byte(legacy), 0644)
+
+	list, err := ListDomains("")
+	assert.Nil(t, err, "the store should be migrated")
+	assert.Equal(t, []ProjectDomains{
+		{ProjectName: "proxy", Domains: []Domain{{Domain: "test.com", Kind: DomainProxy, Port: 3000, Primary: true}}},
+		{ProjectName: "test", Domains: []Domain{{Domain: "test.com", Kind: DomainStatic, Primary: true}}},
+		{ProjectName: "edited", Domains: []Domain{}},
+		{ProjectName: "none", Domains: []Domain{}},
+	}, list, "only partials rendered by mole are adopted")
+
+	p, _ := FindProject("proxy")
+	err = renderProjectDomains(p)
+	assert.Nil(t, err)
+	d, _ := os.ReadFile(getDomainPartialPath("proxy"))
+	assert.Equal(t, successDomainProxy, string(d), "rendering an adopted partial does not change it")
+
+	p, _ = FindProject("test")
+	renderProjectDomains(p)
+	d, _ = os.ReadFile(getDomainPartialPath("test"))
+	assert.Equal(t, successDomainStatic, string(d))
+
+	d, _ = os.ReadFile(getDomainPartialPath("edited"))
+	assert.Equal(t, edited, string(d), "edited partials are left alone")
+}
+
+const successDomainRouted = `www.test.com {
+    redir https://test.com{uri}
+}
+
+test.com {
+    encode gzip zstd
+
+    @blockedFiles {
+        path *.env
+    }
+    respond @blockedFiles 403
+
+    handle /api/* {
+        reverse_proxy 127.0.0.1:3000
+    }
+
+    handle /assets/* {
+        root * /home/mole/projects/test/public
+        file_server
+    }
+
+    handle {
+        root * /home/mole/projects/test/dist
+        file_server
+
+        @htmlFiles {
+            file {
+                try_files {path}.html
+            }
+        }
+        rewrite @htmlFiles {path}.html
+    }
+}`
+
+func TestDomainRoutes(t *testing.T) {
+	consts.Testing = true
+	consts.BasePath = t.TempDir()
+
+	addProject(Project{Name: "test"})
+	AddDomain("test", Domain{Domain: "test.com", Kind: DomainStatic, Location: "dist"})
+
+	err := AddDomainRoute("test", "test.com", Route{Path: "/api/*", Kind: DomainProxy, Port: "3001"})
+	assert.Nil(t, err, "a proxy route should be added")
+	err = AddDomainRoute("test", "test.com", Route{Path: "/assets/*", Kind: DomainStatic, Location: "public"})
+	assert.Nil(t, err, "a static route should be added")
+	err = AddDomainRoute("test", "test.com", Route{Path: "/api/*", Kind: DomainProxy, Port: "3000"})
+	assert.Nil(t, err, "a route with the same path is replaced")
+
+	d, _ := os.ReadFile(getDomainPartialPath("test"))
+	assert.Equal(t, successDomainRouted, string(d), "routes are handled before the domain")
+
+	err = AddDomain("test", Domain{Domain: "test.com", Kind: DomainStatic, Location: "dist"})
+	assert.Nil(t, err)
+	p, _ := FindProject("test")
+	assert.Len(t, p.Domains[0].Routes, 2, "replacing a domain keeps its routes")
+
+	err = AddDomainRoute("test", "test.com", Route{Path: "api/*", Kind: DomainProxy})
+	assert.ErrorContains(t, err, "invalid path")
+	err = AddDomainRoute("test", "test.com", Route{Path: "/ws/*", Kind: DomainProxy, Port: "PortFour"})
+	assert.ErrorContains(t, err, "invalid port")
+	err = AddDomainRoute("test", "test.com", Route{Path: "/files/*", Kind: DomainStatic, Location: "/etc"})
+	assert.ErrorContains(t, err, "stay inside the project")
+	err = AddDomainRoute("test", "other.com", Route{Path: "/ws/*", Kind: DomainProxy})
+	assert.ErrorIs(t, err, ErrNotFound, "the domain has to exist")
+
+	err = RemoveDomainRoute("test", "test.com", "/assets/*")
+	assert.Nil(t, err, "a route should be removed")
+	err = RemoveDomainRoute("test", "test.com", "/assets/*")
+	assert.ErrorIs(t, err, ErrNotFound)
+	p, _ = FindProject("test")
+	assert.Equal(t, []Route{{Path: "/api/*", Kind: DomainProxy, Port: "3000"}}, p.Domains[0].Routes)
+}
+
+func TestDomainRedirectPolicy(t *testing.T) {
+	consts.Testing = true
+	consts.BasePath = t.TempDir()
+
+	addProject(Project{Name: "test"})
+	addProject(Project{Name: "other"})
+
+	render := func(d Domain) string {
+		rendered, err := renderDomain("test", d, 3000, nil)
+		assert.Nil(t, err)
+		return rendered
+	}
+
+	assert.Equal(t, successDomainProxy, render(Domain{Domain: "test.com", Kind: DomainProxy, WWW: WWWApex}), "apex is the default policy")
+	assert.Equal(t, "api.test.com {\n    reverse_proxy 127.0.0.1:3000\n}", render(Domain{Domain: "api.test.com", Kind: DomainProxy, WWW: WWWNone}), "none leaves out the www host")
+	assert.Equal(t, "old.test.com {\n    redir https://api.test.com{uri}\n}\n\napi.test.com {\n    reverse_proxy 127.0.0.1:3000\n}",
+		render(Domain{Domain: "api.test.com", Kind: DomainProxy, WWW: WWWNone, Redirects: []string{"old.test.com"}}), "other redirects are kept")
+	assert.Equal(t, "test.com {\n    redir https://www.test.com{uri} 308\n}\n\nwww.test.com {\n    reverse_proxy 127.0.0.1:3000\n}",
+		render(Domain{Domain: "test.com", Kind: DomainProxy, WWW: WWWWWW, RedirectStatus: 308}), "www redirects the domain to the www host")
+	assert.Equal(t, "*.test.com {\n    reverse_proxy 127.0.0.1:3000\n}", render(Domain{Domain: "*.test.com", Kind: DomainProxy}), "wildcard domains have no www host")
+
+	assert.Equal(t, "old.com, www.old.com {\n    redir https://test.com{uri} 301\n}",
+		render(Domain{Domain: "old.com", Kind: DomainRedirect, Target: "https://test.com/", RedirectStatus: 301}), "the path of the request is kept")
+	assert.Equal(t, "old.com {\n    redir https://test.com/blog\n}",
+		render(Domain{Domain: "old.com", Kind: DomainRedirect, WWW: WWWNone, Target: "https://test.com/blog"}), "a target with a path is fixed")
+
+	err := AddDomain("test", Domain{Domain: "test.com", Kind: DomainProxy, WWW: WWWWWW})
+	assert.Nil(t, err)
+	err = AddDomain("test", Domain{Domain: "old.com", Kind: DomainRedirect, Target: "https://www.test.com"})
+	assert.Nil(t, err, "a redirect domain should be added")
+	p, _ := FindProject("test")
+	assert.Equal(t, []string{"www.test.com"}, configuredDomains(p), "only served hosts are configured")
+
+	err = AddDomain("other", Domain{Domain: "api.test.com", Kind: DomainProxy, WWW: WWWNone})
+	assert.Nil(t, err, "subdomains without a www host do not conflict")
+	err = AddDomain("other", Domain{Domain: "www.old.com", Kind: DomainProxy, WWW: WWWNone})
+	assert.ErrorContains(t, err, "already used by old.com of project test", "the www host of a redirect domain is taken")
+	err = AddDomainRoute("test", "old.com", Route{Path: "/api/*", Kind: DomainProxy})
+	assert.ErrorContains(t, err, "has no routes")
+
+	err = AddDomain("test", Domain{Domain: "*.test.org", Kind: DomainProxy, WWW: WWWApex})
+	assert.ErrorContains(t, err, "has no www host")
+	err = AddDomain("test", Domain{Domain: "test.org", Kind: DomainProxy, WWW: "always"})
+	assert.ErrorContains(t, err, "invalid www policy")
+	err = AddDomain("test", Domain{Domain: "test.org", Kind: DomainProxy, RedirectStatus: 200})
+	assert.ErrorContains(t, err, "invalid redirect status")
+	err = AddDomain("test", Domain{Domain: "test.org", Kind: DomainRedirect, Target: "test.com"})
+	assert.ErrorContains(t, err, "invalid redirect target")
+	err = AddDomain("test", Domain{Domain: "test.org", Kind: DomainRedirect, Target: "https://test.com{uri}"})
+	assert.ErrorContains(t, err, "invalid redirect target")
+}
@@ -0,0 +1,258 @@
+package actions
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gofrs/flock"
+	"github.com/zulubit/mole/pkg/consts"
+	"github.com/zulubit/mole/pkg/helpers"
+)
+
+// projectStoreVersion is the version of the mole.json layout written by this build.
+const projectStoreVersion = 2
+
+// maxProjectStoreBackups is the number of previous revisions of mole.json kept in the backup directory.
+const maxProjectStoreBackups = 10
+
+// projectStoreLockTimeout is how long to wait for another mole process to finish with the project store.
+var projectStoreLockTimeout = 10 * time.Second
+
+// projectStoreMigrations upgrade mole.json one version at a time, projectStoreMigrations[i] migrates version i to i+1.
+// They work on the raw document, so fields that were removed from Project can still be read.
+var projectStoreMigrations = []func(store map[string]any) error{
+	// version 0 had no version field
+	func(store map[string]any) error { return nil },
+	// version 2 keeps the domains of a project in the store
+	adoptRenderedPartials,
+}
+
+// getMoleJSONPath returns the full path to mole.json based on consts.GetBasePath().
+func getMoleJSONPath() string {
+	return path.Join(consts.GetBasePath(), "mole.json")
+}
+
+// getMoleLockPath returns the full path to mole.lock based on consts.GetBasePath().
+func getMoleLockPath() string {
+	return path.Join(consts.GetBasePath(), "mole.lock")
+}
+
+// getProjectStoreBackupsPath returns the directory holding previous revisions of mole.json.
+func getProjectStoreBackupsPath() string {
+	return path.Join(consts.GetBasePath(), "backups", "mole.json")
+}
+
+// lockProjectStore takes the project store lock, waiting for other mole processes up to projectStoreLockTimeout.
+func lockProjectStore() (*flock.Flock, error) {
+	if err := os.MkdirAll(consts.GetBasePath(), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create base directory: %w", err)
+	}
+
+	fileLock, locked, err := lockWithTimeout(getMoleLockPath())
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock the project store: %w", err)
+	}
+
+	if !locked {
+		return nil, fmt.Errorf("someone else is working with the project store, gave up after %s, please try again", projectStoreLockTimeout)
+	}
+
+	return fileLock, nil
+}
+
+// lockWithTimeout takes the file lock at lockPath, waiting up to projectStoreLockTimeout.
+// It reports false when someone else still holds the lock after the timeout.
+func lockWithTimeout(lockPath string) (*flock.Flock, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), projectStoreLockTimeout)
+	defer cancel()
+
+	fileLock := flock.New(lockPath)
+	locked, err := fileLock.TryLockContext(ctx, 50*time.Millisecond)
+	if err != nil && !errors.Is(err, context.DeadlineExceeded) {
+		return nil, false, err
+	}
+
+	return fileLock, locked, nil
+}
+
+// readProjectsFromFile reads the project data from the mole.json file.
+// It holds the project store lock while reading, so it never sees a store another process is migrating.
+func readProjectsFromFile() (Projects, error) {
+	fileLock, err := lockProjectStore()
+	if err != nil {
+		return Projects{}, err
+	}
+	defer fileLock.Unlock()
+
+	return loadProjects()
+}
+
+// updateProjects applies fn to the projects and saves them, holding the project store lock from reading to writing.
+// Nothing is written when fn returns an error.
+func updateProjects(fn func(*Projects) error) error {
+	fileLock, err := lockProjectStore()
+	if err != nil {
+		return err
+	}
+	defer fileLock.Unlock()
+
+	p, err := loadProjects()
+	if err != nil {
+		return err
+	}
+
+	if err := fn(&p); err != nil {
+		return err
+	}
+
+	return p.saveProjectsToFile()
+}
+
+// loadProjects reads mole.json, migrating it to the current version if it was written by an older build.
+// A missing or empty file is an empty project store. The caller must hold the project store lock.
+func loadProjects() (Projects, error) {
+	f, err := os.ReadFile(getMoleJSONPath())
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return Projects{}, fmt.Errorf("failed to read project store: %w", err)
+	}
+
+	if len(bytes.TrimSpace(f)) == 0 {
+		return Projects{Version: projectStoreVersion, Projects: []Project{}}, nil
+	}
+
+	migrated, err := migrateProjectStore(f)
+	if err != nil {
+		return Projects{}, err
+	}
+
+	var p Projects
+	if err := json.Unmarshal(migrated, &p); err != nil {
+		return Projects{}, fmt.Errorf("failed to unmarshal projects: %w", err)
+	}
+
+	if p.Version != projectStoreVersion {
+		// persist the migration right away, so it only runs once
+		if err := p.saveProjectsToFile(); err != nil {
+			return Projects{}, fmt.Errorf("failed to save migrated project store: %w", err)
+		}
+	}
+
+	return p, nil
+}
+
+// migrateProjectStore runs the migrations needed to bring a mole.json document to the current version.
+// The returned document still carries its old version, so the caller can tell it was migrated.
+func migrateProjectStore(f []byte) ([]byte, error) {
+	var store map[string]any
+	if err := json.Unmarshal(f, &store); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal projects: %w", err)
+	}
+
+	version := 0
+	if v, ok := store["version"].(float64); ok {
+		version = int(v)
+	}
+
+	if version > projectStoreVersion {
+		return nil, fmt.Errorf("mole.json has version %d, this build of mole only supports up to version %d, please upgrade mole", version, projectStoreVersion)
+	}
+
+	if version == projectStoreVersion {
+		return f, nil
+	}
+
+	for v := version; v < projectStoreVersion; v++ {
+		if err := projectStoreMigrations[v](store); err != nil {
+			return nil, fmt.Errorf("failed to migrate mole.json from version %d to %d: %w", v, v+1, err)
+		}
+	}
+
+	return json.Marshal(store)
+}
+
+// saveProjectsToFile saves the current state of the Projects to mole.json.
+// The previous revision is backed up and the new one is written atomically. The caller must hold the project store lock.
+func (p Projects) saveProjectsToFile() error {
+	p.Version = projectStoreVersion
+	if p.Projects == nil {
+		p.Projects = []Project{}
+	}
+
+	f, err := json.MarshalIndent(p, "", " ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal projects data: %w", err)
+	}
+
+	if err := backupProjectStore(); err != nil {
+		return err
+	}
+
+	if err := helpers.WriteFileAtomic(getMoleJSONPath(), f, 0644); err != nil {
+		return fmt.Errorf("failed to write projects to file: %w", err)
+	}
+
+	return nil
+}
+
+// backupProjectStore copies the current mole.json to the backup directory, keeping the latest maxProjectStoreBackups revisions.
+func backupProjectStore() error {
+	current, err := os.ReadFile(getMoleJSONPath())
+	if errors.Is(err, os.ErrNotExist) || (err == nil && len(bytes.TrimSpace(current)) == 0) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read project store for backup: %w", err)
+	}
+
+	backupsPath := getProjectStoreBackupsPath()
+	if err := os.MkdirAll(backupsPath, 0755); err != nil {
+		return fmt.Errorf("failed to create backup directory: %w", err)
+	}
+
+	name := "mole-" + time.Now().UTC().Format("20060102T150405.000000000") + ".json"
+	if err := helpers.WriteFileAtomic(path.Join(backupsPath, name), current, 0644); err != nil {
+		return fmt.Errorf("failed to back up project store: %w", err)
+	}
+
+	backups, err := ProjectStoreBackups()
+	if err != nil {
+		return err
+	}
+
+	for len(backups) > maxProjectStoreBackups {
+		os.Remove(backups[len(backups)-1])
+		backups = backups[:len(backups)-1]
+	}
+
+	return nil
+}
+
+// ProjectStoreBackups returns the paths of the backed up revisions of mole.json, newest first.
+func ProjectStoreBackups() ([]string, error) {
+	entries, err := os.ReadDir(getProjectStoreBackupsPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backup directory: %w", err)
+	}
+
+	backups := []string{}
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasPrefix(e.Name(), "mole-") && strings.HasSuffix(e.Name(), ".json") {
+			backups = append(backups, path.Join(getProjectStoreBackupsPath(), e.Name()))
+		}
+	}
+
+	sort.Sort(sort.Reverse(sort.StringSlice(backups)))
+
+	return backups, nil
+}
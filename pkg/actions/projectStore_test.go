@@ -0,0 +1,107 @@
+package actions
+
+import (
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofrs/flock"
+	"github.com/stretchr/testify/assert"
+	"github.com/zulubit/mole/pkg/consts"
+)
+
+func TestProjectStoreMigration(t *testing.T) {
+	consts.Testing = true
+
+	tmp := t.TempDir()
+	consts.BasePath = tmp
+
+	ps, err := readProjectsFromFile()
+	assert.Nil(t, err, "missing store is read as empty")
+	assert.Empty(t, ps.Projects, "missing store has no projects")
+
+	err = os.WriteFile(getMoleJSONPath(), []byte{}, 0644)
+	assert.Nil(t, err, "empty store written")
+	ps, err = readProjectsFromFile()
+	assert.Nil(t, err, "empty store is read as empty")
+	assert.Empty(t, ps.Projects, "empty store has no projects")
+
+	legacy := `{"projects":[{"projectId":"abc","name":"legacy","branch":"main"}]}`
+	err = os.WriteFile(getMoleJSONPath(), []byte(legacy), 0644)
+	assert.Nil(t, err, "legacy store written")
+
+	p, err := FindProject("legacy")
+	assert.Nil(t, err, "projects of a store without version can be found")
+	assert.Equal(t, "abc", p.ProjectID)
+
+	migrated, err := os.ReadFile(getMoleJSONPath())
+	assert.Nil(t, err, "store can be read")
+	assert.Contains(t, string(migrated), `"version": `+strconv.Itoa(projectStoreVersion), "store is migrated to the current version")
+
+	backups, err := ProjectStoreBackups()
+	assert.Nil(t, err, "backups can be listed")
+	assert.Len(t, backups, 1, "store is backed up before migrating")
+	backup, _ := os.ReadFile(backups[0])
+	assert.Equal(t, legacy, string(backup), "backup contains the store before migration")
+
+	err = os.WriteFile(getMoleJSONPath(), []byte(`{"version":99,"projects":[]}`), 0644)
+	assert.Nil(t, err, "future store written")
+	_, err = readProjectsFromFile()
+	assert.ErrorContains(t, err, "please upgrade mole", "stores of newer versions are refused")
+}
+
+func TestProjectStoreBackups(t *testing.T) {
+	consts.Testing = true
+
+	tmp := t.TempDir()
+	consts.BasePath = tmp
+
+	for i := 0; i < maxProjectStoreBackups+3; i++ {
+		err := addProject(Project{Name: "project-" + strconv.Itoa(i)})
+		assert.Nil(t, err, "project added")
+	}
+
+	backups, err := ProjectStoreBackups()
+	assert.Nil(t, err, "backups can be listed")
+	assert.Len(t, backups, maxProjectStoreBackups, "only the latest revisions are kept")
+
+	newest, _ := os.ReadFile(backups[0])
+	assert.Contains(t, string(newest), "project-"+strconv.Itoa(maxProjectStoreBackups+1), "newest backup is the previous revision")
+	assert.NotContains(t, string(newest), "project-"+strconv.Itoa(maxProjectStoreBackups+2), "newest backup does not contain the latest write")
+}
+
+func TestProjectStoreLocking(t *testing.T) {
+	consts.Testing = true
+
+	tmp := t.TempDir()
+	consts.BasePath = tmp
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			err := addProject(Project{Name: "project-" + strconv.Itoa(i)})
+			assert.Nil(t, err, "concurrent writers wait for each other")
+		}(i)
+	}
+	wg.Wait()
+
+	ps, err := AllProjects()
+	assert.Nil(t, err, "projects can be read")
+	assert.Len(t, ps, 20, "no concurrent write was lost")
+
+	originalTimeout := projectStoreLockTimeout
+	defer func() { projectStoreLockTimeout = originalTimeout }()
+	projectStoreLockTimeout = 100 * time.Millisecond
+
+	held := flock.New(getMoleLockPath())
+	locked, err := held.TryLock()
+	assert.True(t, locked, "lock is taken by someone else")
+	defer held.Unlock()
+
+	err = addProject(Project{Name: "blocked"})
+	assert.ErrorContains(t, err, "someone else is working with the project store", "writers give up after the timeout")
+}
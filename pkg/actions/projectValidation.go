@@ -0,0 +1,94 @@
+package actions
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/zulubit/mole/pkg/consts"
+)
+
+// ProjectValidation is the result of linting the mole.yaml of a project and the files it refers to.
+type ProjectValidation struct {
+	ProjectName string `json:"projectName"`
+	Valid       bool   `json:"valid"`
+	// Problems fail the deployment of the project.
+	Problems []string `json:"problems"`
+	// Warnings point out likely mistakes that do not fail the deployment.
+	Warnings []string `json:"warnings"`
+}
+
+// ValidateProject lints the mole.yaml of a project: the manifest itself, the files it refers to,
+// the secrets it requires and every template of the project, which is rendered without being written.
+func ValidateProject(projectNOI string) (ProjectValidation, error) {
+	p, err := FindProject(projectNOI)
+	if err != nil {
+		return ProjectValidation{}, err
+	}
+
+	v := ProjectValidation{ProjectName: p.Name, Problems: []string{}, Warnings: []string{}}
+
+	m, err := readManifest(p)
+	if err != nil {
+		// nothing else can be checked without the manifest
+		v.Problems = append(v.Problems, err.Error())
+		return v, nil
+	}
+
+	secrets, err := readProjectSecrets(p.Name)
+	if err != nil {
+		return v, fmt.Errorf("failed to load secrets for project %s: %w", p.Name, err)
+	}
+
+	projectPath := path.Join(consts.GetBasePath(), "projects", p.Name)
+	exists := func(file string) bool {
+		_, err := os.Stat(path.Join(projectPath, file))
+		return err == nil
+	}
+
+	if !exists(m.deployScript()) {
+		v.Problems = append(v.Problems, fmt.Sprintf("deployment script %s not found", m.deployScript()))
+	}
+	if m.Compose != "" && !exists(m.Compose) {
+		v.Problems = append(v.Problems, fmt.Sprintf("compose file %s not found", m.Compose))
+	}
+
+	for _, key := range m.missingSecrets(secrets) {
+		v.Problems = append(v.Problems, fmt.Sprintf("secret %s is required but not set, set it with mole secrets set", key))
+	}
+
+	for _, t := range append(builtinTemplateFiles(p, m), m.Templates...) {
+		if !exists(t.Source) {
+			v.Problems = append(v.Problems, fmt.Sprintf("template %s not found", t.Source))
+			continue
+		}
+		if _, err := renderTemplateFile(p, secrets, t); err != nil {
+			v.Problems = append(v.Problems, err.Error())
+		}
+	}
+
+	for _, d := range m.Domains {
+		if !domainConfigured(p, d) {
+			v.Warnings = append(v.Warnings, fmt.Sprintf("domain %s is not configured, add it with mole domains add", d))
+		}
+	}
+
+	if m.Ports > 1 && p.Strategy == StrategyBlueGreen {
+		v.Warnings = append(v.Warnings, fmt.Sprintf("ports is %d, but blue/green deployments run the green slot on PortTwo", m.Ports))
+	}
+
+	v.Valid = len(v.Problems) == 0
+
+	return v, nil
+}
+
+// domainConfigured reports whether the Caddy partial of a project serves the domain.
+func domainConfigured(p Project, domain string) bool {
+	for _, d := range configuredDomains(p) {
+		if strings.EqualFold(d, domain) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,69 @@
+package actions
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zulubit/mole/pkg/consts"
+)
+
+func TestValidateProject(t *testing.T) {
+	consts.Testing = true
+
+	tmp := t.TempDir()
+	consts.BasePath = tmp
+
+	np := Project{Name: "validate-project"}
+	err := addProject(np)
+	assert.Nil(t, err, "project added")
+	err = createProjectSecretsJson(np)
+	assert.Nil(t, err, "project secrets created")
+
+	projectPath := path.Join(tmp, "projects", np.Name)
+	err = os.MkdirAll(projectPath, 0755)
+	assert.Nil(t, err, "project directory created")
+
+	write := func(name, content string) {
+		err := os.WriteFile(path.Join(projectPath, name), []byte(content), 0644)
+		assert.Nil(t, err, name+" written")
+	}
+
+	v, err := ValidateProject(np.Name)
+	assert.Nil(t, err, "project validated")
+	assert.False(t, v.Valid, "project without mole.sh is invalid")
+	assert.Equal(t, []string{"deployment script mole.sh not found"}, v.Problems)
+
+	write("deploy.sh", "echo {{.ProjectName}}")
+	write("app.conf.tmpl", "smtp {{secret \"SMTP_PASSWORD\"}}")
+	write("mole.yaml", "deploy: deploy.sh\ncompose: compose.yaml\ntemplates:\n  - app.conf.tmpl\n  - missing.conf.tmpl\nsecrets:\n  - SMTP_PASSWORD\ndomains:\n  - example.com\n")
+
+	v, err = ValidateProject(np.Name)
+	assert.Nil(t, err, "project validated")
+	assert.False(t, v.Valid, "project with problems is invalid")
+	assert.Len(t, v.Problems, 4, "every problem is reported")
+	assert.Contains(t, v.Problems[0], "compose file compose.yaml not found")
+	assert.Contains(t, v.Problems[1], "secret SMTP_PASSWORD is required but not set")
+	assert.Contains(t, v.Problems[2], "app.conf.tmpl")
+	assert.Contains(t, v.Problems[3], "template missing.conf.tmpl not found")
+	assert.Equal(t, []string{"domain example.com is not configured, add it with mole domains add"}, v.Warnings)
+
+	err = SetProjectSecret(np.Name, "SMTP_PASSWORD", "smtp-password")
+	assert.Nil(t, err, "required secret set")
+	write("compose.yaml", "services: {}")
+	write("mole.yaml", "deploy: deploy.sh\ncompose: compose.yaml\ntemplates:\n  - app.conf.tmpl\nsecrets:\n  - SMTP_PASSWORD\n")
+
+	v, err = ValidateProject(np.Name)
+	assert.Nil(t, err, "project validated")
+	assert.True(t, v.Valid, "fixed project is valid")
+	assert.Empty(t, v.Problems)
+	_, err = os.Stat(path.Join(projectPath, "app.conf"))
+	assert.True(t, os.IsNotExist(err), "validation writes nothing")
+
+	write("mole.yaml", "deploy: [")
+	v, err = ValidateProject(np.Name)
+	assert.Nil(t, err, "project validated")
+	assert.False(t, v.Valid, "unparsable manifest is invalid")
+	assert.Contains(t, v.Problems[0], "invalid mole.yaml")
+}
@@ -10,79 +10,61 @@ import (
 	"path"
 	"strconv"
 	"strings"
+	"time"
 
-	"github.com/gofrs/flock"
 	"github.com/lithammer/shortuuid/v4"
 	"github.com/zulubit/mole/pkg/consts"
 	"github.com/zulubit/mole/pkg/helpers"
 )
 
-// Projects represents a collection of Project.
-type Projects struct {
-	Projects []Project `json:"projects"`
-}
+// ErrNotFound is matched by errors reporting that a project or deployment does not exist.
+var ErrNotFound = errors.New("not found")
 
-// Project represents an individual project with its details.
-type Project struct {
-	ProjectID     string `json:"projectId"`
-	Name          string `json:"name"`
-	Description   string `json:"description"`
-	RepositoryURL string `json:"repositoryUrl"`
-	Branch        string `json:"branch"`
+// notFoundError reports a missing project or deployment with a message meant for the user.
+type notFoundError struct {
+	msg string
 }
 
-// getMoleJSONPath returns the full path to mole.json based on consts.GetBasePath().
-func getMoleJSONPath() string {
-	return path.Join(consts.GetBasePath(), "mole.json")
+func (e notFoundError) Error() string {
+	return e.msg
 }
 
-// getMoleLockPath returns the full path to mole.lock based on consts.GetBasePath().
-func getMoleLockPath() string {
-	return path.Join(consts.GetBasePath(), "mole.lock")
+func (e notFoundError) Is(target error) bool {
+	return target == ErrNotFound
 }
 
-// readProjectsFromFile reads the project data from the mole.json file.
-// It uses a file lock to ensure that no other process is modifying the file at the same time.
-func readProjectsFromFile() (Projects, error) {
-	fileLock := flock.New(getMoleLockPath())
-	locked, err := fileLock.TryLock()
-	defer fileLock.Unlock()
-
-	if err != nil {
-		return Projects{}, err
-	}
-
-	if locked {
-		f, err := os.ReadFile(getMoleJSONPath())
-		if err != nil {
-			if errors.Is(err, os.ErrNotExist) {
-				err = os.WriteFile(getMoleJSONPath(), []byte{}, 0644)
-				if err != nil {
-					return Projects{}, errors.New("mole.json was missing. An attempt to create it failed")
-				}
-			}
-			return Projects{}, err
-		}
-
-		var p Projects
-		if err := json.Unmarshal(f, &p); err != nil {
-			return Projects{}, fmt.Errorf("failed to unmarshal projects: %w", err)
-		}
+// Projects represents a collection of Project.
+type Projects struct {
+	Version  int       `json:"version"`
+	Projects []Project `json:"projects"`
+}
 
-		return p, nil
-	} else {
-		return Projects{}, errors.New("someone else is trying to work with the project store, please try again")
-	}
+// Project represents an individual project with its details.
+type Project struct {
+	ProjectID     string       `json:"projectId"`
+	Name          string       `json:"name"`
+	Description   string       `json:"description"`
+	RepositoryURL string       `json:"repositoryUrl"`
+	Branch        string       `json:"branch"`
+	DeployTimeout string       `json:"deployTimeout,omitempty"`
+	HealthCheck   *HealthCheck `json:"healthCheck,omitempty"`
+	Strategy      string       `json:"strategy,omitempty"`
+	ActiveSlot    string       `json:"activeSlot,omitempty"`
+	Domains       []Domain     `json:"domains,omitempty"`
 }
 
-// ListProjects returns a string representation of all projects in mole.json.
-func ListProjects() string {
+// AllProjects returns all projects in mole.json.
+func AllProjects() ([]Project, error) {
 	p, err := readProjectsFromFile()
 	if err != nil {
-		return err.Error()
+		return nil, err
+	}
+
+	if p.Projects == nil {
+		return []Project{}, nil
 	}
 
-	return p.Stringify()
+	return p.Projects, nil
 }
 
 // FindProject searches for a project by name or ID and returns it.
@@ -93,62 +75,29 @@ func FindProject(searchTerm string) (Project, error) {
 	}
 
 	var foundProject Project
+	found := false
 	for _, pro := range p.Projects {
 		if strings.EqualFold(pro.Name, searchTerm) || pro.ProjectID == searchTerm {
 			foundProject = pro
+			found = true
 			break
 		}
 	}
 
-	if foundProject == (Project{}) {
-		return foundProject, errors.New("sorry, no project was found!\nYou can use the \"mole projects list\" command to see all projects")
+	if !found {
+		return foundProject, notFoundError{"sorry, no project was found!\nYou can use the \"mole projects list\" command to see all projects"}
 	}
 
 	return foundProject, nil
 }
 
-// saveProjectsToFile saves the current state of the Projects to mole.json.
-func (p Projects) saveProjectsToFile() error {
-	f, err := json.MarshalIndent(p, "", " ")
-	if err != nil {
-		return fmt.Errorf("failed to marshal projects data: %w", err)
-	}
-
-	if err := os.WriteFile(getMoleJSONPath(), f, 0644); err != nil {
-		return fmt.Errorf("failed to write projects to file: %w", err)
-	}
-
-	return nil
-}
-
 // addProject adds a new project to the list of projects and saves it to the file.
 func addProject(newProject Project) error {
-
-	p, err := readProjectsFromFile()
-	if err != nil {
-		return err
-	}
-
-	fileLock := flock.New(getMoleLockPath())
-	locked, err := fileLock.TryLock()
-	defer fileLock.Unlock()
-
-	if err != nil {
-		return err
-	}
-
-	if locked {
+	return updateProjects(func(p *Projects) error {
 		newProject.ProjectID = shortuuid.New() // Assign a new unique project ID.
 		p.Projects = append(p.Projects, newProject)
-
-		if err := p.saveProjectsToFile(); err != nil {
-			return err
-		}
-
 		return nil
-	} else {
-		return errors.New("someone else is trying to work with the project store, please try again")
-	}
+	})
 }
 
 // cloneProject clones a project from a given repository URL into the local file system.
@@ -200,6 +149,12 @@ type projectSecrets struct {
 	DatabaseName  string
 	DatabaseUser  string
 	DatabasePass  string
+	WebhookSecret string
+	// Custom holds the secrets added with "mole secrets set", available as {{.Custom.KEY}} or {{secret "KEY"}}
+	Custom map[string]string `json:",omitempty"`
+	// Previous holds the values of rotated secrets for their grace period, available as {{.Previous.KEY}}
+	Previous      map[string]string    `json:",omitempty"`
+	PreviousUntil map[string]time.Time `json:",omitempty"`
 }
 
 func createProjectSecretsJson(project Project) error {
@@ -225,80 +180,57 @@ func createProjectSecretsJson(project Project) error {
 		DatabaseName:  dbName,
 		DatabaseUser:  dbUser,
 		DatabasePass:  dbPass,
+		WebhookSecret: helpers.GenerateRandomKey(32),
 	}
 
+	return saveProjectSecrets(project.Name, &secrets)
+}
+
+// saveProjectSecrets encrypts the secrets of a project with the master key and writes them to its secrets file.
+// It holds the master key lock, so it waits for a running rotation of the master key.
+func saveProjectSecrets(projectName string, secrets *projectSecrets) error {
 	jbe, err := json.Marshal(secrets)
 	if err != nil {
 		return err
 	}
 
-	err = os.MkdirAll(path.Join(consts.GetBasePath(), "secrets"), 0755)
+	keyLock, err := lockMasterKey()
 	if err != nil {
 		return err
 	}
+	defer keyLock.Unlock()
 
-	err = os.WriteFile(path.Join(consts.GetBasePath(), "secrets", project.Name+".json"), jbe, 0644)
+	key, err := findOrCreateMasterKey()
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return writeSecretsFile(projectName, jbe, key)
 }
 
+// ReadProjectSecrets returns the decrypted secrets of a project found by its name or ID.
 func ReadProjectSecrets(projectNOI string) (*projectSecrets, error) {
 	p, err := FindProject(projectNOI)
 	if err != nil {
 		return nil, err
 	}
 
-	s, err := os.ReadFile(path.Join(consts.GetBasePath(), "secrets", p.Name+".json"))
-	if err != nil {
-		return nil, err
-	}
-
-	var sec projectSecrets
-
-	err = json.Unmarshal(s, &sec)
-	if err != nil {
-		return nil, err
-	}
-
-	return &sec, nil
+	return readProjectSecrets(p.Name)
 }
 
-// createProjectBaseEnv generates the base environment file for the project if .env.example is present.
-func createProjectBaseEnv(project Project) error {
-
-	epath := path.Join(consts.BasePath, "projects", project.Name, ".env.example")
-
-	env, err := os.ReadFile(epath)
+// mole.sh, or the deployment script declared in mole.yaml, is mandatory for the project to be deployable by mole.
+// The mole.yaml of the project is validated on the way.
+func ensureMoleSh(newProject Project) error {
+	m, err := readManifest(newProject)
 	if err != nil {
-		return nil
-	}
-
-	if env != nil {
-		var ft bytes.Buffer
-
-		ft.Write(env)
-
-		efp := path.Join(consts.GetBasePath(), "projects", project.Name, ".env")
-
-		if err := os.WriteFile(efp, ft.Bytes(), 0644); err != nil {
-			return fmt.Errorf("failed to write environment file: %w", err)
-		}
+		return err
 	}
 
-	return nil
-}
-
-// mole.sh is mandatory for the project to be deployable by mole
-func ensureMoleSh(newProject Project) error {
-
-	shPath := path.Join(consts.BasePath, "projects", newProject.Name, "mole.sh")
+	shPath := path.Join(consts.BasePath, "projects", newProject.Name, m.deployScript())
 
-	_, err := os.ReadFile(shPath)
+	_, err = os.ReadFile(shPath)
 	if err != nil {
-		return fmt.Errorf("Project does not conatain or is unable to read mole.sh: %v", err)
+		return fmt.Errorf("Project does not conatain or is unable to read %s: %v", m.deployScript(), err)
 	}
 
 	return nil
@@ -313,12 +245,8 @@ func CreateProject(newProject Project) error {
 		return err
 	}
 
-	// ensure project hase the mole.sh
+	// ensure project hase the mole.sh and a valid mole.yaml
 	if err := ensureMoleSh(newProject); err != nil {
-		return err
-	}
-
-	if err := createProjectBaseEnv(newProject); err != nil {
 		os.RemoveAll(clonePath) // Clean up on error
 		return err
 	}
@@ -328,12 +256,13 @@ func CreateProject(newProject Project) error {
 		return err
 	}
 
-	if err := createProjectBaseEnv(newProject); err != nil {
+	if err := createProjectSecretsJson(newProject); err != nil {
 		os.RemoveAll(clonePath) // Clean up on error
 		return err
 	}
 
-	if err := createProjectSecretsJson(newProject); err != nil {
+	// the .env template is rendered with the secrets, so they have to exist first
+	if err := createProjectBaseEnv(newProject); err != nil {
 		os.RemoveAll(clonePath) // Clean up on error
 		return err
 	}
@@ -351,85 +280,91 @@ func createProjectLogDirectory(p Project) error {
 }
 
 // EditProject updates the details of an existing project by its name or ID.
-func EditProject(proNOI, desc, branch string) error {
-	p, err := readProjectsFromFile()
-	if err != nil {
-		return err
+// A timeout of "0" removes the project's deploy timeout.
+func EditProject(proNOI, desc, branch, timeout string) error {
+	if timeout != "" && timeout != "0" {
+		if _, err := time.ParseDuration(timeout); err != nil {
+			return fmt.Errorf("invalid deploy timeout %s, use a duration such as 15m: %w", timeout, err)
+		}
 	}
 
-	found := false
+	return editProject(proNOI, func(p *Project) {
+		if desc != "" {
+			p.Description = desc
+		}
+		if branch != "" {
+			p.Branch = branch
+		}
+		if timeout == "0" {
+			p.DeployTimeout = ""
+		} else if timeout != "" {
+			p.DeployTimeout = timeout
+		}
+	})
+}
 
-	for i, pro := range p.Projects {
-		if proNOI == pro.ProjectID || proNOI == pro.Name {
-			found = true
-			if desc != "" {
-				p.Projects[i].Description = desc
+// editProject applies fn to a project found by its name or ID and saves the project store.
+func editProject(projectNOI string, fn func(*Project)) error {
+	return updateProjects(func(p *Projects) error {
+		for i, pro := range p.Projects {
+			if strings.EqualFold(pro.Name, projectNOI) || pro.ProjectID == projectNOI {
+				fn(&p.Projects[i])
+				return nil
 			}
-			if branch != "" {
-				p.Projects[i].Branch = branch
-			}
-			break
 		}
-	}
 
-	if !found {
-		return fmt.Errorf("project with ID %s not found", proNOI)
-	}
-
-	return p.saveProjectsToFile()
+		return notFoundError{fmt.Sprintf("project with ID %s not found", projectNOI)}
+	})
 }
 
-// DeleteProject removes a project from the list by its ID, deletes the project directory, deletes logs and reloads Caddy
+// DeleteProject removes a project from the list by its ID, deletes the project directory, deletes logs and removes its route from Caddy
 func DeleteProject(proId string) error {
-	p, err := readProjectsFromFile()
-	if err != nil {
-		return err
-	}
-
-	found := false
-	foundProject := Project{}
-
-	for i, pro := range p.Projects {
-		if proId == pro.ProjectID {
-			found = true
-			foundProject = pro
-			p.Projects = append(p.Projects[:i], p.Projects[i+1:]...) // Remove the project from the slice.
-			break
+	return updateProjects(func(p *Projects) error {
+		found := false
+		foundProject := Project{}
+
+		for i, pro := range p.Projects {
+			if proId == pro.ProjectID {
+				found = true
+				foundProject = pro
+				p.Projects = append(p.Projects[:i], p.Projects[i+1:]...) // Remove the project from the slice.
+				break
+			}
 		}
-	}
 
-	if !found {
-		return fmt.Errorf("project with ID %s not found", proId)
-	}
-
-	err = os.RemoveAll(path.Join(consts.GetBasePath(), "projects", foundProject.Name))
-	if err != nil {
-		return err
-	}
+		if !found {
+			return notFoundError{fmt.Sprintf("project with ID %s not found", proId)}
+		}
 
-	err = os.RemoveAll(path.Join(consts.GetBasePath(), "logs", foundProject.Name))
-	if err != nil {
-		return err
-	}
+		err := os.RemoveAll(path.Join(consts.GetBasePath(), "projects", foundProject.Name))
+		if err != nil {
+			return err
+		}
 
-	domainPratial := path.Join(consts.GetBasePath(), "domains", foundProject.Name+".caddy")
-	_, err = os.ReadFile(domainPratial)
-	if err == nil {
-		err = os.Remove(domainPratial)
+		err = os.RemoveAll(path.Join(consts.GetBasePath(), "logs", foundProject.Name))
 		if err != nil {
 			return err
 		}
 
-		if !consts.Testing {
-			err = ReloadCaddy()
+		domainPratial := path.Join(consts.GetBasePath(), "domains", foundProject.Name+".caddy")
+		_, err = os.ReadFile(domainPratial)
+		if err == nil {
+			err = os.Remove(domainPratial)
 			if err != nil {
 				return err
 			}
-		}
 
-	}
+			if !consts.Testing {
+				err = removeCaddyRoute(foundProject.Name)
+				if err != nil {
+					return err
+				}
+			}
+
+		}
 
-	return p.saveProjectsToFile()
+		return nil
+	})
 }
 
 // Stringify returns a string representation of the Project.
@@ -441,6 +376,15 @@ func (ps Project) Stringify() string {
 	b.WriteString(" |Desc.  : " + ps.Description + "\n")
 	b.WriteString(" |Git    : " + ps.RepositoryURL + "\n")
 	b.WriteString(" |Branch : " + ps.Branch + "\n")
+	if ps.DeployTimeout != "" {
+		b.WriteString(" |Timeout: " + ps.DeployTimeout + "\n")
+	}
+	if ps.HealthCheck != nil {
+		b.WriteString(" |Health : " + ps.HealthCheck.Stringify() + "\n")
+	}
+	if ps.Strategy == StrategyBlueGreen {
+		b.WriteString(" |Deploy : blue/green, live in the " + slotName(ps.ActiveSlot) + " stack\n")
+	}
 	return b.String()
 }
 
@@ -454,24 +398,3 @@ func (p Projects) Stringify() string {
 	}
 	return b.String()
 }
-
-// FindAndEditEnv opens the .env file for editing using nano.
-func FindAndEditEnv(pName string) error {
-	p, err := FindProject(pName)
-	if err != nil {
-		return err
-	}
-
-	c := exec.Command("nano", ".env")
-	c.Stdin = os.Stdin
-	c.Stdout = os.Stdout
-	c.Stderr = os.Stderr
-	c.Dir = path.Join(consts.GetBasePath(), "projects", p.Name)
-
-	// Run the command and handle any error
-	if err := c.Run(); err != nil {
-		return fmt.Errorf("error running nano: %w", err)
-	}
-
-	return nil
-}
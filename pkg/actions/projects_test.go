@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"os"
 	"path"
+	"strconv"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -13,7 +14,7 @@ import (
 func TestAddReadFindProject(t *testing.T) {
 	consts.Testing = true
 
-	tmp := os.TempDir()
+	tmp := t.TempDir()
 	consts.BasePath = tmp
 	defer os.RemoveAll(tmp)
 
@@ -36,7 +37,7 @@ func TestAddReadFindProject(t *testing.T) {
 func TestCreateProjectBaseEnv(t *testing.T) {
 	consts.Testing = true
 
-	tmp := os.TempDir()
+	tmp := t.TempDir()
 	consts.BasePath = tmp
 	defer os.RemoveAll(tmp)
 
@@ -51,13 +52,16 @@ func TestCreateProjectBaseEnv(t *testing.T) {
 	err = os.MkdirAll(projectPath, 0755)
 	assert.Nil(t, err, "project directory created")
 
-	envMoleContent := "MOLE_TEST_KEY=test_value\n"
+	envMoleContent := "# app\nMOLE_TEST_KEY=test_value\nAPP_KEY={{.AppKey}}\n"
 	err = os.WriteFile(path.Join(projectPath, ".env.example"), []byte(envMoleContent), 0644)
 	assert.Nil(t, err, "example env.example file created")
 
 	fp, err := FindProject(np.Name)
 	assert.Nil(t, err, "project found successfully")
 
+	err = createProjectSecretsJson(fp)
+	assert.Nil(t, err, "project secrets created")
+
 	err = createProjectBaseEnv(fp)
 	assert.Nil(t, err, "base env created")
 
@@ -65,13 +69,16 @@ func TestCreateProjectBaseEnv(t *testing.T) {
 	envContent, err := os.ReadFile(envPath)
 	assert.Nil(t, err, "env file can be read")
 
-	assert.Contains(t, string(envContent), "MOLE_TEST_KEY=test_value", "env contains merged value from .env.example")
+	secrets, _ := readProjectSecrets(np.Name)
+	assert.Contains(t, string(envContent), "# app\nMOLE_TEST_KEY=test_value", "env contains the content of .env.example")
+	assert.Contains(t, string(envContent), "APP_KEY="+secrets.AppKey, "secrets are injected into .env")
+	assert.Contains(t, string(envContent), "MOLE_PORT_APP="+strconv.Itoa(secrets.PortApp), "app port is added to .env")
 }
 
 func TestCreateProjectSecretsJson(t *testing.T) {
 	consts.Testing = true
 
-	tmp := os.TempDir()
+	tmp := t.TempDir()
 	consts.BasePath = tmp
 	defer os.RemoveAll(tmp)
 
@@ -82,15 +89,20 @@ func TestCreateProjectSecretsJson(t *testing.T) {
 	err := createProjectSecretsJson(np)
 	assert.Nil(t, err, "project secrets JSON created successfully")
 
-	// Verify the secrets JSON file
+	// Verify the secrets file is encrypted at rest
 	secretsPath := path.Join(tmp, "secrets", np.Name+".json")
 	secretsContent, err := os.ReadFile(secretsPath)
 	assert.Nil(t, err, "secrets file can be read")
+	assert.False(t, json.Valid(secretsContent), "secrets file is not plaintext JSON")
+
+	info, err := os.Stat(secretsPath)
+	assert.Nil(t, err, "secrets file exists")
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm(), "secrets file is only readable by mole")
 
-	// Parse the secrets JSON
-	var secrets projectSecrets
-	err = json.Unmarshal(secretsContent, &secrets)
-	assert.Nil(t, err, "secrets JSON unmarshalled successfully")
+	// Decrypt the secrets
+	secrets, err := readProjectSecrets(np.Name)
+	assert.Nil(t, err, "secrets decrypted successfully")
+	assert.NotContains(t, string(secretsContent), secrets.DatabasePass, "database password is not stored in plaintext")
 
 	// Verify the contents
 	assert.Equal(t, "/home/mole/projects/test-project/.env", secrets.EnvFilePath, "EnvPath is correct")
@@ -106,7 +118,7 @@ func TestCreateProjectSecretsJson(t *testing.T) {
 func TestEnsureMoleSh(t *testing.T) {
 	consts.Testing = true
 
-	tmp := os.TempDir()
+	tmp := t.TempDir()
 	consts.BasePath = tmp
 	defer os.RemoveAll(tmp)
 
@@ -132,7 +144,7 @@ func TestEnsureMoleSh(t *testing.T) {
 func TestDeleteProject(t *testing.T) {
 	consts.Testing = true
 
-	tmp := os.TempDir()
+	tmp := t.TempDir()
 	consts.BasePath = tmp
 	defer os.RemoveAll(tmp)
 
@@ -0,0 +1,146 @@
+package actions
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"time"
+
+	"github.com/zulubit/mole/pkg/consts"
+	"github.com/zulubit/mole/pkg/helpers"
+)
+
+// DefaultRotationGrace is how long the previous value of a rotated secret stays available to templates by default.
+const DefaultRotationGrace = 24 * time.Hour
+
+// rotatableSecrets are the generated secrets "mole secrets rotate" can regenerate, with the length of their values.
+var rotatableSecrets = map[string]int{
+	"AppKey":        32,
+	"DatabasePass":  24,
+	"WebhookSecret": 32,
+}
+
+// RotatedSecret describes a secret regenerated by RotateProjectSecret.
+type RotatedSecret struct {
+	ProjectName   string     `json:"projectName"`
+	Secret        string     `json:"secret"`
+	PreviousUntil *time.Time `json:"previousUntil,omitempty"`
+}
+
+// Summary returns a human readable description of the rotation.
+func (r RotatedSecret) Summary() string {
+	if r.PreviousUntil == nil {
+		return "Rotated " + r.Secret + " of " + r.ProjectName
+	}
+	return "Rotated " + r.Secret + " of " + r.ProjectName + ", the previous value is available as {{.Previous." + r.Secret + "}} until " + r.PreviousUntil.Local().Format(time.RFC1123)
+}
+
+// RotateProjectSecret generates a new value for a generated secret of a project and renders the project's templates with it.
+// The variables of the .env whose line in the .env template uses the secret are set to the new value.
+// The previous value stays available to templates as {{.Previous.<secret>}} for the grace period, so the deployment
+// can move a database user or an integration over to the new value. A grace period of 0 drops the previous value right away.
+func RotateProjectSecret(projectNOI, secret string, grace time.Duration) (RotatedSecret, error) {
+	length, ok := rotatableSecrets[secret]
+	if !ok {
+		return RotatedSecret{}, fmt.Errorf("%s can not be rotated, rotate one of AppKey, DatabasePass or WebhookSecret", secret)
+	}
+
+	if grace < 0 {
+		return RotatedSecret{}, fmt.Errorf("invalid grace period %s", grace)
+	}
+
+	p, err := FindProject(projectNOI)
+	if err != nil {
+		return RotatedSecret{}, err
+	}
+
+	res := RotatedSecret{ProjectName: p.Name, Secret: secret}
+
+	err = updateProjectSecrets(p.Name, func(s *projectSecrets) error {
+		value := helpers.GenerateRandomKey(length)
+
+		var previous string
+		switch secret {
+		case "AppKey":
+			previous, s.AppKey = s.AppKey, value
+		case "DatabasePass":
+			previous, s.DatabasePass = s.DatabasePass, value
+		case "WebhookSecret":
+			previous, s.WebhookSecret = s.WebhookSecret, value
+		}
+
+		delete(s.Previous, secret)
+		delete(s.PreviousUntil, secret)
+
+		if grace == 0 || previous == "" {
+			return nil
+		}
+
+		if s.Previous == nil {
+			s.Previous = map[string]string{}
+		}
+		if s.PreviousUntil == nil {
+			s.PreviousUntil = map[string]time.Time{}
+		}
+
+		until := time.Now().Add(grace).UTC()
+		s.Previous[secret] = previous
+		s.PreviousUntil[secret] = until
+		res.PreviousUntil = &until
+
+		return nil
+	})
+	if err != nil {
+		return RotatedSecret{}, err
+	}
+
+	refresh, err := refreshEnvSecret(p, secret)
+	if err == nil {
+		_, err = updateProjectEnv(p, "rotate", refresh)
+	}
+	if err != nil {
+		return res, fmt.Errorf("rotated %s, but failed to update the .env: %w", secret, err)
+	}
+
+	if err := renderProjectTemplates(p); err != nil {
+		return res, fmt.Errorf("rotated %s, but failed to render the templates: %w", secret, err)
+	}
+
+	return res, nil
+}
+
+// dropExpiredPrevious removes the previous values of rotated secrets whose grace period is over.
+func (s *projectSecrets) dropExpiredPrevious(now time.Time) {
+	for secret, until := range s.PreviousUntil {
+		if now.After(until) {
+			delete(s.Previous, secret)
+			delete(s.PreviousUntil, secret)
+		}
+	}
+}
+
+// renderProjectTemplates renders the deployment and compose templates of a project that exist in its directory,
+// and the templates declared in its mole.yaml.
+func renderProjectTemplates(p Project) error {
+	projectPath := path.Join(consts.GetBasePath(), "projects", p.Name)
+
+	m, err := readManifest(p)
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(path.Join(projectPath, m.deployScript())); err == nil {
+		if err := TransformDeploy(p.Name); err != nil {
+			return err
+		}
+	}
+
+	if _, err := os.Stat(path.Join(projectPath, m.composeFile())); err == nil {
+		if err := TransformCompose(p.Name); err != nil {
+			return err
+		}
+	}
+
+	_, err = renderManifestTemplates(p)
+	return err
+}
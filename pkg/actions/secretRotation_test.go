@@ -0,0 +1,98 @@
+package actions
+
+import (
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zulubit/mole/pkg/consts"
+)
+
+func TestRotateProjectSecret(t *testing.T) {
+	consts.Testing = true
+
+	tmp := t.TempDir()
+	consts.BasePath = tmp
+
+	np := Project{Name: "rotate-project"}
+	err := addProject(np)
+	assert.Nil(t, err, "project added")
+	err = createProjectSecretsJson(np)
+	assert.Nil(t, err, "project secrets created")
+
+	projectPath := path.Join(tmp, "projects", np.Name)
+	err = os.MkdirAll(projectPath, 0755)
+	assert.Nil(t, err, "project directory created")
+	err = os.WriteFile(path.Join(projectPath, "mole.sh"), []byte(`{{.DatabasePass}} {{index .Previous "DatabasePass"}}`), 0644)
+	assert.Nil(t, err, "mole.sh written")
+
+	before, _ := readProjectSecrets(np.Name)
+
+	_, err = RotateProjectSecret(np.Name, "DatabaseName", time.Hour)
+	assert.ErrorContains(t, err, "can not be rotated", "only generated credentials can be rotated")
+
+	res, err := RotateProjectSecret(np.Name, "DatabasePass", time.Hour)
+	assert.Nil(t, err, "secret rotated")
+	assert.Equal(t, "DatabasePass", res.Secret)
+	assert.NotNil(t, res.PreviousUntil, "previous value is kept")
+
+	after, _ := readProjectSecrets(np.Name)
+	assert.NotEqual(t, before.DatabasePass, after.DatabasePass, "a new value is generated")
+	assert.Len(t, after.DatabasePass, 24, "new value has the length of the generated one")
+	assert.Equal(t, before.DatabasePass, after.Previous["DatabasePass"], "previous value is available")
+	assert.Equal(t, before.AppKey, after.AppKey, "other secrets are not rotated")
+
+	ready, err := os.ReadFile(path.Join(projectPath, "mole-ready.sh"))
+	assert.Nil(t, err, "templates are rendered")
+	assert.Equal(t, after.DatabasePass+" "+before.DatabasePass, string(ready), "templates see the new and the previous value")
+
+	err = updateProjectSecrets(np.Name, func(s *projectSecrets) error {
+		s.PreviousUntil["DatabasePass"] = time.Now().Add(-time.Minute)
+		return nil
+	})
+	assert.Nil(t, err, "grace period moved to the past")
+
+	expired, _ := readProjectSecrets(np.Name)
+	assert.Empty(t, expired.Previous, "previous value is dropped after the grace period")
+
+	res, err = RotateProjectSecret(np.Name, "AppKey", 0)
+	assert.Nil(t, err, "secret rotated without grace period")
+	assert.Nil(t, res.PreviousUntil, "previous value is not kept")
+
+	rotated, _ := readProjectSecrets(np.Name)
+	assert.NotEqual(t, before.AppKey, rotated.AppKey, "a new value is generated")
+	assert.Empty(t, rotated.Previous["AppKey"], "previous value is not available")
+}
+
+func TestRotateProjectSecretRefreshesEnv(t *testing.T) {
+	consts.Testing = true
+	consts.BasePath = t.TempDir()
+
+	np := Project{Name: "rotate-env"}
+	addProject(np)
+	createProjectSecretsJson(np)
+
+	projectPath := path.Join(consts.BasePath, "projects", np.Name)
+	os.MkdirAll(projectPath, 0755)
+	template := "DB_PASSWORD={{.DatabasePass}}\nDB_PASSWORD_OLD={{index .Previous \"DatabasePass\"}}\nAPP_KEY={{.AppKey}}\nLOG_LEVEL=info\n"
+	err := os.WriteFile(path.Join(projectPath, "mole.env"), []byte(template), 0644)
+	assert.Nil(t, err, "mole.env written")
+	err = createProjectBaseEnv(np)
+	assert.Nil(t, err, ".env rendered")
+	_, err = SetEnv(np.Name, []EnvVar{{Key: "LOG_LEVEL", Value: "debug"}})
+	assert.Nil(t, err, ".env edited on the server")
+
+	before, _ := readProjectSecrets(np.Name)
+	_, err = RotateProjectSecret(np.Name, "DatabasePass", time.Hour)
+	assert.Nil(t, err, "secret rotated")
+	after, _ := readProjectSecrets(np.Name)
+
+	env, _ := readEnvFile(np.Name)
+	values, _ := env.values()
+	assert.Equal(t, after.DatabasePass, values["DB_PASSWORD"], ".env holds the new value")
+	assert.Equal(t, "", values["DB_PASSWORD_OLD"], "variables using the previous value are left alone")
+	assert.Equal(t, before.AppKey, values["APP_KEY"], "variables using other secrets are left alone")
+	assert.Equal(t, "debug", values["LOG_LEVEL"], "edits made on the server survive")
+}
@@ -0,0 +1,300 @@
+package actions
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/gofrs/flock"
+	"github.com/zulubit/mole/pkg/consts"
+	"github.com/zulubit/mole/pkg/helpers"
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+// encryptedSecretsPrefix marks a secrets file encrypted with the master key.
+// It is followed by the base64 encoded nonce and secretbox.
+const encryptedSecretsPrefix = "mole:secretbox:v1:"
+
+// getMasterKeyPath returns the path to the key encrypting project secrets at rest.
+func getMasterKeyPath() string {
+	return path.Join(consts.GetBasePath(), ".mole", "master.key")
+}
+
+// getPendingMasterKeyPath returns the path a new master key is kept at while secrets are re-encrypted with it.
+func getPendingMasterKeyPath() string {
+	return getMasterKeyPath() + ".new"
+}
+
+// getMasterKeyLockPath returns the path to the lock guarding the master key.
+func getMasterKeyLockPath() string {
+	return getMasterKeyPath() + ".lock"
+}
+
+// getSecretsPath returns the path to the secrets file of a project.
+func getSecretsPath(projectName string) string {
+	return path.Join(consts.GetBasePath(), "secrets", projectName+".json")
+}
+
+// getSecretsLockPath returns the path to the lock guarding the secrets file of a project.
+func getSecretsLockPath(projectName string) string {
+	return path.Join(consts.GetBasePath(), "secrets", projectName+".lock")
+}
+
+// readMasterKey reads a master key file.
+func readMasterKey(keyPath string) (*[32]byte, error) {
+	encoded, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(encoded)))
+	if err != nil || len(raw) != 32 {
+		return nil, fmt.Errorf("master key at %s is malformed", keyPath)
+	}
+
+	var key [32]byte
+	copy(key[:], raw)
+
+	return &key, nil
+}
+
+// writeMasterKey generates a new master key and writes it to keyPath, readable by the mole user only.
+func writeMasterKey(keyPath string) (*[32]byte, error) {
+	var key [32]byte
+	if _, err := io.ReadFull(rand.Reader, key[:]); err != nil {
+		return nil, fmt.Errorf("failed to generate master key: %w", err)
+	}
+
+	if err := os.MkdirAll(path.Dir(keyPath), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create master key directory: %w", err)
+	}
+
+	encoded := base64.StdEncoding.EncodeToString(key[:]) + "\n"
+	if err := helpers.WriteFileAtomic(keyPath, []byte(encoded), 0600); err != nil {
+		return nil, fmt.Errorf("failed to write master key: %w", err)
+	}
+
+	return &key, nil
+}
+
+// lockMasterKey takes the master key lock. It is held while a secrets file is encrypted and written, and for a whole
+// rotation, so no secrets file is ever written with a key the rotation is replacing.
+func lockMasterKey() (*flock.Flock, error) {
+	if err := os.MkdirAll(path.Dir(getMasterKeyPath()), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create master key directory: %w", err)
+	}
+
+	fileLock, locked, err := lockWithTimeout(getMasterKeyLockPath())
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock the master key: %w", err)
+	}
+	if !locked {
+		return nil, fmt.Errorf("someone else is working with the master key, gave up after %s, please try again", projectStoreLockTimeout)
+	}
+
+	return fileLock, nil
+}
+
+// findOrCreateMasterKey returns the master key, generating it the first time secrets are written.
+func findOrCreateMasterKey() (*[32]byte, error) {
+	key, err := readMasterKey(getMasterKeyPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return writeMasterKey(getMasterKeyPath())
+	}
+
+	return key, err
+}
+
+// encryptSecrets seals plaintext with the master key.
+func encryptSecrets(plaintext []byte, key *[32]byte) ([]byte, error) {
+	var nonce [24]byte
+	if _, err := io.ReadFull(rand.Reader, nonce[:]); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	sealed := secretbox.Seal(nonce[:], plaintext, &nonce, key)
+
+	return []byte(encryptedSecretsPrefix + base64.StdEncoding.EncodeToString(sealed) + "\n"), nil
+}
+
+// decryptSecrets opens a secrets file. Files written before secrets were encrypted are returned as they are.
+// The pending master key of an interrupted rotation is tried after the current one.
+func decryptSecrets(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, []byte(encryptedSecretsPrefix)) {
+		return data, nil
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data[len(encryptedSecretsPrefix):])))
+	if err != nil || len(sealed) < 24 {
+		return nil, errors.New("secrets file is malformed")
+	}
+
+	var nonce [24]byte
+	copy(nonce[:], sealed[:24])
+
+	for _, keyPath := range []string{getMasterKeyPath(), getPendingMasterKeyPath()} {
+		key, err := readMasterKey(keyPath)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if plaintext, ok := secretbox.Open(nil, sealed[24:], &nonce, key); ok {
+			return plaintext, nil
+		}
+	}
+
+	return nil, fmt.Errorf("failed to decrypt secrets, they were not encrypted with the master key at %s", getMasterKeyPath())
+}
+
+// loadSecretsFile reads and decrypts the secrets file of a project, leaving plaintext files as they are.
+func loadSecretsFile(projectName string) ([]byte, bool, error) {
+	data, err := os.ReadFile(getSecretsPath(projectName))
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read secrets file: %w", err)
+	}
+
+	plaintext, err := decryptSecrets(data)
+	return plaintext, !bytes.HasPrefix(data, []byte(encryptedSecretsPrefix)), err
+}
+
+// readSecretsFile reads and decrypts the secrets file of a project.
+// Files written before secrets were encrypted are encrypted the first time they are read.
+func readSecretsFile(projectName string) ([]byte, error) {
+	plaintext, legacy, err := loadSecretsFile(projectName)
+	if err != nil || !legacy {
+		return plaintext, err
+	}
+
+	if err := encryptLegacySecrets(projectName); err != nil {
+		return nil, fmt.Errorf("failed to encrypt the plaintext secrets of %s: %w", projectName, err)
+	}
+
+	return plaintext, nil
+}
+
+// encryptLegacySecrets encrypts a plaintext secrets file with the master key and makes it readable by the mole user only.
+// The file is read again under the master key lock, as it may have been written in the meantime.
+func encryptLegacySecrets(projectName string) error {
+	keyLock, err := lockMasterKey()
+	if err != nil {
+		return err
+	}
+	defer keyLock.Unlock()
+
+	plaintext, legacy, err := loadSecretsFile(projectName)
+	if err != nil || !legacy {
+		return err
+	}
+
+	key, err := findOrCreateMasterKey()
+	if err != nil {
+		return err
+	}
+
+	return writeSecretsFile(projectName, plaintext, key)
+}
+
+// writeSecretsFile encrypts plaintext with key and writes it to the secrets file of a project.
+func writeSecretsFile(projectName string, plaintext []byte, key *[32]byte) error {
+	sealed, err := encryptSecrets(plaintext, key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(path.Join(consts.GetBasePath(), "secrets"), 0700); err != nil {
+		return fmt.Errorf("failed to create secrets directory: %w", err)
+	}
+
+	if err := helpers.WriteFileAtomic(getSecretsPath(projectName), sealed, 0600); err != nil {
+		return fmt.Errorf("failed to write secrets file: %w", err)
+	}
+
+	return nil
+}
+
+// updateProjectSecrets applies fn to the secrets of a project and saves them, holding the secrets lock of the project from reading to writing.
+// Nothing is written when fn returns an error.
+func updateProjectSecrets(projectName string, fn func(*projectSecrets) error) error {
+	if err := os.MkdirAll(path.Join(consts.GetBasePath(), "secrets"), 0700); err != nil {
+		return fmt.Errorf("failed to create secrets directory: %w", err)
+	}
+
+	fileLock, locked, err := lockWithTimeout(getSecretsLockPath(projectName))
+	if err != nil {
+		return fmt.Errorf("failed to lock the secrets of %s: %w", projectName, err)
+	}
+	if !locked {
+		return fmt.Errorf("someone else is working with the secrets of %s, gave up after %s, please try again", projectName, projectStoreLockTimeout)
+	}
+	defer fileLock.Unlock()
+
+	secrets, err := readProjectSecrets(projectName)
+	if err != nil {
+		return err
+	}
+
+	if err := fn(secrets); err != nil {
+		return err
+	}
+
+	return saveProjectSecrets(projectName, secrets)
+}
+
+// RotateMasterKey generates a new master key and re-encrypts the secrets of every project with it.
+// Secrets still stored in plaintext are encrypted as well. It returns the number of secrets files written.
+// Writes of secrets wait for the rotation, and are encrypted with the new key.
+func RotateMasterKey() (int, error) {
+	keyLock, err := lockMasterKey()
+	if err != nil {
+		return 0, err
+	}
+	defer keyLock.Unlock()
+
+	entries, err := os.ReadDir(path.Join(consts.GetBasePath(), "secrets"))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return 0, fmt.Errorf("failed to read secrets directory: %w", err)
+	}
+
+	// decrypt everything first, so a single unreadable file does not leave the rotation half done
+	plaintexts := map[string][]byte{}
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+
+		projectName := strings.TrimSuffix(e.Name(), ".json")
+		// the master key lock is already held, plaintext files are encrypted below
+		plaintext, _, err := loadSecretsFile(projectName)
+		if err != nil {
+			return 0, fmt.Errorf("secrets of %s: %w", projectName, err)
+		}
+		plaintexts[projectName] = plaintext
+	}
+
+	// until the new key replaces the old one, files already re-encrypted are opened with the pending key
+	key, err := writeMasterKey(getPendingMasterKeyPath())
+	if err != nil {
+		return 0, err
+	}
+
+	for projectName, plaintext := range plaintexts {
+		if err := writeSecretsFile(projectName, plaintext, key); err != nil {
+			return 0, fmt.Errorf("secrets of %s: %w", projectName, err)
+		}
+	}
+
+	if err := os.Rename(getPendingMasterKeyPath(), getMasterKeyPath()); err != nil {
+		return 0, fmt.Errorf("failed to replace master key: %w", err)
+	}
+
+	return len(plaintexts), nil
+}
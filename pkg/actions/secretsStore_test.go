@@ -0,0 +1,121 @@
+package actions
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zulubit/mole/pkg/consts"
+)
+
+func TestLegacyPlaintextSecrets(t *testing.T) {
+	consts.Testing = true
+
+	tmp := t.TempDir()
+	consts.BasePath = tmp
+
+	legacy, _ := json.Marshal(projectSecrets{ProjectName: "legacy", AppKey: "plain-app-key"})
+	err := os.MkdirAll(path.Join(tmp, "secrets"), 0755)
+	assert.Nil(t, err, "secrets directory created")
+	err = os.WriteFile(getSecretsPath("legacy"), legacy, 0644)
+	assert.Nil(t, err, "plaintext secrets written")
+
+	secrets, err := readProjectSecrets("legacy")
+	assert.Nil(t, err, "plaintext secrets can still be read")
+	assert.Equal(t, "plain-app-key", secrets.AppKey)
+
+	content, _ := os.ReadFile(getSecretsPath("legacy"))
+	assert.NotContains(t, string(content), "plain-app-key", "plaintext secrets are encrypted when read")
+	info, err := os.Stat(getSecretsPath("legacy"))
+	assert.Nil(t, err, "secrets file exists")
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm(), "encrypted secrets are readable by the mole user only")
+
+	err = os.WriteFile(getSecretsPath("legacy"), legacy, 0644)
+	assert.Nil(t, err, "plaintext secrets written again")
+
+	written, err := RotateMasterKey()
+	assert.Nil(t, err, "master key rotated")
+	assert.Equal(t, 1, written, "plaintext secrets are re-written")
+
+	content, _ = os.ReadFile(getSecretsPath("legacy"))
+	assert.NotContains(t, string(content), "plain-app-key", "plaintext secrets are encrypted by the rotation")
+
+	secrets, err = readProjectSecrets("legacy")
+	assert.Nil(t, err, "encrypted secrets can be read")
+	assert.Equal(t, "plain-app-key", secrets.AppKey)
+}
+
+func TestRotateMasterKey(t *testing.T) {
+	consts.Testing = true
+
+	tmp := t.TempDir()
+	consts.BasePath = tmp
+
+	for _, name := range []string{"first", "second"} {
+		err := createProjectSecretsJson(Project{Name: name})
+		assert.Nil(t, err, "project secrets created")
+	}
+
+	before, _ := readProjectSecrets("first")
+	oldKey, _ := os.ReadFile(getMasterKeyPath())
+	oldFile, _ := os.ReadFile(getSecretsPath("first"))
+
+	written, err := RotateMasterKey()
+	assert.Nil(t, err, "master key rotated")
+	assert.Equal(t, 2, written, "all secrets are re-encrypted")
+
+	newKey, _ := os.ReadFile(getMasterKeyPath())
+	newFile, _ := os.ReadFile(getSecretsPath("first"))
+	assert.NotEqual(t, oldKey, newKey, "master key is replaced")
+	assert.NotEqual(t, oldFile, newFile, "secrets are re-encrypted")
+
+	info, err := os.Stat(getMasterKeyPath())
+	assert.Nil(t, err, "master key exists")
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm(), "master key is only readable by mole")
+	_, err = os.Stat(getPendingMasterKeyPath())
+	assert.True(t, os.IsNotExist(err), "pending master key is removed")
+
+	after, err := readProjectSecrets("first")
+	assert.Nil(t, err, "secrets can be read with the new key")
+	assert.Equal(t, before, after, "secrets survive the rotation")
+
+	err = os.WriteFile(getMasterKeyPath(), oldKey, 0600)
+	assert.Nil(t, err, "old master key restored")
+	_, err = readProjectSecrets("first")
+	assert.ErrorContains(t, err, "failed to decrypt secrets", "secrets can not be read with the wrong key")
+}
+
+func TestRotateMasterKeyWhileWriting(t *testing.T) {
+	consts.Testing = true
+	consts.BasePath = t.TempDir()
+
+	err := createProjectSecretsJson(Project{Name: "test"})
+	assert.Nil(t, err, "project secrets created")
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := range 20 {
+			err := updateProjectSecrets("test", func(s *projectSecrets) error {
+				s.AppKey = "key-" + strconv.Itoa(i)
+				return nil
+			})
+			assert.Nil(t, err, "secrets written during the rotation")
+		}
+	}()
+
+	for range 5 {
+		_, err := RotateMasterKey()
+		assert.Nil(t, err, "master key rotated")
+	}
+	wg.Wait()
+
+	secrets, err := readProjectSecrets("test")
+	assert.Nil(t, err, "secrets written during the rotation can be read with the final key")
+	assert.Equal(t, "key-19", secrets.AppKey, "no write is lost")
+}
@@ -16,7 +16,7 @@ var tkw string = "ADAQABAAABAQDJ+CEFyKmeys1gL/EsBhKIf58Rny7kQUZe5XbktCZl8HC+Oq49
 func TestFindOrCreateDeployKey(t *testing.T) {
 	consts.Testing = true
 
-	consts.BasePath = os.TempDir()
+	consts.BasePath = t.TempDir()
 	defer os.RemoveAll(consts.BasePath)
 
 	key, err := FindOrCreateDeployKey()
@@ -27,7 +27,7 @@ func TestFindOrCreateDeployKey(t *testing.T) {
 func TestAddAuthorizedKeys(t *testing.T) {
 	consts.Testing = true
 
-	consts.BasePath = os.TempDir()
+	consts.BasePath = t.TempDir()
 	defer os.RemoveAll(consts.BasePath)
 
 	err := AddAuthorizedKeys(tk, "test-key")
@@ -46,7 +46,7 @@ func TestAddAuthorizedKeys(t *testing.T) {
 func TestFindOrCreateActionsKey(t *testing.T) {
 	consts.Testing = true
 
-	consts.BasePath = os.TempDir()
+	consts.BasePath = t.TempDir()
 	defer os.RemoveAll(consts.BasePath)
 
 	privateKey, err := FindOrCreateActionsKey()
@@ -68,7 +68,7 @@ func TestFindOrCreateActionsKey(t *testing.T) {
 func TestEnsureShhDirectory(t *testing.T) {
 	consts.Testing = true
 
-	consts.BasePath = os.TempDir()
+	consts.BasePath = t.TempDir()
 	defer os.RemoveAll(consts.BasePath)
 
 	err := ensureShhDirectory()
@@ -87,7 +87,7 @@ func TestEnsureShhDirectory(t *testing.T) {
 func TestCheckAuthorizedExists(t *testing.T) {
 	consts.Testing = true
 
-	consts.BasePath = os.TempDir()
+	consts.BasePath = t.TempDir()
 	defer os.RemoveAll(consts.BasePath)
 
 	err := AddAuthorizedKeys(tk, "test-key")
@@ -0,0 +1,316 @@
+package actions
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shirou/gopsutil/net"
+	"github.com/zulubit/mole/pkg/consts"
+)
+
+// Overall states of a project's stack, derived from the states of its containers.
+const (
+	StackRunning  = "running"
+	StackDegraded = "degraded"
+	StackStopped  = "stopped"
+)
+
+// ProjectStatus summarizes whether a project is running and how it was last deployed.
+type ProjectStatus struct {
+	ProjectName string `json:"projectName"`
+	Strategy    string `json:"strategy,omitempty"`
+	ActiveSlot  string `json:"activeSlot,omitempty"`
+	// State is running, degraded or stopped, and empty for projects without a compose stack.
+	State    string            `json:"state,omitempty"`
+	Services []ContainerStatus `json:"services"`
+	// ServicesError explains why the containers could not be listed, e.g. because docker is not running.
+	ServicesError  string       `json:"servicesError,omitempty"`
+	Ports          []PortStatus `json:"ports"`
+	Domains        []string     `json:"domains"`
+	Deploying      bool         `json:"deploying"`
+	LastDeployment *Deployment  `json:"lastDeployment,omitempty"`
+}
+
+// ContainerStatus is the status of a container together with its resource usage.
+type ContainerStatus struct {
+	ComposeService
+	CPUPercent    float64 `json:"cpuPercent"`
+	MemoryUsage   string  `json:"memoryUsage,omitempty"`
+	MemoryPercent float64 `json:"memoryPercent"`
+}
+
+// PortStatus is a port reserved for a project and whether something listens on it.
+type PortStatus struct {
+	Name      string `json:"name"`
+	Port      int    `json:"port"`
+	Listening bool   `json:"listening"`
+}
+
+// containerStats is the resource usage of a running container, as printed by "docker stats".
+type containerStats struct {
+	Name     string
+	CPUPerc  string
+	MemUsage string
+	MemPerc  string
+}
+
+// readContainerStats returns the resource usage of the given running containers by name.
+var readContainerStats = func(names []string) (map[string]containerStats, error) {
+	args := append([]string{"stats", "--no-stream", "--format", "{{json .}}"}, names...)
+	out, err := exec.Command("docker", args...).Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return nil, fmt.Errorf("docker stats failed: %s", strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return nil, fmt.Errorf("docker stats failed: %w", err)
+	}
+
+	stats := map[string]containerStats{}
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		var s containerStats
+		if err := json.Unmarshal(scanner.Bytes(), &s); err != nil {
+			return nil, fmt.Errorf("failed to parse docker stats output: %w", err)
+		}
+		stats[s.Name] = s
+	}
+
+	return stats, nil
+}
+
+// listeningPorts returns the local TCP ports something listens on.
+var listeningPorts = func() (map[int]bool, error) {
+	connections, err := net.Connections("tcp")
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve TCP connections: %w", err)
+	}
+
+	listening := map[int]bool{}
+	for _, conn := range connections {
+		if conn.Status == "LISTEN" {
+			listening[int(conn.Laddr.Port)] = true
+		}
+	}
+	return listening, nil
+}
+
+// ProjectsStatus reports the status of a project, or of all projects when projectNOI is empty.
+func ProjectsStatus(projectNOI string) ([]ProjectStatus, error) {
+	if projectNOI != "" {
+		p, err := FindProject(projectNOI)
+		if err != nil {
+			return nil, err
+		}
+
+		st, err := projectStatus(p)
+		if err != nil {
+			return nil, err
+		}
+		return []ProjectStatus{st}, nil
+	}
+
+	ps, err := readProjectsFromFile()
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := []ProjectStatus{}
+	for _, p := range ps.Projects {
+		st, err := projectStatus(p)
+		if err != nil {
+			return nil, err
+		}
+		statuses = append(statuses, st)
+	}
+
+	return statuses, nil
+}
+
+// projectStatus gathers the status of a single project.
+// Failing to reach docker is reported in the status, not as an error, so the rest of it is still shown.
+func projectStatus(p Project) (ProjectStatus, error) {
+	st := ProjectStatus{
+		ProjectName: p.Name,
+		Strategy:    p.Strategy,
+		ActiveSlot:  p.ActiveSlot,
+		Services:    []ContainerStatus{},
+		Ports:       []PortStatus{},
+		Domains:     configuredDomains(p),
+		Deploying:   projectDeployState(p.Name).Running,
+	}
+
+	// the status only looks, the compose file is not rendered again like "mole compose ps" would
+	_, err := os.Stat(path.Join(consts.GetBasePath(), "projects", p.Name, composeReadyFile))
+	hasStack := err == nil
+
+	var services []ComposeService
+	if hasStack {
+		services, err = composeServices(p, "")
+	}
+
+	switch {
+	case !hasStack:
+		// projects without a compose file, such as static sites, have no stack
+	case err != nil:
+		st.ServicesError = err.Error()
+	default:
+		st.Services, err = withContainerStats(services)
+		if err != nil {
+			st.ServicesError = err.Error()
+		}
+		st.State = stackState(st.Services)
+	}
+
+	secrets, err := readProjectSecrets(p.Name)
+	if err != nil {
+		return st, fmt.Errorf("failed to load secrets for project %s: %w", p.Name, err)
+	}
+
+	listening, err := listeningPorts()
+	if err != nil {
+		return st, err
+	}
+	for _, port := range []PortStatus{{Name: "PortApp", Port: secrets.PortApp}, {Name: "PortTwo", Port: secrets.PortTwo}, {Name: "PortThree", Port: secrets.PortThree}} {
+		port.Listening = listening[port.Port]
+		st.Ports = append(st.Ports, port)
+	}
+
+	h, err := readDeploymentHistory(p.Name)
+	if err != nil {
+		return st, err
+	}
+	if len(h.Deployments) > 0 {
+		st.LastDeployment = &h.Deployments[len(h.Deployments)-1]
+	}
+
+	return st, nil
+}
+
+// withContainerStats adds the resource usage of the running containers to their status.
+// The status is returned without it when docker stats fails.
+func withContainerStats(services []ComposeService) ([]ContainerStatus, error) {
+	containers := make([]ContainerStatus, len(services))
+	running := []string{}
+	for i, s := range services {
+		containers[i] = ContainerStatus{ComposeService: s}
+		if s.State == "running" {
+			running = append(running, s.Name)
+		}
+	}
+
+	if len(running) == 0 {
+		return containers, nil
+	}
+
+	stats, err := readContainerStats(running)
+	if err != nil {
+		return containers, err
+	}
+
+	for i, c := range containers {
+		s, ok := stats[c.Name]
+		if !ok {
+			continue
+		}
+		containers[i].CPUPercent = parsePercent(s.CPUPerc)
+		containers[i].MemoryUsage = s.MemUsage
+		containers[i].MemoryPercent = parsePercent(s.MemPerc)
+	}
+
+	return containers, nil
+}
+
+// parsePercent parses a percentage such as "12.34%", 0 if it is not one.
+func parsePercent(s string) float64 {
+	f, _ := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(s), "%"), 64)
+	return f
+}
+
+// stackState derives the overall state of a stack from its containers.
+// Containers that exited with 0, such as migrations, count as done rather than down.
+func stackState(containers []ContainerStatus) string {
+	up, down := 0, 0
+	for _, c := range containers {
+		switch {
+		case c.State == "running" && c.Health != "unhealthy":
+			up++
+		case c.State == "exited" && c.ExitCode == 0:
+		default:
+			down++
+		}
+	}
+
+	switch {
+	case up > 0 && down == 0:
+		return StackRunning
+	case up > 0:
+		return StackDegraded
+	default:
+		return StackStopped
+	}
+}
+
+// Stringify returns a string representation of the ProjectStatus.
+func (st ProjectStatus) Stringify() string {
+	var b strings.Builder
+	b.WriteString("\n")
+	b.WriteString(" |Project    : " + st.ProjectName + "\n")
+
+	state := st.State
+	if state == "" {
+		state = "no compose stack"
+	}
+	if st.Deploying {
+		state += ", deploying"
+	}
+	b.WriteString(" |State      : " + state + "\n")
+
+	if st.Strategy == StrategyBlueGreen {
+		b.WriteString(" |Live slot  : " + slotName(st.ActiveSlot) + "\n")
+	}
+
+	if st.ServicesError != "" {
+		b.WriteString(" |Services   : " + st.ServicesError + "\n")
+	}
+	for _, c := range st.Services {
+		line := c.Stringify()
+		if c.State == "running" && c.MemoryUsage != "" {
+			line += fmt.Sprintf("  cpu %.1f%%, mem %s", c.CPUPercent, c.MemoryUsage)
+		}
+		b.WriteString(" |Service    : " + line + "\n")
+	}
+
+	ports := make([]string, len(st.Ports))
+	for i, p := range st.Ports {
+		state := "closed"
+		if p.Listening {
+			state = "listening"
+		}
+		ports[i] = strconv.Itoa(p.Port) + " " + state
+	}
+	b.WriteString(" |Ports      : " + strings.Join(ports, ", ") + "\n")
+
+	domains := "none"
+	if len(st.Domains) > 0 {
+		domains = strings.Join(st.Domains, ", ")
+	}
+	b.WriteString(" |Domains    : " + domains + "\n")
+
+	if d := st.LastDeployment; d != nil {
+		b.WriteString(" |Deployed   : " + d.Status + " " + shortCommit(d.Commit) + " " + d.FinishedAt.Format(time.RFC3339) + " (" + d.DeploymentID + ")\n")
+	} else {
+		b.WriteString(" |Deployed   : never\n")
+	}
+
+	return b.String()
+}
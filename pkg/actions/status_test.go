@@ -0,0 +1,91 @@
+package actions
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zulubit/mole/pkg/consts"
+)
+
+func TestProjectsStatus(t *testing.T) {
+	consts.Testing = true
+
+	tmp := t.TempDir()
+	consts.BasePath = tmp
+
+	np := Project{Name: "status-project"}
+	err := addProject(np)
+	assert.Nil(t, err, "project added")
+	err = createProjectSecretsJson(np)
+	assert.Nil(t, err, "project secrets created")
+	secrets, _ := readProjectSecrets(np.Name)
+
+	psOutput := `[{"Name":"status-project-app-1","Service":"app","State":"running","Status":"Up 1 hour"},{"Name":"status-project-migrate-1","Service":"migrate","State":"exited","ExitCode":0,"Status":"Exited (0)"}]`
+	originalRunCompose, originalStats, originalListening := runCompose, readContainerStats, listeningPorts
+	defer func() { runCompose, readContainerStats, listeningPorts = originalRunCompose, originalStats, originalListening }()
+	runCompose = func(p Project, env []string, args []string, cio ComposeIO) error {
+		io.WriteString(cio.Stdout, psOutput)
+		return nil
+	}
+	var statsOf []string
+	readContainerStats = func(names []string) (map[string]containerStats, error) {
+		statsOf = names
+		return map[string]containerStats{"status-project-app-1": {Name: "status-project-app-1", CPUPerc: "1.50%", MemUsage: "12MiB / 1GiB", MemPerc: "1.17%"}}, nil
+	}
+	listeningPorts = func() (map[int]bool, error) {
+		return map[int]bool{secrets.PortApp: true}, nil
+	}
+
+	statuses, err := ProjectsStatus(np.Name)
+	assert.Nil(t, err, "status gathered")
+	st := statuses[0]
+	assert.Equal(t, "", st.State, "projects without a compose file have no stack")
+	assert.Empty(t, st.Services)
+	assert.Equal(t, []PortStatus{
+		{Name: "PortApp", Port: secrets.PortApp, Listening: true},
+		{Name: "PortTwo", Port: secrets.PortTwo},
+		{Name: "PortThree", Port: secrets.PortThree},
+	}, st.Ports, "reserved ports are checked")
+	assert.Nil(t, st.LastDeployment, "project was never deployed")
+	assert.Contains(t, st.Stringify(), "never")
+
+	projectPath := path.Join(tmp, "projects", np.Name)
+	os.MkdirAll(projectPath, 0755)
+	os.WriteFile(path.Join(projectPath, "mole-compose-ready.yaml"), []byte("services: {}"), 0644)
+	os.WriteFile(path.Join(projectPath, "mole.sh"), []byte("echo deployed"), 0755)
+	_, err = RunDeployment(np.Name, DeployOptions{})
+	assert.Nil(t, err, "project deployed")
+	err = AddDomainProxy(np.Name, "status.com", 0)
+	assert.Nil(t, err, "domain added")
+
+	statuses, err = ProjectsStatus("")
+	assert.Nil(t, err, "status of all projects gathered")
+	st = statuses[0]
+	assert.Equal(t, StackRunning, st.State, "containers that exited with 0 do not count as down")
+	assert.Equal(t, []string{"status-project-app-1"}, statsOf, "only running containers have stats")
+	assert.Equal(t, 1.5, st.Services[0].CPUPercent, "resource usage is added")
+	assert.Equal(t, "12MiB / 1GiB", st.Services[0].MemoryUsage)
+	assert.Equal(t, []string{"status.com"}, st.Domains, "www redirect is left out")
+	assert.Equal(t, "success", st.LastDeployment.Status, "last deployment is reported")
+
+	psOutput = `[{"Name":"status-project-app-1","Service":"app","State":"running","Health":"unhealthy"},{"Name":"status-project-db-1","Service":"db","State":"running"}]`
+	statuses, _ = ProjectsStatus(np.Name)
+	assert.Equal(t, StackDegraded, statuses[0].State, "unhealthy containers degrade the stack")
+
+	psOutput = `[{"Name":"status-project-app-1","Service":"app","State":"exited","ExitCode":137}]`
+	statuses, _ = ProjectsStatus(np.Name)
+	assert.Equal(t, StackStopped, statuses[0].State, "stack without running containers is stopped")
+
+	runCompose = func(p Project, env []string, args []string, cio ComposeIO) error {
+		io.WriteString(cio.Stderr, "Cannot connect to the Docker daemon")
+		return errors.New("exit status 1")
+	}
+	statuses, err = ProjectsStatus(np.Name)
+	assert.Nil(t, err, "docker failures do not fail the status")
+	assert.Contains(t, statuses[0].ServicesError, "Cannot connect to the Docker daemon")
+	assert.Len(t, statuses[0].Ports, 3, "the rest of the status is still gathered")
+}
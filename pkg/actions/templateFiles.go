@@ -0,0 +1,217 @@
+package actions
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/zulubit/mole/pkg/consts"
+	"github.com/zulubit/mole/pkg/helpers"
+)
+
+// maskedSecret replaces secret values in the output of "mole templates render --dry-run".
+const maskedSecret = "********"
+
+// RenderedTemplate is a template file of a project that was rendered.
+type RenderedTemplate struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+	// Content is the rendered template with its secrets masked, only set for dry runs.
+	Content string `json:"content,omitempty"`
+}
+
+// builtinTemplateFiles returns the templates mole renders without them being listed under templates in mole.yaml,
+// if the project has them.
+func builtinTemplateFiles(p Project, m Manifest) []TemplateFile {
+	candidates := []TemplateFile{
+		{Source: m.deployScript(), Target: "mole-ready.sh"},
+		{Source: m.composeFile(), Target: "mole-compose-ready.yaml"},
+	}
+	for _, hook := range deployHooks {
+		candidates = append(candidates, TemplateFile{Source: hook.Template, Target: hook.Ready})
+	}
+
+	files := []TemplateFile{}
+	for _, t := range candidates {
+		if _, err := os.Stat(path.Join(consts.GetBasePath(), "projects", p.Name, t.Source)); err == nil {
+			files = append(files, t)
+		}
+	}
+	return files
+}
+
+// renderTemplateFile renders a template file of a project.
+func renderTemplateFile(p Project, secrets *projectSecrets, t TemplateFile) (string, error) {
+	content, err := os.ReadFile(path.Join(consts.GetBasePath(), "projects", p.Name, t.Source))
+	if err != nil {
+		return "", fmt.Errorf("failed to read template file %s: %w", t.Source, err)
+	}
+
+	rendered, err := renderTemplate(string(content), secrets)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", t.Source, err)
+	}
+
+	return rendered, nil
+}
+
+// writeTemplateFile writes a rendered template to its target, with the mode of the template or of its source.
+func writeTemplateFile(p Project, t TemplateFile, rendered string) error {
+	projectPath := path.Join(consts.GetBasePath(), "projects", p.Name)
+	target := path.Join(projectPath, t.Target)
+
+	var mode os.FileMode
+	if t.Mode != "" {
+		m, err := t.fileMode()
+		if err != nil {
+			return err
+		}
+		mode = m
+	} else {
+		info, err := os.Stat(path.Join(projectPath, t.Source))
+		if err != nil {
+			return fmt.Errorf("failed to read template file %s: %w", t.Source, err)
+		}
+		mode = info.Mode().Perm()
+	}
+
+	if err := os.MkdirAll(path.Dir(target), 0755); err != nil {
+		return fmt.Errorf("failed to create directory of %s: %w", t.Target, err)
+	}
+
+	if err := helpers.WriteFileAtomic(target, []byte(rendered), mode); err != nil {
+		return fmt.Errorf("failed to write %s: %w", t.Target, err)
+	}
+
+	return nil
+}
+
+// renderManifestTemplates renders the templates declared in the mole.yaml of a project to their targets.
+// Every template is rendered before any is written, so a broken template leaves the previous files in place.
+func renderManifestTemplates(p Project) ([]TemplateFile, error) {
+	m, err := readManifest(p)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(m.Templates) == 0 {
+		return nil, nil
+	}
+
+	secrets, err := readProjectSecrets(p.Name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load secrets for project %s: %w", p.Name, err)
+	}
+
+	rendered := make([]string, len(m.Templates))
+	for i, t := range m.Templates {
+		if rendered[i], err = renderTemplateFile(p, secrets, t); err != nil {
+			return nil, err
+		}
+	}
+
+	for i, t := range m.Templates {
+		if err := writeTemplateFile(p, t, rendered[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	return m.Templates, nil
+}
+
+// RenderTemplates renders the templates of a project, those declared in its mole.yaml as well as mole.sh,
+// its hooks and mole-compose.yaml. With a file, only the template with that source or target is rendered.
+// A dry run writes nothing and returns the rendered templates with the project secrets masked.
+func RenderTemplates(projectNOI, file string, dryRun bool) ([]RenderedTemplate, error) {
+	p, err := FindProject(projectNOI)
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := readManifest(p)
+	if err != nil {
+		return nil, err
+	}
+
+	files := append(builtinTemplateFiles(p, m), m.Templates...)
+	if file != "" {
+		files = selectTemplateFile(files, file)
+		if len(files) == 0 {
+			return nil, notFoundError{fmt.Sprintf("%s is not a template of %s, declare it in %s", file, p.Name, manifestFile)}
+		}
+	}
+
+	secrets, err := readProjectSecrets(p.Name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load secrets for project %s: %w", p.Name, err)
+	}
+
+	rendered := make([]RenderedTemplate, len(files))
+	for i, t := range files {
+		content, err := renderTemplateFile(p, secrets, t)
+		if err != nil {
+			return nil, err
+		}
+
+		rendered[i] = RenderedTemplate{Source: t.Source, Target: t.Target, Content: content}
+	}
+
+	for i, t := range files {
+		if dryRun {
+			rendered[i].Content = maskSecrets(rendered[i].Content, secrets)
+			continue
+		}
+
+		if err := writeTemplateFile(p, t, rendered[i].Content); err != nil {
+			return nil, err
+		}
+		rendered[i].Content = ""
+	}
+
+	return rendered, nil
+}
+
+// selectTemplateFile returns the template whose source or target is file.
+func selectTemplateFile(files []TemplateFile, file string) []TemplateFile {
+	file = filepath.Clean(file)
+	for _, t := range files {
+		if filepath.Clean(t.Source) == file || filepath.Clean(t.Target) == file {
+			return []TemplateFile{t}
+		}
+	}
+	return nil
+}
+
+// maskSecrets replaces the credentials among the project secrets, and their base64 encodings, in rendered content.
+// Paths, ports and names are left visible, since they are needed to review the result.
+func maskSecrets(content string, s *projectSecrets) string {
+	values := []string{s.AppKey, s.DatabasePass, s.WebhookSecret}
+	for _, v := range s.Custom {
+		values = append(values, v)
+	}
+	for _, v := range s.Previous {
+		values = append(values, v)
+	}
+
+	masked := []string{}
+	for _, v := range values {
+		// very short values would mask unrelated text, such as "1" or "true"
+		if len(v) < 4 {
+			continue
+		}
+		masked = append(masked, v, base64.StdEncoding.EncodeToString([]byte(v)))
+	}
+
+	// longer values first, so a value containing another one is masked as a whole
+	sort.Slice(masked, func(i, j int) bool { return len(masked[i]) > len(masked[j]) })
+
+	for _, v := range masked {
+		content = strings.ReplaceAll(content, v, maskedSecret)
+	}
+
+	return content
+}
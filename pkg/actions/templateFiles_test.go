@@ -0,0 +1,75 @@
+package actions
+
+import (
+	"os"
+	"path"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zulubit/mole/pkg/consts"
+)
+
+func TestRenderTemplates(t *testing.T) {
+	consts.Testing = true
+
+	tmp := t.TempDir()
+	consts.BasePath = tmp
+
+	np := Project{Name: "render-project"}
+	err := addProject(np)
+	assert.Nil(t, err, "project added")
+	err = createProjectSecretsJson(np)
+	assert.Nil(t, err, "project secrets created")
+	err = SetProjectSecret(np.Name, "API_TOKEN", "token-value")
+	assert.Nil(t, err, "custom secret set")
+
+	projectPath := path.Join(tmp, "projects", np.Name)
+	err = os.MkdirAll(path.Join(projectPath, "config"), 0755)
+	assert.Nil(t, err, "project directory created")
+
+	files := map[string]string{
+		"mole.sh":               "echo {{.ProjectName}}",
+		"nginx.conf.tmpl":       "listen {{.PortApp}};",
+		"config/prod.yaml.tmpl": "key: {{.AppKey}}\ntoken: {{secret \"API_TOKEN\" | b64enc}}\nname: {{.ProjectName}}",
+		"mole.yaml":             "templates:\n  - nginx.conf.tmpl\n  - source: config/prod.yaml.tmpl\n    target: config/prod.yaml\n    mode: \"0600\"\n",
+	}
+	for name, content := range files {
+		err = os.WriteFile(path.Join(projectPath, name), []byte(content), 0644)
+		assert.Nil(t, err, name+" written")
+	}
+
+	secrets, _ := readProjectSecrets(np.Name)
+
+	rendered, err := RenderTemplates(np.Name, "config/prod.yaml", true)
+	assert.Nil(t, err, "dry run rendered")
+	assert.Len(t, rendered, 1, "only the selected template is rendered")
+	assert.Equal(t, "key: ********\ntoken: ********\nname: render-project", rendered[0].Content, "secrets are masked")
+	_, err = os.Stat(path.Join(projectPath, "config/prod.yaml"))
+	assert.True(t, os.IsNotExist(err), "dry runs write nothing")
+
+	_, err = RenderTemplates(np.Name, "missing.conf", true)
+	assert.ErrorIs(t, err, ErrNotFound, "undeclared files are not rendered")
+
+	rendered, err = RenderTemplates(np.Name, "", false)
+	assert.Nil(t, err, "templates rendered")
+	assert.Equal(t, []RenderedTemplate{
+		{Source: "mole.sh", Target: "mole-ready.sh"},
+		{Source: "nginx.conf.tmpl", Target: "nginx.conf"},
+		{Source: "config/prod.yaml.tmpl", Target: "config/prod.yaml"},
+	}, rendered, "built-in and declared templates are rendered")
+
+	nginx, _ := os.ReadFile(path.Join(projectPath, "nginx.conf"))
+	assert.Equal(t, "listen "+strconv.Itoa(secrets.PortApp)+";", string(nginx), "template rendered to its target")
+
+	info, err := os.Stat(path.Join(projectPath, "config/prod.yaml"))
+	assert.Nil(t, err, "target written")
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm(), "mode of the manifest is used")
+
+	err = os.WriteFile(path.Join(projectPath, "nginx.conf.tmpl"), []byte("listen {{.Missing}};"), 0644)
+	assert.Nil(t, err, "broken template written")
+	_, err = renderManifestTemplates(np)
+	assert.ErrorContains(t, err, "nginx.conf.tmpl", "broken templates fail the render")
+	nginx, _ = os.ReadFile(path.Join(projectPath, "nginx.conf"))
+	assert.Equal(t, "listen "+strconv.Itoa(secrets.PortApp)+";", string(nginx), "previous file is kept when a template fails")
+}
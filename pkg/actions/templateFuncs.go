@@ -0,0 +1,99 @@
+package actions
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/zulubit/mole/pkg/helpers"
+	"gopkg.in/yaml.v3"
+)
+
+// templateFuncs returns the functions available in mole templates, documented in docs/templates.md.
+func templateFuncs(data *projectSecrets) template.FuncMap {
+	return template.FuncMap{
+		"secret": func(key string) (string, error) {
+			value, ok := data.Custom[key]
+			if !ok {
+				return "", fmt.Errorf("secret %s is not set, add it with mole secrets set", key)
+			}
+			return value, nil
+		},
+		"default":      templateDefault,
+		"required":     templateRequired,
+		"quote":        func(v any) string { return strconv.Quote(fmt.Sprint(v)) },
+		"shellEscape":  func(v any) string { return shellEscape(fmt.Sprint(v)) },
+		"b64enc":       func(v any) string { return base64.StdEncoding.EncodeToString([]byte(fmt.Sprint(v))) },
+		"b64dec":       templateB64dec,
+		"env":          os.Getenv,
+		"randAlphaNum": helpers.GenerateRandomKey,
+		"toYaml":       templateToYaml,
+		"toJson":       templateToJson,
+	}
+}
+
+// isEmptyValue reports whether v is nil or the zero value of its type, an empty map or an empty slice.
+func isEmptyValue(v any) bool {
+	if v == nil {
+		return true
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Map, reflect.Slice, reflect.Array, reflect.String:
+		return rv.Len() == 0
+	default:
+		return rv.IsZero()
+	}
+}
+
+// templateDefault returns given, or def when given is empty: {{index .Custom "LOG_LEVEL" | default "info"}}.
+func templateDefault(def any, given ...any) any {
+	if len(given) == 0 || isEmptyValue(given[0]) {
+		return def
+	}
+	return given[0]
+}
+
+// templateRequired fails the render with msg when v is empty: {{required "set APP_URL" (index .Custom "APP_URL")}}.
+func templateRequired(msg string, v any) (any, error) {
+	if isEmptyValue(v) {
+		return nil, errors.New(msg)
+	}
+	return v, nil
+}
+
+// shellEscape single-quotes s for POSIX shells.
+func shellEscape(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func templateB64dec(s string) (string, error) {
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return "", fmt.Errorf("b64dec: %w", err)
+	}
+	return string(b), nil
+}
+
+func templateToYaml(v any) (string, error) {
+	b, err := yaml.Marshal(v)
+	if err != nil {
+		return "", fmt.Errorf("toYaml: %w", err)
+	}
+	return strings.TrimSuffix(string(b), "\n"), nil
+}
+
+func templateToJson(v any) (string, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", fmt.Errorf("toJson: %w", err)
+	}
+	return string(b), nil
+}
@@ -0,0 +1,60 @@
+package actions
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTemplateFuncs(t *testing.T) {
+	t.Setenv("MOLE_TEMPLATE_TEST", "from-env")
+
+	data := &projectSecrets{
+		ProjectName: "funcs",
+		PortApp:     9000,
+		Custom:      map[string]string{"EMPTY": "", "NAME": "it's mole"},
+	}
+
+	tests := []struct {
+		template string
+		expected string
+	}{
+		{`{{.Custom.EMPTY | default "fallback"}}`, "fallback"},
+		{`{{.Custom.NAME | default "fallback"}}`, "it's mole"},
+		{`{{index .Custom "MISSING" | default "fallback"}}`, "fallback"},
+		{`{{.PortApp | default 8080}}`, "9000"},
+		{`{{required "name is required" .Custom.NAME}}`, "it's mole"},
+		{`{{.Custom.NAME | quote}}`, `"it's mole"`},
+		{`{{.Custom.NAME | shellEscape}}`, `'it'\''s mole'`},
+		{`{{.ProjectName | b64enc}}`, "ZnVuY3M="},
+		{`{{"ZnVuY3M=" | b64dec}}`, "funcs"},
+		{`{{env "MOLE_TEMPLATE_TEST"}}`, "from-env"},
+		{`{{randAlphaNum 12 | len}}`, "12"},
+		{`{{toJson .Custom}}`, `{"EMPTY":"","NAME":"it's mole"}`},
+		{`{{toYaml .Custom}}`, "EMPTY: \"\"\nNAME: it's mole"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.template, func(t *testing.T) {
+			rendered, err := renderTemplate(tt.template, data)
+			assert.Nil(t, err, "template renders")
+			assert.Equal(t, tt.expected, rendered)
+		})
+	}
+}
+
+func TestTemplateStrictness(t *testing.T) {
+	data := &projectSecrets{Custom: map[string]string{}}
+
+	_, err := renderTemplate(`{{.DatabsePass}}`, data)
+	assert.ErrorContains(t, err, "DatabsePass", "typos in secret names fail the render")
+
+	_, err = renderTemplate(`{{.Custom.MISSING}}`, data)
+	assert.ErrorContains(t, err, "MISSING", "missing custom secrets fail the render")
+
+	_, err = renderTemplate(`{{required "APP_URL must be set" .Custom.EMPTY}}`, &projectSecrets{Custom: map[string]string{"EMPTY": ""}})
+	assert.ErrorContains(t, err, "APP_URL must be set", "required fails on empty values")
+
+	_, err = renderTemplate(`{{"not base64" | b64dec}}`, data)
+	assert.ErrorContains(t, err, "b64dec", "invalid base64 fails the render")
+}
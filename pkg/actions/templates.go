@@ -7,36 +7,88 @@ import (
 	"path"
 	"strings"
 	"text/template"
+	"time"
 
 	"github.com/zulubit/mole/pkg/consts"
 )
 
-// TransformCompose generates "mole-compose-ready.yaml" by transforming "mole-compose.yaml"
-// using secrets from the project's secrets file.
+// TransformCompose generates "mole-compose-ready.yaml" by transforming "mole-compose.yaml",
+// or the compose file declared in mole.yaml, using secrets from the project's secrets file.
 func TransformCompose(projectNOI string) error {
 	p, err := FindProject(projectNOI)
 	if err != nil {
 		return err
 	}
 
-	sourcePath := path.Join(consts.GetBasePath(), "projects", p.Name, "mole-compose.yaml")
+	m, err := readManifest(p)
+	if err != nil {
+		return err
+	}
+
+	sourcePath := path.Join(consts.GetBasePath(), "projects", p.Name, m.composeFile())
 	destPath := path.Join(consts.GetBasePath(), "projects", p.Name, "mole-compose-ready.yaml")
 
 	return injectSecrets(sourcePath, destPath, p.Name)
 }
 
-// TransformDeploy generates "mole-ready.sh" by transforming "mole.sh"
-// using secrets from the project's secrets file.
+// deployHook is an optional script of a project run at a stage of the deployment lifecycle.
+type deployHook struct {
+	Stage    string
+	Template string
+	Ready    string
+}
+
+var (
+	preDeployHook     = deployHook{Stage: "pre", Template: "mole.pre.sh", Ready: "mole-pre-ready.sh"}
+	postDeployHook    = deployHook{Stage: "post", Template: "mole.post.sh", Ready: "mole-post-ready.sh"}
+	failureDeployHook = deployHook{Stage: "failure", Template: "mole.failure.sh", Ready: "mole-failure-ready.sh"}
+)
+
+var deployHooks = []deployHook{preDeployHook, postDeployHook, failureDeployHook}
+
+// TransformDeploy generates "mole-ready.sh" by transforming "mole.sh", or the deployment script
+// declared in mole.yaml, using secrets from the project's secrets file.
+// The optional lifecycle hooks "mole.pre.sh", "mole.post.sh" and "mole.failure.sh" are transformed the same way.
 func TransformDeploy(projectNOI string) error {
 	p, err := FindProject(projectNOI)
 	if err != nil {
 		return err
 	}
 
-	sourcePath := path.Join(consts.GetBasePath(), "projects", p.Name, "mole.sh")
+	m, err := readManifest(p)
+	if err != nil {
+		return err
+	}
+
+	sourcePath := path.Join(consts.GetBasePath(), "projects", p.Name, m.deployScript())
 	destPath := path.Join(consts.GetBasePath(), "projects", p.Name, "mole-ready.sh")
 
-	return injectSecrets(sourcePath, destPath, p.Name)
+	if err := injectSecrets(sourcePath, destPath, p.Name); err != nil {
+		return err
+	}
+
+	for _, hook := range deployHooks {
+		sourcePath := path.Join(consts.GetBasePath(), "projects", p.Name, hook.Template)
+		destPath := path.Join(consts.GetBasePath(), "projects", p.Name, hook.Ready)
+
+		if _, err := os.Stat(sourcePath); os.IsNotExist(err) {
+			// the hook was removed from the project, make sure a stale transformed copy does not run
+			os.Remove(destPath)
+			continue
+		}
+
+		if err := injectSecrets(sourcePath, destPath, p.Name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// hookExists reports whether the project has a transformed script for the hook.
+func hookExists(p Project, hook deployHook) bool {
+	_, err := os.Stat(path.Join(consts.GetBasePath(), "projects", p.Name, hook.Ready))
+	return err == nil
 }
 
 // injectSecrets reads the secrets JSON file and injects its values into a template.
@@ -68,9 +120,11 @@ func injectSecrets(sourcePath, destPath, projectName string) error {
 	return nil
 }
 
-// renderTemplate renders a Go template with secrets
+// renderTemplate renders a Go template with secrets and the functions of templateFuncs.
+// Custom secrets are available as {{.Custom.KEY}} and through the secret function, {{secret "KEY"}}.
+// Missing keys fail the render instead of rendering "<no value>".
 func renderTemplate(templateText string, data *projectSecrets) (string, error) {
-	tmpl, err := template.New("unit").Parse(templateText)
+	tmpl, err := template.New("unit").Funcs(templateFuncs(data)).Option("missingkey=error").Parse(templateText)
 	if err != nil {
 		return "", fmt.Errorf("failed to parse template: %v", err)
 	}
@@ -84,13 +138,11 @@ func renderTemplate(templateText string, data *projectSecrets) (string, error) {
 	return renderedContent.String(), nil
 }
 
-// readProjectSecrets reads and unmarshals the secrets JSON for a given project
+// readProjectSecrets reads, decrypts and unmarshals the secrets JSON for a given project
 func readProjectSecrets(projectName string) (*projectSecrets, error) {
-	secretsPath := path.Join(consts.GetBasePath(), "secrets", projectName+".json")
-
-	data, err := os.ReadFile(secretsPath)
+	data, err := readSecretsFile(projectName)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read secrets file: %v", err)
+		return nil, err
 	}
 
 	var secrets projectSecrets
@@ -99,5 +151,7 @@ func readProjectSecrets(projectName string) (*projectSecrets, error) {
 		return nil, fmt.Errorf("failed to unmarshal secrets JSON: %v", err)
 	}
 
+	secrets.dropExpiredPrevious(time.Now())
+
 	return &secrets, nil
 }
@@ -12,7 +12,7 @@ import (
 func TestTransformTemplates(t *testing.T) {
 	consts.Testing = true
 
-	tmp := os.TempDir()
+	tmp := t.TempDir()
 	consts.BasePath = tmp
 	defer os.RemoveAll(tmp)
 
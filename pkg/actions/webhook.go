@@ -0,0 +1,238 @@
+package actions
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/zulubit/mole/pkg/helpers"
+)
+
+// maxWebhookBody limits the size of accepted webhook payloads.
+const maxWebhookBody = 5 << 20
+
+// pushEvent holds the fields of a push payload mole cares about.
+// GitHub, GitLab and Gitea all send the pushed ref and the new head commit under these names.
+// GitHub and Gitea mark the push deleting a branch with deleted, all of them send a zero commit as its new head.
+type pushEvent struct {
+	Ref     string `json:"ref"`
+	After   string `json:"after"`
+	Deleted bool   `json:"deleted"`
+}
+
+// deletesBranch reports whether the push deleted the branch instead of pushing commits to it.
+func (e pushEvent) deletesBranch() bool {
+	return e.Deleted || (e.After != "" && strings.Trim(e.After, "0") == "")
+}
+
+// webhookDeploy starts the deployment of a project on behalf of a webhook.
+var webhookDeploy = func(projectName, provider string) (DeployResult, error) {
+	return RunDeployment(projectName, DeployOptions{TriggeredBy: "webhook (" + provider + ")"})
+}
+
+// FindOrCreateWebhookSecret returns the secret used to verify push webhooks of a project,
+// generating it for projects created before webhooks were supported.
+func FindOrCreateWebhookSecret(projectNOI string) (string, error) {
+	p, err := FindProject(projectNOI)
+	if err != nil {
+		return "", err
+	}
+
+	secrets, err := readProjectSecrets(p.Name)
+	if err != nil {
+		return "", err
+	}
+
+	if secrets.WebhookSecret != "" {
+		return secrets.WebhookSecret, nil
+	}
+
+	var webhookSecret string
+	err = updateProjectSecrets(p.Name, func(s *projectSecrets) error {
+		if s.WebhookSecret == "" {
+			s.WebhookSecret = helpers.GenerateRandomKey(32)
+		}
+		webhookSecret = s.WebhookSecret
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to save webhook secret: %w", err)
+	}
+
+	return webhookSecret, nil
+}
+
+// ServeWebhooks listens on addr and deploys projects when their branch receives a push.
+// Only loopback addresses are accepted, the listener is meant to be exposed through Caddy.
+func ServeWebhooks(addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid listen address %s: %w", addr, err)
+	}
+
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return fmt.Errorf("refusing to listen on %s, webhooks must be bound to localhost and exposed through Caddy", addr)
+	}
+
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           WebhookHandler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	log.Printf("Listening for webhooks on http://%s/hooks/<project>", addr)
+
+	return server.ListenAndServe()
+}
+
+// WebhookHandler returns the HTTP handler receiving push webhooks on /hooks/<project name/id>.
+func WebhookHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /hooks/{project}", handleWebhook)
+	return mux
+}
+
+// handleWebhook verifies a push webhook and starts a deployment when the pushed branch is the project's branch.
+// Unknown projects, projects without a webhook secret and bad signatures get the same answer,
+// so the webhook server does not tell which projects exist.
+func handleWebhook(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxWebhookBody))
+	if err != nil {
+		http.Error(w, "failed to read payload", http.StatusBadRequest)
+		return
+	}
+
+	name := r.PathValue("project")
+	p, err := FindProject(name)
+	var secrets *projectSecrets
+	if err == nil {
+		secrets, err = readProjectSecrets(p.Name)
+	}
+	if err == nil && secrets.WebhookSecret == "" {
+		err = errors.New("webhooks are not enabled for the project")
+	}
+
+	provider := ""
+	if err == nil {
+		provider, err = verifyWebhook(r.Header, body, secrets.WebhookSecret)
+		if err != nil && secrets.Previous["WebhookSecret"] != "" {
+			// the git provider may not have been updated since the secret was rotated
+			provider, err = verifyWebhook(r.Header, body, secrets.Previous["WebhookSecret"])
+		}
+	}
+	if err != nil {
+		log.Printf("Rejected webhook for %s: %v", name, err)
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	event := webhookEvent(r.Header)
+	if event == "ping" {
+		fmt.Fprintln(w, "pong")
+		return
+	}
+
+	if event != "push" && event != "push hook" {
+		w.WriteHeader(http.StatusAccepted)
+		fmt.Fprintf(w, "ignored %s event\n", event)
+		return
+	}
+
+	var push pushEvent
+	if err := json.Unmarshal(body, &push); err != nil {
+		http.Error(w, "invalid push payload", http.StatusBadRequest)
+		return
+	}
+
+	if push.deletesBranch() {
+		w.WriteHeader(http.StatusAccepted)
+		fmt.Fprintf(w, "ignored deletion of %s\n", push.Ref)
+		return
+	}
+
+	if push.Ref != "refs/heads/"+p.Branch {
+		w.WriteHeader(http.StatusAccepted)
+		fmt.Fprintf(w, "ignored push to %s, %s deploys %s\n", push.Ref, p.Name, p.Branch)
+		return
+	}
+
+	log.Printf("Push to %s (%s) received from %s, deploying %s", p.Branch, shortCommit(push.After), provider, p.Name)
+
+	go func() {
+		res, err := webhookDeploy(p.Name, provider)
+		if err != nil {
+			log.Printf("Deployment of %s failed: %v", p.Name, err)
+			return
+		}
+		log.Print(res.Summary())
+	}()
+
+	w.WriteHeader(http.StatusAccepted)
+	fmt.Fprintf(w, "deployment of %s started\n", p.Name)
+}
+
+// verifyWebhook checks the payload against the project's webhook secret and returns the sending provider.
+// GitHub and Gitea sign the payload with HMAC-SHA256, GitLab sends the secret itself as a token.
+func verifyWebhook(header http.Header, body []byte, secret string) (string, error) {
+	if sig := header.Get("X-Hub-Signature-256"); sig != "" {
+		return providerName(header), verifyHMAC(strings.TrimPrefix(sig, "sha256="), body, secret)
+	}
+
+	if sig := header.Get("X-Gitea-Signature"); sig != "" {
+		return "gitea", verifyHMAC(sig, body, secret)
+	}
+
+	if token := header.Get("X-Gitlab-Token"); token != "" {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(secret)) != 1 {
+			return "gitlab", errors.New("token does not match")
+		}
+		return "gitlab", nil
+	}
+
+	return "", errors.New("request is not signed")
+}
+
+// verifyHMAC compares a hex encoded HMAC-SHA256 signature against the one computed for body.
+func verifyHMAC(signature string, body []byte, secret string) error {
+	got, err := hex.DecodeString(signature)
+	if err != nil {
+		return errors.New("signature is not hex encoded")
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+
+	if !hmac.Equal(got, mac.Sum(nil)) {
+		return errors.New("signature does not match")
+	}
+
+	return nil
+}
+
+// providerName guesses the provider of a payload signed with X-Hub-Signature-256, which Gitea sends as well.
+func providerName(header http.Header) string {
+	if header.Get("X-Gitea-Event") != "" {
+		return "gitea"
+	}
+	return "github"
+}
+
+// webhookEvent returns the lowercased event name sent by any of the supported providers.
+func webhookEvent(header http.Header) string {
+	for _, h := range []string{"X-GitHub-Event", "X-Gitea-Event", "X-Gitlab-Event"} {
+		if event := header.Get(h); event != "" {
+			return strings.ToLower(event)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,132 @@
+package actions
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zulubit/mole/pkg/consts"
+)
+
+func signPayload(body, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestWebhookHandler(t *testing.T) {
+	consts.Testing = true
+
+	tmp := t.TempDir()
+	consts.BasePath = tmp
+
+	np := Project{
+		Name:   "test-project",
+		Branch: "main",
+	}
+	err := addProject(np)
+	assert.Nil(t, err, "project should be added")
+
+	err = createProjectSecretsJson(np)
+	assert.Nil(t, err, "project secrets should be created")
+
+	secret, err := FindOrCreateWebhookSecret(np.Name)
+	assert.Nil(t, err, "webhook secret can be read")
+	assert.Len(t, secret, 32, "webhook secret is generated with the project")
+
+	deployed := make(chan string, 10)
+	originalDeploy := webhookDeploy
+	defer func() { webhookDeploy = originalDeploy }()
+	webhookDeploy = func(projectName, provider string) (DeployResult, error) {
+		deployed <- projectName + ":" + provider
+		return DeployResult{ProjectName: projectName}, nil
+	}
+
+	handler := WebhookHandler()
+	send := func(headers map[string]string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/hooks/"+np.Name, strings.NewReader(body))
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	push := `{"ref":"refs/heads/main","after":"0123456789abcdef"}`
+
+	rec := send(map[string]string{"X-GitHub-Event": "push", "X-Hub-Signature-256": "sha256=" + signPayload(push, "wrong")}, push)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code, "wrong signature is rejected")
+
+	rec = send(map[string]string{"X-GitHub-Event": "push"}, push)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code, "unsigned payload is rejected")
+
+	rec = send(map[string]string{"X-GitHub-Event": "ping", "X-Hub-Signature-256": "sha256=" + signPayload("{}", secret)}, "{}")
+	assert.Equal(t, http.StatusOK, rec.Code, "ping is answered")
+
+	other := `{"ref":"refs/heads/feature"}`
+	rec = send(map[string]string{"X-GitHub-Event": "push", "X-Hub-Signature-256": "sha256=" + signPayload(other, secret)}, other)
+	assert.Equal(t, http.StatusAccepted, rec.Code, "push to another branch is accepted")
+	assert.Contains(t, rec.Body.String(), "ignored push", "push to another branch is ignored")
+
+	for _, deletion := range []string{
+		`{"ref":"refs/heads/main","after":"0000000000000000000000000000000000000000","deleted":true}`,
+		`{"ref":"refs/heads/main","after":"0000000000000000000000000000000000000000"}`,
+	} {
+		rec = send(map[string]string{"X-GitHub-Event": "push", "X-Hub-Signature-256": "sha256=" + signPayload(deletion, secret)}, deletion)
+		assert.Equal(t, http.StatusAccepted, rec.Code, "deletion of the branch is accepted")
+		assert.Contains(t, rec.Body.String(), "ignored deletion", "deletion of the branch does not deploy")
+	}
+
+	rec = send(map[string]string{"X-GitHub-Event": "push", "X-Hub-Signature-256": "sha256=" + signPayload(push, secret)}, push)
+	assert.Equal(t, http.StatusAccepted, rec.Code, "signed github push is accepted")
+	assert.Equal(t, "test-project:github", <-deployed, "github push triggers a deployment")
+
+	rec = send(map[string]string{"X-Gitea-Event": "push", "X-Gitea-Signature": signPayload(push, secret)}, push)
+	assert.Equal(t, http.StatusAccepted, rec.Code, "signed gitea push is accepted")
+	assert.Equal(t, "test-project:gitea", <-deployed, "gitea push triggers a deployment")
+
+	rec = send(map[string]string{"X-Gitlab-Event": "Push Hook", "X-Gitlab-Token": "wrong"}, push)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code, "wrong gitlab token is rejected")
+
+	rec = send(map[string]string{"X-Gitlab-Event": "Push Hook", "X-Gitlab-Token": secret}, push)
+	assert.Equal(t, http.StatusAccepted, rec.Code, "gitlab push with token is accepted")
+	assert.Equal(t, "test-project:gitlab", <-deployed, "gitlab push triggers a deployment")
+
+	_, err = RotateProjectSecret(np.Name, "WebhookSecret", time.Hour)
+	assert.Nil(t, err, "webhook secret rotated")
+	rotated, _ := FindOrCreateWebhookSecret(np.Name)
+
+	rec = send(map[string]string{"X-Gitlab-Event": "Push Hook", "X-Gitlab-Token": rotated}, push)
+	assert.Equal(t, http.StatusAccepted, rec.Code, "push with the rotated secret is accepted")
+	assert.Equal(t, "test-project:gitlab", <-deployed, "push with the rotated secret triggers a deployment")
+
+	rec = send(map[string]string{"X-Gitlab-Event": "Push Hook", "X-Gitlab-Token": secret}, push)
+	assert.Equal(t, http.StatusAccepted, rec.Code, "push with the previous secret is accepted during the grace period")
+	assert.Equal(t, "test-project:gitlab", <-deployed, "push with the previous secret triggers a deployment")
+
+	_, err = RotateProjectSecret(np.Name, "WebhookSecret", 0)
+	assert.Nil(t, err, "webhook secret rotated without grace period")
+	rec = send(map[string]string{"X-Gitlab-Event": "Push Hook", "X-Gitlab-Token": rotated}, push)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code, "previous secret is rejected without grace period")
+
+	req := httptest.NewRequest(http.MethodPost, "/hooks/missing", strings.NewReader(push))
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code, "unknown project is rejected like a bad signature")
+	assert.Equal(t, "invalid signature\n", rec.Body.String(), "unknown projects are not revealed")
+}
+
+func TestServeWebhooksRequiresLoopback(t *testing.T) {
+	err := ServeWebhooks("0.0.0.0:7890")
+	assert.ErrorContains(t, err, "must be bound to localhost", "public addresses are refused")
+
+	err = ServeWebhooks("7890")
+	assert.ErrorContains(t, err, "invalid listen address", "malformed addresses are refused")
+}
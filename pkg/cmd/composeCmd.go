@@ -0,0 +1,175 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/zulubit/mole/pkg/actions"
+)
+
+func init() {
+	RootCmd.AddCommand(composeRootCmd)
+
+	composeRootCmd.AddCommand(composeUpCmd)
+	composeRootCmd.AddCommand(composeDownCmd)
+	composeRootCmd.AddCommand(composeRestartCmd)
+	composeRootCmd.AddCommand(composePsCmd)
+	composeRootCmd.AddCommand(composePullCmd)
+
+	composeLogsCmd.Flags().BoolVarP(&followFlag, "follow", "f", false, "Follow the logs until interrupted")
+	composeLogsCmd.Flags().StringVarP(&composeTailFlag, "tail", "n", "", "Number of lines to show from the end of the logs of each container")
+	composeRootCmd.AddCommand(composeLogsCmd)
+
+	// flags after the service belong to the command run in the container
+	composeExecCmd.Flags().SetInterspersed(false)
+	composeRootCmd.AddCommand(composeExecCmd)
+}
+
+var composeRootCmd = &cobra.Command{
+	Use:   "compose",
+	Short: "Manage the docker compose stack of a project",
+	Long: `The "compose" command group runs docker compose on the stack of a project.
+
+Every command operates on mole-compose-ready.yaml, which is rendered again
+first when mole-compose.yaml was changed since it was last rendered. The
+stack runs under the project name as compose project name, blue/green
+projects under the name of their live slot, so the commands always reach
+the stack mole deployed, whatever directory they are run from.`,
+}
+
+// serviceArg returns the optional service argument of a compose command.
+func serviceArg(args []string) string {
+	if len(args) > 1 {
+		return args[1]
+	}
+	return ""
+}
+
+var composeUpCmd = &cobra.Command{
+	Use:   "up [name/id] [service]",
+	Short: "Create and start the stack of a project",
+	Long: `Creates and starts the containers of a project, or of one of its
+services, in the background and prints their status.`,
+	Args: cobra.RangeArgs(1, 2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := actions.ComposeUp(args[0], serviceArg(args), progressOutput()); err != nil {
+			return err
+		}
+
+		return printComposeServices(args[0], serviceArg(args))
+	},
+}
+
+var composeDownCmd = &cobra.Command{
+	Use:   "down [name/id] [service]",
+	Short: "Stop and remove the stack of a project",
+	Long: `Stops and removes the containers and networks of a project and
+prints the containers left. With a service, only its containers are
+stopped and removed.`,
+	Args: cobra.RangeArgs(1, 2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := actions.ComposeDown(args[0], serviceArg(args), progressOutput()); err != nil {
+			return err
+		}
+
+		return printComposeServices(args[0], "")
+	},
+}
+
+var composeRestartCmd = &cobra.Command{
+	Use:   "restart [name/id] [service]",
+	Short: "Restart the containers of a project",
+	Long: `Restarts the containers of a project, or of one of its services,
+and prints their status. Changes to the compose file are not applied,
+use "compose up" for that.`,
+	Args: cobra.RangeArgs(1, 2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := actions.ComposeRestart(args[0], serviceArg(args), progressOutput()); err != nil {
+			return err
+		}
+
+		return printComposeServices(args[0], serviceArg(args))
+	},
+}
+
+var composePullCmd = &cobra.Command{
+	Use:   "pull [name/id] [service]",
+	Short: "Pull the images of a project",
+	Long: `Pulls the images of a project, or of one of its services, and prints
+the status of its containers. Run "compose up" to start the new images.`,
+	Args: cobra.RangeArgs(1, 2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := actions.ComposePull(args[0], serviceArg(args), progressOutput()); err != nil {
+			return err
+		}
+
+		return printComposeServices(args[0], serviceArg(args))
+	},
+}
+
+var composePsCmd = &cobra.Command{
+	Use:   "ps [name/id] [service]",
+	Short: "List the containers of a project",
+	Long: `Lists the containers of a project, or of one of its services,
+stopped ones included, with their state, health and published ports.`,
+	Args: cobra.RangeArgs(1, 2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return printComposeServices(args[0], serviceArg(args))
+	},
+}
+
+var composeLogsCmd = &cobra.Command{
+	Use:   "logs [name/id] [service]",
+	Short: "Print the logs of the containers of a project",
+	Args:  cobra.RangeArgs(1, 2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return actions.ComposeLogs(args[0], serviceArg(args), followFlag, composeTailFlag, os.Stdout)
+	},
+}
+
+var composeExecCmd = &cobra.Command{
+	Use:   "exec [name/id] [service] [command]...",
+	Short: "Run a command in a running container of a project",
+	Long: `Runs a command in the running container of a service of a project.
+A terminal is attached when mole is run in one, so interactive commands
+such as shells work:
+
+  mole compose exec my-project app sh
+  mole compose exec my-project app php artisan migrate --force`,
+	Args: cobra.MinimumNArgs(3),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return actions.ComposeExec(args[0], args[1], args[2:], stdinIsTerminal(), actions.ComposeIO{
+			Stdin:  os.Stdin,
+			Stdout: os.Stdout,
+			Stderr: os.Stderr,
+		})
+	},
+}
+
+// stdinIsTerminal reports whether mole reads from a terminal rather than a pipe or file.
+func stdinIsTerminal() bool {
+	info, err := os.Stdin.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// printComposeServices prints the status of the containers of a project.
+func printComposeServices(projectNOI, service string) error {
+	services, err := actions.ComposePs(projectNOI, service)
+	if err != nil {
+		return err
+	}
+
+	return printResult(services, func() string {
+		if len(services) == 0 {
+			return "No containers"
+		}
+
+		lines := []string{fmt.Sprintf("%-20s %-24s %s", "SERVICE", "STATE", "STATUS")}
+		for _, s := range services {
+			lines = append(lines, s.Stringify())
+		}
+		return strings.Join(lines, "\n")
+	})
+}
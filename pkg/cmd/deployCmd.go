@@ -2,6 +2,7 @@ package cmd
 
 import (
 	"fmt"
+	"os"
 	"strings"
 
 	"github.com/spf13/cobra"
@@ -11,22 +12,37 @@ import (
 func init() {
 	RootCmd.AddCommand(deployCmd)
 	deployCmd.Flags().BoolVar(&deployDown, "down", false, "Try to run docker compose down on mole-compose-ready.yaml or fail.")
+	deployCmd.Flags().StringVar(&deployRef, "ref", "", "Deploy a specific branch, tag or commit instead of the project branch")
+	deployCmd.Flags().BoolVar(&deployNoPull, "no-pull", false, "Deploy the working tree as it is, without fetching new commits")
+
+	deployCmd.AddCommand(deployHistoryCmd)
+	deployCmd.AddCommand(deployRollbackCmd)
+	deployCmd.AddCommand(deployStatusCmd)
+
+	deployCancelCmd.Flags().BoolVarP(&forceFlag, "force", "f", false, "Kill the deployment scripts instead of asking them to stop")
+	deployCmd.AddCommand(deployCancelCmd)
+
+	deployLogsCmd.Flags().BoolVarP(&followFlag, "follow", "f", false, "Follow the output of a running deployment until it finishes")
+	deployCmd.AddCommand(deployLogsCmd)
 }
 
 var deployCmd = &cobra.Command{
 	Use:   "deploy [project name/id]",
 	Short: "Deploy triggers project deployment",
 	Long: `Deploy triggers project deployment.
-This will transform your mole.sh and run it.`,
+Before deploying, the project is reset to the latest commit of its branch, 
+or to the ref given with --ref. Then your mole.sh is transformed and run.
+The output is streamed as it runs and written to the deployment log.
+Every deployment is recorded in the project's deployment history.
+
+Only one deployment of a project runs at a time. A second deployment waits 
+for the running one to finish, further deployments requested while one is 
+waiting are coalesced into it.`,
 	Args: cobra.MinimumNArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		if !deployDown {
-			succ, err := actions.RunDeployment(strings.Join(args, ""))
-			if err != nil {
-				return err
-			}
-
-			fmt.Println(succ)
+			res, err := actions.RunDeployment(strings.Join(args, ""), actions.DeployOptions{Ref: deployRef, SkipPull: deployNoPull, Output: progressOutput()})
+			return printDeployResult(res, err)
 		} else if deployDown {
 			succ, err := actions.RundDeplyDown(strings.Join(args, ""))
 			if err != nil {
@@ -38,3 +54,115 @@ This will transform your mole.sh and run it.`,
 		return nil
 	},
 }
+
+var deployHistoryCmd = &cobra.Command{
+	Use:   "history [project name/id]",
+	Short: "List past deployments of a project",
+	Long: `Lists the deployments recorded for a project, newest first.
+Each entry shows the deployment ID, the deployed commit and branch, 
+when it ran, who triggered it, its exit status and the path to its log.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		deployments, err := actions.ListDeployments(args[0])
+		if err != nil {
+			return err
+		}
+
+		return printResult(deployments, func() string {
+			if len(deployments) == 0 {
+				return "No deployments recorded for " + args[0]
+			}
+
+			var b strings.Builder
+			for _, d := range deployments {
+				b.WriteString(d.Stringify() + "\n")
+			}
+			return strings.TrimSuffix(b.String(), "\n")
+		})
+	},
+}
+
+var deployRollbackCmd = &cobra.Command{
+	Use:   "rollback [project name/id] [deployment id]",
+	Short: "Redeploy the commit of a previous deployment",
+	Long: `Rollback checks out the commit recorded by a previous deployment 
+and runs the transformed mole.sh again. 
+Use "mole deploy history" to find the deployment ID to roll back to.`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		res, err := actions.RollbackDeployment(args[0], args[1], progressOutput())
+		return printDeployResult(res, err)
+	},
+}
+
+var deployStatusCmd = &cobra.Command{
+	Use:   "status [project name/id]",
+	Short: "Show running and queued deployments",
+	Long: `Shows whether a deployment of the project is running or waiting in the queue. 
+Without a project, the deployment status of every project is shown.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		states, err := actions.DeploymentStatus(strings.Join(args, ""))
+		if err != nil {
+			return err
+		}
+
+		return printResult(states, func() string {
+			var b strings.Builder
+			for _, st := range states {
+				b.WriteString(st.Stringify() + "\n")
+			}
+			return strings.TrimSuffix(b.String(), "\n")
+		})
+	},
+}
+
+var deployCancelCmd = &cobra.Command{
+	Use:   "cancel [project name/id]",
+	Short: "Cancel the running deployment of a project",
+	Long: `Cancels the running deployment of a project by sending SIGTERM to the 
+process group of its deployment script. Use --force to send SIGKILL instead.
+The deployment is recorded with the status "cancelled".`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		err := actions.CancelDeployment(args[0], forceFlag)
+		if err != nil {
+			return err
+		}
+
+		fmt.Println("Deployment of " + args[0] + " is being cancelled")
+		return nil
+	},
+}
+
+var deployLogsCmd = &cobra.Command{
+	Use:   "logs [project name/id]",
+	Short: "Show the log of the running or latest deployment",
+	Long: `Shows the log of the project's running deployment, or of its latest 
+deployment when none is running. 
+
+Use --follow to attach to a running deployment, for example from another 
+SSH session, and stream its output until it finishes.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return actions.StreamDeploymentLog(args[0], followFlag, os.Stdout)
+	},
+}
+
+// printDeployResult prints the outcome of a deployment, also when it failed.
+// With structured output, the error is reported through the exit code and the result is printed instead.
+func printDeployResult(res actions.DeployResult, err error) error {
+	if res.Deployment == nil && !res.Queued {
+		return err
+	}
+
+	if perr := printResult(res, res.Summary); perr != nil {
+		return perr
+	}
+
+	if err != nil && structuredOutput() {
+		return silentError{err}
+	}
+
+	return err
+}
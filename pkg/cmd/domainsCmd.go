@@ -12,24 +12,52 @@ func init() {
 	RootCmd.AddCommand(domainsRootCmd)
 
 	domainsRootCmd.AddCommand(listTakenPortsCmd)
+	reloadCaddyCmd.Flags().BoolVar(&reloadDryRunFlag, "dry-run", false, "Validate the configuration and print the diff against the running one without applying it")
 	domainsRootCmd.AddCommand(reloadCaddyCmd)
 	domainsRootCmd.AddCommand(setupCaddyCmd)
 	domainsRootCmd.AddCommand(deleteCaddyCmd)
+	domainsRootCmd.AddCommand(listDomainsCmd)
+	domainsRootCmd.AddCommand(removeDomainCmd)
+
+	addRouteCmd.Flags().StringVarP(&routePortFlag, "port", "p", "", "Port to forward to: PortApp, PortTwo, PortThree or a port number (default PortApp)")
+	addRouteCmd.Flags().StringVarP(&locationFlag, "location", "l", "", "Directory of the project to serve the path from instead")
+	addRouteCmd.MarkFlagsMutuallyExclusive("port", "location")
+	routesCmd.AddCommand(addRouteCmd)
+	routesCmd.AddCommand(removeRouteCmd)
+	domainsRootCmd.AddCommand(routesCmd)
 
 	addProxyCaddyCmd.Flags().StringVarP(&domainFlag, "domain", "d", "", "Domain *required")
 	addProxyCaddyCmd.MarkFlagRequired("domain")
 	addProxyCaddyCmd.Flags().IntVarP(&portFlag, "port", "p", 0, "Port *required")
 	addProxyCaddyCmd.MarkFlagRequired("port")
+	addDomainEntryFlags(addProxyCaddyCmd)
 	addCaddyCmd.AddCommand(addProxyCaddyCmd)
 
 	addStaticCaddyCmd.Flags().StringVarP(&domainFlag, "domain", "d", "", "Domain *required")
 	addStaticCaddyCmd.MarkFlagRequired("domain")
 	addStaticCaddyCmd.Flags().StringVarP(&locationFlag, "location", "l", "", "Location adds to the default path: /home/mole/projects/#project#/#provided location#")
+	addDomainEntryFlags(addStaticCaddyCmd)
 	addCaddyCmd.AddCommand(addStaticCaddyCmd)
 
+	addRedirectCaddyCmd.Flags().StringVarP(&domainFlag, "domain", "d", "", "Domain *required")
+	addRedirectCaddyCmd.MarkFlagRequired("domain")
+	addRedirectCaddyCmd.Flags().StringVar(&redirectTargetFlag, "to", "", "URL to redirect to, without a path the path of the request is kept *required")
+	addRedirectCaddyCmd.MarkFlagRequired("to")
+	addDomainEntryFlags(addRedirectCaddyCmd)
+	addCaddyCmd.AddCommand(addRedirectCaddyCmd)
+
 	domainsRootCmd.AddCommand(addCaddyCmd)
 }
 
+// addDomainEntryFlags adds the flags shared by the commands adding a domain.
+func addDomainEntryFlags(cmd *cobra.Command) {
+	cmd.Flags().StringSliceVar(&domainAliasFlag, "alias", nil, "Other domain served the same way, can be repeated")
+	cmd.Flags().StringSliceVar(&domainRedirectFlag, "redirect", nil, "Domain redirected to this one next to www.<domain>, can be repeated")
+	cmd.Flags().BoolVar(&domainPrimaryFlag, "primary", false, "Make this the primary domain of the project")
+	cmd.Flags().StringVar(&domainWWWFlag, "www", "", "Policy for www.<domain>: apex redirects it to the domain, www the domain to it, none leaves it out (default apex)")
+	cmd.Flags().IntVar(&redirectStatusFlag, "redirect-status", 0, "Status code of the redirects: 301, 302, 303, 307 or 308 (default 302)")
+}
+
 var domainsRootCmd = &cobra.Command{
 	Use:   "domains",
 	Short: "Manage Caddy reverse proxy configurations",
@@ -63,49 +91,88 @@ var listTakenPortsCmd = &cobra.Command{
 	Long: `This command lists all active ports currently in use, 
 	retrieving the information using the "ss" command to display essential details.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		p, err := actions.PortReport()
+		p, err := actions.ListActivePorts()
 		if err != nil {
 			return err
 		}
 
-		fmt.Println(p)
-		return nil
+		return printResult(p, func() string {
+			return actions.PortReport(p)
+		})
 	},
 }
 
 var reloadCaddyCmd = &cobra.Command{
 	Use:   "reload",
 	Short: "Reload the Caddy service configuration",
-	Long: `Reload collects the main Caddyfile and all partial configurations, 
-	merges them, and sends them to the Caddy API.`,
+	Long: `Reload rebuilds the whole Caddy configuration from the main Caddyfile and
+the partials in the domains directory, which hold the rendered routes of every
+project, and loads it through the Caddy API.
+
+Every partial is validated with Caddy first. When one fails, or the partials
+conflict once merged, nothing is applied and the offending partial is named,
+so the running sites stay up. The diff against the running configuration is
+printed, with --dry-run without applying it:
+
+  mole domains reload --dry-run`,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		err := actions.ReloadCaddy()
+		reload, err := actions.ReloadCaddy(reloadDryRunFlag)
 		if err != nil {
 			return err
 		}
 
-		fmt.Println("Caddy configuration reloaded successfully.")
-		return nil
+		return printResult(reload, func() string {
+			if !reload.Changed {
+				return "Caddy configuration is up to date."
+			}
+			if reload.DryRun {
+				return reload.Diff + "\nDry run, the configuration is valid and was not applied."
+			}
+			return reload.Diff + "\nCaddy configuration reloaded successfully."
+		})
 	},
 }
 
 var addCaddyCmd = &cobra.Command{
 	Use:   "add",
 	Short: "Add a new domain to the Caddy configuration",
-	Long: `This command supports adding domains to the Caddy configuration, 
-	including reverse proxies and static file servers with automatic TLS configuration.`,
+	Long: `This command supports adding domains to the Caddy configuration,
+	including reverse proxies and static file servers with automatic TLS configuration.
+
+A project can have any number of domains, all of them are rendered into its
+partial. Adding a domain the project already has replaces it. The first
+domain of a project is its primary one, unless another one is added with
+--primary:
+
+  mole domains add proxy my-project -d example.com -p 0 --alias example.org --redirect old-example.com
+  mole domains add static my-project -d docs.example.com -l public
+
+Every domain redirects www.<domain> to itself, --www www redirects the domain
+to www.<domain> instead and --www none leaves it out, e.g. for subdomains:
+
+  mole domains add proxy my-project -d api.example.com -p 8081 --www none`,
 }
 
 var addProxyCaddyCmd = &cobra.Command{
 	Use:   "proxy [project name/id]",
 	Short: "Add a reverse proxy for a domain",
-	Long: `This command creates a reverse proxy configuration in Caddy for the specified project.
+	Long: `This command adds a reverse proxy domain to the specified project and applies
+	the route of the project in Caddy, leaving the routes of other projects alone.
 	if an empty on 0 port flag is set, MOLE_PORT_APP env variable will be used instead.`,
 	Args: cobra.ExactArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		a := strings.Join(args, " ")
 
-		err := actions.AddDomainProxy(a, domainFlag, portFlag)
+		err := actions.AddDomain(a, actions.Domain{
+			Domain:         domainFlag,
+			Kind:           actions.DomainProxy,
+			Port:           portFlag,
+			Primary:        domainPrimaryFlag,
+			Aliases:        domainAliasFlag,
+			Redirects:      domainRedirectFlag,
+			WWW:            domainWWWFlag,
+			RedirectStatus: redirectStatusFlag,
+		})
 		if err != nil {
 			return err
 		}
@@ -118,12 +185,22 @@ var addProxyCaddyCmd = &cobra.Command{
 var addStaticCaddyCmd = &cobra.Command{
 	Use:   "static [project name/id]",
 	Short: "Add a static file server route",
-	Long:  `This command adds a static file server configuration in Caddy for serving files for the specified project.`,
-	Args:  cobra.ExactArgs(1),
+	Long: `This command adds a static file server domain to the specified project and applies
+	the route of the project in Caddy, leaving the routes of other projects alone.`,
+	Args: cobra.ExactArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		a := strings.Join(args, " ")
 
-		err := actions.AddDomainStatic(a, domainFlag, locationFlag)
+		err := actions.AddDomain(a, actions.Domain{
+			Domain:         domainFlag,
+			Kind:           actions.DomainStatic,
+			Location:       locationFlag,
+			Primary:        domainPrimaryFlag,
+			Aliases:        domainAliasFlag,
+			Redirects:      domainRedirectFlag,
+			WWW:            domainWWWFlag,
+			RedirectStatus: redirectStatusFlag,
+		})
 		if err != nil {
 			return err
 		}
@@ -133,11 +210,149 @@ var addStaticCaddyCmd = &cobra.Command{
 	},
 }
 
+var addRedirectCaddyCmd = &cobra.Command{
+	Use:   "redirect [project name/id]",
+	Short: "Add a domain redirecting to another URL",
+	Long: `This command adds a domain to the specified project that redirects to another
+URL, and applies the route of the project in Caddy. The aliases, redirects and
+www.<domain> of the domain redirect to the URL as well:
+
+  mole domains add redirect my-project -d old-example.com --to https://example.com --redirect-status 301
+
+Without a path in the URL, the path of the request is kept.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		a := strings.Join(args, " ")
+
+		err := actions.AddDomain(a, actions.Domain{
+			Domain:         domainFlag,
+			Kind:           actions.DomainRedirect,
+			Target:         redirectTargetFlag,
+			Primary:        domainPrimaryFlag,
+			Aliases:        domainAliasFlag,
+			Redirects:      domainRedirectFlag,
+			WWW:            domainWWWFlag,
+			RedirectStatus: redirectStatusFlag,
+		})
+		if err != nil {
+			return err
+		}
+
+		fmt.Println("Redirect added for: " + a)
+		return nil
+	},
+}
+
+var listDomainsCmd = &cobra.Command{
+	Use:   "list [project name/id]",
+	Short: "List the domains of a project, or of all projects",
+	Long: `Lists the domains of a project with their aliases and redirects, and the
+port or directory they serve. Without a project, the domains of all projects
+are listed.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		project := ""
+		if len(args) == 1 {
+			project = args[0]
+		}
+
+		list, err := actions.ListDomains(project)
+		if err != nil {
+			return err
+		}
+
+		return printResult(list, func() string {
+			if len(list) == 0 {
+				return "No projects"
+			}
+
+			var b strings.Builder
+			for _, pd := range list {
+				b.WriteString(pd.Stringify())
+			}
+			return b.String()
+		})
+	},
+}
+
+var removeDomainCmd = &cobra.Command{
+	Use:   "remove [project name/id] [domain]",
+	Short: "Remove a domain from a project",
+	Long: `Removes a domain from a project and applies the route of the project in Caddy.
+The domain may also be an alias or a redirect of a domain of the project, then
+only that is removed. When the project has no domains left, its partial and
+route are removed.`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := actions.RemoveDomain(args[0], args[1]); err != nil {
+			return err
+		}
+
+		fmt.Println("Domain " + args[1] + " removed from: " + args[0])
+		return nil
+	},
+}
+
+var routesCmd = &cobra.Command{
+	Use:   "routes",
+	Short: "Route paths of a domain to other ports or directories",
+	Long: `Routes send the requests to paths of a domain elsewhere than the domain
+itself, so an app with a backend and static files can be served on one domain:
+
+  mole domains add static my-project -d example.com -l dist
+  mole domains routes add my-project example.com "/api/*" -p PortApp
+  mole domains routes add my-project example.com "/ws/*" -p PortTwo
+  mole domains routes add my-project example.com "/assets/*" -l public
+
+Requests that match no route are handled by the domain. Routes are listed
+with "domains list".`,
+}
+
+var addRouteCmd = &cobra.Command{
+	Use:   "add [project name/id] [domain] [path]",
+	Short: "Add a route to a domain",
+	Long: `Adds a route forwarding a path of a domain to a port, or serving it from a
+directory of the project, and applies the route of the project in Caddy. A
+route with the same path is replaced.
+
+Files are served with their full path, with --location public a request for
+/assets/app.css is served from public/assets/app.css.`,
+	Args: cobra.ExactArgs(3),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		route := actions.Route{Path: args[2], Kind: actions.DomainProxy, Port: routePortFlag}
+		if cmd.Flags().Changed("location") {
+			route = actions.Route{Path: args[2], Kind: actions.DomainStatic, Location: locationFlag}
+		}
+
+		if err := actions.AddDomainRoute(args[0], args[1], route); err != nil {
+			return err
+		}
+
+		fmt.Println("Route " + args[2] + " added to: " + args[1])
+		return nil
+	},
+}
+
+var removeRouteCmd = &cobra.Command{
+	Use:   "remove [project name/id] [domain] [path]",
+	Short: "Remove a route from a domain",
+	Args:  cobra.ExactArgs(3),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := actions.RemoveDomainRoute(args[0], args[1], args[2]); err != nil {
+			return err
+		}
+
+		fmt.Println("Route " + args[2] + " removed from: " + args[1])
+		return nil
+	},
+}
+
 var deleteCaddyCmd = &cobra.Command{
 	Use:   "delete [project name]",
-	Short: "Delete a domain from the Caddy configuration",
-	Long:  `This command attempts to locate and remove the specified project’s domain configuration file from Caddy.`,
-	Args:  cobra.ExactArgs(1),
+	Short: "Delete all domains of a project from the Caddy configuration",
+	Long: `This command attempts to locate and remove the specified project’s domain configuration file,
+	removes all domains of the project and the route of the project from Caddy.`,
+	Args: cobra.ExactArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		a := strings.Join(args, " ")
 
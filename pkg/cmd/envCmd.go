@@ -0,0 +1,236 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/zulubit/mole/pkg/actions"
+)
+
+func init() {
+	RootCmd.AddCommand(envRootCmd)
+
+	envRootCmd.AddCommand(listEnvCmd)
+	envRootCmd.AddCommand(getEnvCmd)
+	envRootCmd.AddCommand(setEnvCmd)
+	envRootCmd.AddCommand(unsetEnvCmd)
+	envRootCmd.AddCommand(importEnvCmd)
+	envRootCmd.AddCommand(exportEnvCmd)
+	envRootCmd.AddCommand(envHistoryCmd)
+	envRootCmd.AddCommand(syncEnvCmd)
+}
+
+var envRootCmd = &cobra.Command{
+	Use:   "env",
+	Short: "Manage the .env file of a project",
+	Long: `The "env" command group reads and edits the .env file of a project
+without opening an editor, so it can be used from scripts and CI.
+
+Edits are written atomically and keep the comments and the order of
+the file. Every change is recorded in the project's .env history,
+which names who changed which variables. Values are not recorded.
+
+Redeploy the project for the changes to take effect.`,
+}
+
+var listEnvCmd = &cobra.Command{
+	Use:   "list [name/id]",
+	Short: "List the variables of a project's .env file",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		vars, err := actions.ListEnv(args[0])
+		if err != nil {
+			return err
+		}
+
+		return printResult(vars, func() string {
+			if len(vars) == 0 {
+				return "No variables set"
+			}
+
+			lines := make([]string, len(vars))
+			for i, v := range vars {
+				lines[i] = v.Key + "=" + v.Value
+			}
+			return strings.Join(lines, "\n")
+		})
+	},
+}
+
+var getEnvCmd = &cobra.Command{
+	Use:   "get [name/id] [key]",
+	Short: "Print the value of a variable of a project's .env file",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		value, err := actions.GetEnv(args[0], args[1])
+		if err != nil {
+			return err
+		}
+
+		return printResult(actions.EnvVar{Key: args[1], Value: value}, func() string { return value })
+	},
+}
+
+var setEnvCmd = &cobra.Command{
+	Use:   "set [name/id] [KEY=value]...",
+	Short: "Set variables of a project's .env file",
+	Long: `The "set" command sets one or more variables of a project's .env
+file. Variables that are already set are changed in place, new ones
+are added at the end of the file. Values are quoted as needed.
+
+  mole env set my-project APP_ENV=production "APP_NAME=My App"`,
+	Args: cobra.MinimumNArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		vars := []actions.EnvVar{}
+		for _, arg := range args[1:] {
+			key, value, ok := strings.Cut(arg, "=")
+			if !ok {
+				return usageError{err: fmt.Errorf("%s is not in KEY=value form", arg), cmd: cmd}
+			}
+			vars = append(vars, actions.EnvVar{Key: key, Value: value})
+		}
+
+		changes, err := actions.SetEnv(args[0], vars)
+		if err != nil {
+			return err
+		}
+
+		return printEnvChanges(changes)
+	},
+}
+
+var unsetEnvCmd = &cobra.Command{
+	Use:   "unset [name/id] [key]...",
+	Short: "Remove variables from a project's .env file",
+	Long: `The "unset" command removes one or more variables from a project's
+.env file. Nothing is removed when one of them is not set.`,
+	Args: cobra.MinimumNArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		changes, err := actions.UnsetEnv(args[0], args[1:])
+		if err != nil {
+			return err
+		}
+
+		return printEnvChanges(changes)
+	},
+}
+
+var importEnvCmd = &cobra.Command{
+	Use:   "import [name/id] [file]",
+	Short: "Set the variables of a dotenv file in a project's .env file",
+	Long: `The "import" command sets every variable of the given dotenv file
+in a project's .env file. Variables missing from the imported file
+are kept. Without a file, or with "-", the variables are read from
+stdin:
+
+  mole env export staging | mole env import production`,
+	Args: cobra.RangeArgs(1, 2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var r io.Reader = os.Stdin
+		if len(args) == 2 && args[1] != "-" {
+			f, err := os.Open(args[1])
+			if err != nil {
+				return fmt.Errorf("failed to open %s: %w", args[1], err)
+			}
+			defer f.Close()
+			r = f
+		}
+
+		changes, err := actions.ImportEnv(args[0], r)
+		if err != nil {
+			return err
+		}
+
+		return printEnvChanges(changes)
+	},
+}
+
+var exportEnvCmd = &cobra.Command{
+	Use:   "export [name/id]",
+	Short: "Print the variables of a project's .env file as a dotenv file",
+	Long: `The "export" command prints the variables of a project's .env file
+as a dotenv file without comments, ready for "env import".`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if structuredOutput() {
+			vars, err := actions.ListEnv(args[0])
+			if err != nil {
+				return err
+			}
+			return printResult(vars, nil)
+		}
+
+		exported, err := actions.ExportEnv(args[0])
+		if err != nil {
+			return err
+		}
+
+		fmt.Print(exported)
+		return nil
+	},
+}
+
+var envHistoryCmd = &cobra.Command{
+	Use:   "history [name/id]",
+	Short: "List the changes made to a project's .env file",
+	Long: `The "history" command lists the changes made to a project's .env
+file through mole, newest first, with who made them and which
+variables were added (+), changed (~) or removed (-).`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		revisions, err := actions.ListEnvHistory(args[0])
+		if err != nil {
+			return err
+		}
+
+		return printResult(revisions, func() string {
+			if len(revisions) == 0 {
+				return "No changes recorded"
+			}
+
+			var b strings.Builder
+			for _, r := range revisions {
+				b.WriteString(r.Stringify())
+			}
+			return b.String()
+		})
+	},
+}
+
+var syncEnvCmd = &cobra.Command{
+	Use:   "sync [name/id]",
+	Short: "Add new variables of the .env template to a project's .env file",
+	Long: `The "sync" command renders the .env template of a project, mole.env
+or .env.example, with the project secrets and adds the variables
+missing from its .env file. Variables already set are left alone,
+so edits made on the server are kept.
+
+Deployments sync the .env file automatically.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		changes, err := actions.SyncEnv(args[0])
+		if err != nil {
+			return err
+		}
+
+		return printEnvChanges(changes)
+	},
+}
+
+// printEnvChanges prints the changes an env command made.
+func printEnvChanges(changes []actions.EnvChange) error {
+	return printResult(changes, func() string {
+		if len(changes) == 0 {
+			return "Nothing changed"
+		}
+
+		lines := make([]string, len(changes))
+		for i, c := range changes {
+			lines[i] = c.Key + " " + c.Action
+		}
+		return strings.Join(lines, "\n")
+	})
+}
@@ -1,5 +1,7 @@
 package cmd
 
+import "time"
+
 var (
 	domainFlag      string
 	portFlag        int
@@ -10,7 +12,48 @@ var (
 	confirmFlag     bool
 	hardRerloadFlag bool
 	deployDown      bool
+	deployRef       string
+	deployNoPull    bool
 	keyName         string
+	listenFlag      string
+	forceFlag       bool
+	followFlag      bool
+	timeoutFlag     string
+	disableFlag     bool
+	outputFlag      string
+)
+
+// flags for secret rotation
+var (
+	graceFlag        time.Duration
+	rotateDeployFlag bool
+)
+
+// flags for template rendering
+var renderDryRunFlag bool
+
+// flags for domains
+var (
+	reloadDryRunFlag   bool
+	domainAliasFlag    []string
+	domainRedirectFlag []string
+	domainPrimaryFlag  bool
+	domainWWWFlag      string
+	redirectStatusFlag int
+	redirectTargetFlag string
+	routePortFlag      string
+)
+
+// flags for compose commands
+var composeTailFlag string
+
+// flags for health checks
+var (
+	healthPathFlag     string
+	healthStatusFlag   int
+	healthRetriesFlag  int
+	healthIntervalFlag string
+	healthRollbackFlag bool
 )
 
 // flags for service actions
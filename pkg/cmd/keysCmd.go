@@ -21,6 +21,11 @@ func init() {
 	addAuthorizedKeyCmd.MarkFlagRequired("name")
 }
 
+// deployKeyOutput is the structured output of "keys deploy".
+type deployKeyOutput struct {
+	PublicKey string `json:"publicKey"`
+}
+
 var keysRootCmd = &cobra.Command{
 	Use:   "keys",
 	Short: "Manage SSH keys for secure server access",
@@ -50,8 +55,7 @@ secure, automated interactions with external repositories.`,
 			return err
 		}
 
-		fmt.Println(key)
-		return nil
+		return printResult(deployKeyOutput{PublicKey: key}, func() string { return key })
 	},
 }
 
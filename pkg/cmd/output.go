@@ -0,0 +1,202 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/zulubit/mole/pkg/actions"
+	"gopkg.in/yaml.v3"
+)
+
+// Output formats selected with --output.
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+	outputYAML  = "yaml"
+)
+
+// Exit codes, documented in docs/output.md.
+const (
+	exitError               = 1
+	exitUsage               = 2
+	exitNotFound            = 3
+	exitDeploymentFailed    = 4
+	exitDeploymentCancelled = 5
+	exitDeploymentTimedOut  = 6
+)
+
+// usageError marks errors caused by invalid flags or arguments of cmd.
+type usageError struct {
+	err error
+	cmd *cobra.Command
+}
+
+func (e usageError) Error() string {
+	return e.err.Error()
+}
+
+func (e usageError) Unwrap() error {
+	return e.err
+}
+
+// silentError carries the exit code of an error that was already reported as part of the printed result.
+type silentError struct {
+	err error
+}
+
+func (e silentError) Error() string {
+	return e.err.Error()
+}
+
+func (e silentError) Unwrap() error {
+	return e.err
+}
+
+// errorOutput is printed instead of the result when a command fails with structured output.
+type errorOutput struct {
+	Error    string `json:"error"`
+	ExitCode int    `json:"exitCode"`
+}
+
+func init() {
+	RootCmd.PersistentFlags().StringVarP(&outputFlag, "output", "o", outputTable, "Output format: table, json or yaml")
+
+	RootCmd.PersistentPreRunE = func(cmd *cobra.Command, args []string) error {
+		if outputFlag != outputTable && outputFlag != outputJSON && outputFlag != outputYAML {
+			return usageError{err: fmt.Errorf("unknown output format %s, use table, json or yaml", outputFlag), cmd: cmd}
+		}
+		return nil
+	}
+
+	RootCmd.SetFlagErrorFunc(func(cmd *cobra.Command, err error) error {
+		return usageError{err: err, cmd: cmd}
+	})
+
+	// runs once all commands are registered, before their arguments are validated
+	cobra.OnInitialize(func() { usageArgs(RootCmd) })
+}
+
+// usageArgs marks the errors of the argument validation of c and its subcommands as usage errors.
+func usageArgs(c *cobra.Command) {
+	if validate := c.Args; validate != nil {
+		c.Args = func(cmd *cobra.Command, args []string) error {
+			if err := validate(cmd, args); err != nil {
+				return usageError{err: err, cmd: cmd}
+			}
+			return nil
+		}
+	}
+
+	for _, sub := range c.Commands() {
+		usageArgs(sub)
+	}
+}
+
+// structuredOutput reports whether the result of a command is printed as JSON or YAML.
+func structuredOutput() bool {
+	return outputFlag == outputJSON || outputFlag == outputYAML
+}
+
+// printResult writes v in the format selected with --output.
+// The table format prints the human readable representation returned by table instead.
+func printResult(v any, table func() string) error {
+	switch outputFlag {
+	case outputJSON:
+		b, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal output: %w", err)
+		}
+		fmt.Println(string(b))
+	case outputYAML:
+		b, err := marshalYAML(v)
+		if err != nil {
+			return fmt.Errorf("failed to marshal output: %w", err)
+		}
+		fmt.Print(string(b))
+	default:
+		fmt.Println(table())
+	}
+
+	return nil
+}
+
+// marshalYAML converts v to YAML through its JSON representation,
+// so both formats share field names and field order.
+func marshalYAML(v any) ([]byte, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var node yaml.Node
+	if err := yaml.Unmarshal(b, &node); err != nil {
+		return nil, err
+	}
+	resetYAMLStyle(&node)
+
+	return yaml.Marshal(&node)
+}
+
+// resetYAMLStyle drops the flow style and quoting carried over from JSON.
+func resetYAMLStyle(node *yaml.Node) {
+	node.Style = 0
+	for _, n := range node.Content {
+		resetYAMLStyle(n)
+	}
+}
+
+// ExitCode maps the error a command failed with to the exit code of mole.
+func ExitCode(err error) int {
+	var usageErr usageError
+
+	switch {
+	case errors.As(err, &usageErr):
+		return exitUsage
+	case errors.Is(err, actions.ErrNotFound):
+		return exitNotFound
+	case errors.Is(err, actions.ErrDeploymentCancelled):
+		return exitDeploymentCancelled
+	case errors.Is(err, actions.ErrDeploymentTimedOut):
+		return exitDeploymentTimedOut
+	case errors.Is(err, actions.ErrDeploymentFailed):
+		return exitDeploymentFailed
+	default:
+		return exitError
+	}
+}
+
+// HandleError prints the error a command failed with and returns the exit code mole should exit with.
+// With the table format the error is printed to stderr, followed by the usage of the command for usage errors.
+func HandleError(err error) int {
+	code := ExitCode(err)
+
+	var silent silentError
+	if errors.As(err, &silent) {
+		return code
+	}
+
+	if structuredOutput() {
+		printResult(errorOutput{Error: err.Error(), ExitCode: code}, nil)
+	} else {
+		fmt.Fprintln(os.Stderr, err)
+
+		var usageErr usageError
+		if errors.As(err, &usageErr) && usageErr.cmd != nil {
+			fmt.Fprint(os.Stderr, "\n"+usageErr.cmd.UsageString())
+		}
+	}
+
+	return code
+}
+
+// progressOutput returns where progress, such as deployment output, is written.
+// With structured output stdout is reserved for the result.
+func progressOutput() *os.File {
+	if structuredOutput() {
+		return os.Stderr
+	}
+	return os.Stdout
+}
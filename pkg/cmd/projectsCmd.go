@@ -25,8 +25,20 @@ func init() {
 
 	editProjectCmd.Flags().StringVarP(&descriptionFlag, "description", "d", "", "Change description")
 	editProjectCmd.Flags().StringVarP(&branchFlag, "branch", "b", "", "Change branch")
+	editProjectCmd.Flags().StringVarP(&timeoutFlag, "timeout", "t", "", "Change deploy timeout, e.g. 15m (0 removes it)")
 	projectsRootCmd.AddCommand(editProjectCmd)
 
+	healthCheckCmd.Flags().StringVarP(&healthPathFlag, "path", "p", "/", "Path requested on the app port")
+	healthCheckCmd.Flags().IntVarP(&healthStatusFlag, "status", "s", 200, "Expected HTTP status")
+	healthCheckCmd.Flags().IntVarP(&healthRetriesFlag, "retries", "r", 10, "Number of requests before the check fails")
+	healthCheckCmd.Flags().StringVarP(&healthIntervalFlag, "interval", "i", "3s", "Time between requests")
+	healthCheckCmd.Flags().BoolVar(&healthRollbackFlag, "rollback", false, "Re-deploy the previous successful commit when the check fails")
+	healthCheckCmd.Flags().BoolVar(&disableFlag, "disable", false, "Remove the health check")
+	projectsRootCmd.AddCommand(healthCheckCmd)
+
+	projectsRootCmd.AddCommand(strategyCmd)
+	projectsRootCmd.AddCommand(validateProjectCmd)
+
 	deleteProjectCmd.Flags().BoolVarP(&confirmFlag, "confirm", "y", false, "Confirms intent of deletion *required")
 	deleteProjectCmd.MarkFlagRequired("confirm")
 	projectsRootCmd.AddCommand(deleteProjectCmd)
@@ -45,8 +57,13 @@ var listProjectsCmd = &cobra.Command{
 	Short: "List all projects",
 	Long: `Lists all projects in the system. 
 This provides an overview of available projects for further actions.`,
-	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Println(actions.ListProjects())
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ps, err := actions.AllProjects()
+		if err != nil {
+			return err
+		}
+
+		return printResult(ps, func() string { return actions.Projects{Projects: ps}.Stringify() })
 	},
 }
 
@@ -63,8 +80,7 @@ to assist with further management commands.`,
 			return err
 		}
 
-		fmt.Println(p.Stringify())
-		return nil
+		return printResult(p, p.Stringify)
 	},
 }
 
@@ -121,10 +137,11 @@ var editProjectCmd = &cobra.Command{
 	Use:   "edit [name/id]",
 	Short: "Edit a project by name or ID",
 	Long: `Edits properties of a project identified by its name or ID. 
-You can change the description or branch, but not the repository, ID, or name.`,
+You can change the description, branch or deploy timeout,
+but not the repository, ID, or name.`,
 	Args: cobra.ExactArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
-		err := actions.EditProject(strings.Join(args, ""), descriptionFlag, branchFlag)
+		err := actions.EditProject(strings.Join(args, ""), descriptionFlag, branchFlag, timeoutFlag)
 		if err != nil {
 			return err
 		}
@@ -133,3 +150,94 @@ You can change the description or branch, but not the repository, ID, or name.`,
 		return nil
 	},
 }
+
+var healthCheckCmd = &cobra.Command{
+	Use:   "healthcheck [name/id]",
+	Short: "Configure the health check of a project",
+	Long: `Configures the HTTP health check run after the deployment script of a project finished.
+Mole requests the path on the app port of the project until it answers with the expected status.
+If it does not within the given number of retries, the deployment fails.
+With --rollback, the previous successful commit is deployed again.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if disableFlag {
+			if err := actions.SetHealthCheck(args[0], nil); err != nil {
+				return err
+			}
+
+			fmt.Println("Health check of " + args[0] + " was removed")
+			return nil
+		}
+
+		hc, err := actions.NewHealthCheck(healthPathFlag, healthStatusFlag, healthRetriesFlag, healthIntervalFlag, healthRollbackFlag)
+		if err != nil {
+			return err
+		}
+
+		if err := actions.SetHealthCheck(args[0], hc); err != nil {
+			return err
+		}
+
+		fmt.Println("Health check of " + args[0] + " was updated: " + hc.Stringify())
+		return nil
+	},
+}
+
+var strategyCmd = &cobra.Command{
+	Use:   "strategy [name/id] [inplace|bluegreen]",
+	Short: "Set the deploy strategy of a project",
+	Long: `Sets how a project is deployed.
+inplace restarts the project in place on every deployment, this is the default.
+bluegreen starts each release next to the running one, on the alternate port,
+health-checks it and switches the domain of the project over before stopping the old release.`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		err := actions.SetDeployStrategy(args[0], args[1])
+		if err != nil {
+			return err
+		}
+
+		fmt.Println("Project " + args[0] + " now deploys " + args[1])
+		return nil
+	},
+}
+
+var validateProjectCmd = &cobra.Command{
+	Use:   "validate [name/id]",
+	Short: "Lint the mole.yaml of a project",
+	Long: `Checks the mole.yaml of a project and the files it refers to without deploying it.
+Every template of the project is rendered, nothing is written.
+Problems fail the deployment, warnings point out likely mistakes.
+Exits with 1 when the project has problems.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		v, err := actions.ValidateProject(args[0])
+		if err != nil {
+			return err
+		}
+
+		err = printResult(v, func() string {
+			var b strings.Builder
+			for _, p := range v.Problems {
+				b.WriteString("error: " + p + "\n")
+			}
+			for _, w := range v.Warnings {
+				b.WriteString("warning: " + w + "\n")
+			}
+			if v.Valid {
+				b.WriteString(v.ProjectName + " is valid")
+			} else {
+				fmt.Fprintf(&b, "%s has %d problem(s)", v.ProjectName, len(v.Problems))
+			}
+			return b.String()
+		})
+		if err != nil {
+			return err
+		}
+
+		if !v.Valid {
+			return silentError{fmt.Errorf("%s has %d problem(s)", v.ProjectName, len(v.Problems))}
+		}
+		return nil
+	},
+}
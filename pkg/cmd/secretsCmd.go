@@ -0,0 +1,217 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/zulubit/mole/pkg/actions"
+)
+
+func init() {
+	RootCmd.AddCommand(secretsRootCmd)
+
+	secretsRootCmd.AddCommand(rotateMasterKeyCmd)
+	secretsRootCmd.AddCommand(setSecretCmd)
+	secretsRootCmd.AddCommand(getSecretCmd)
+	secretsRootCmd.AddCommand(unsetSecretCmd)
+	secretsRootCmd.AddCommand(listSecretsCmd)
+
+	secretsRootCmd.AddCommand(rotateSecretCmd)
+	rotateSecretCmd.Flags().DurationVarP(&graceFlag, "grace", "g", actions.DefaultRotationGrace, "How long the previous value stays available as {{.Previous.<secret>}}, 0 drops it right away")
+	rotateSecretCmd.Flags().BoolVarP(&rotateDeployFlag, "deploy", "d", false, "Deploy the project after rotating the secret")
+}
+
+// rotateSecretOutput is the structured output of "secrets rotate".
+type rotateSecretOutput struct {
+	actions.RotatedSecret
+	Deploy *actions.DeployResult `json:"deploy,omitempty"`
+}
+
+// secretOutput is the structured output of "secrets get".
+type secretOutput struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// rotateMasterKeyOutput is the structured output of "secrets rotate-master-key".
+type rotateMasterKeyOutput struct {
+	Reencrypted int `json:"reencrypted"`
+}
+
+var secretsRootCmd = &cobra.Command{
+	Use:   "secrets",
+	Short: "Manage project secrets",
+	Long: `The "secrets" command group manages the project secrets injected 
+into deployment templates.
+
+Besides the secrets mole generates for every project, custom secrets 
+such as SMTP passwords or API tokens can be added with "secrets set". 
+Templates use them as {{.Custom.KEY}} or {{secret "KEY"}}.
+
+Secrets are encrypted at rest with the master key stored at 
+/home/mole/.mole/master.key, which is created the first time 
+secrets are written. Keep a copy of it, secrets can not be 
+recovered without it.`,
+}
+
+var rotateMasterKeyCmd = &cobra.Command{
+	Use:   "rotate-master-key",
+	Short: "Replace the master key and re-encrypt all project secrets",
+	Long: `The "rotate-master-key" command generates a new master key and 
+re-encrypts the secrets of every project with it. The old key can 
+not be used to read them afterwards.
+
+Secrets written by older versions of mole, which were stored in 
+plaintext, are encrypted as well.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		n, err := actions.RotateMasterKey()
+		if err != nil {
+			return err
+		}
+
+		return printResult(rotateMasterKeyOutput{Reencrypted: n}, func() string {
+			return fmt.Sprintf("Master key rotated, re-encrypted the secrets of %d project(s)", n)
+		})
+	},
+}
+
+var setSecretCmd = &cobra.Command{
+	Use:   "set [name/id] [key] [value]",
+	Short: "Set a custom secret of a project",
+	Long: `The "set" command stores a custom secret of a project, replacing 
+its value if it is already set.
+
+Keys can only contain letters, digits and underscores and can not 
+start with a digit. When the value is left out, it is read from 
+stdin, which keeps it out of the shell history:
+
+  mole secrets set my-project SMTP_PASSWORD < password.txt
+
+Redeploy the project to render the new value into its templates.`,
+	Args: cobra.RangeArgs(2, 3),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var value string
+		if len(args) == 3 {
+			value = args[2]
+		} else {
+			b, err := io.ReadAll(os.Stdin)
+			if err != nil {
+				return fmt.Errorf("failed to read secret from stdin: %w", err)
+			}
+			value = strings.TrimRight(string(b), "\r\n")
+		}
+
+		if err := actions.SetProjectSecret(args[0], args[1], value); err != nil {
+			return err
+		}
+
+		fmt.Println("Secret " + args[1] + " set")
+		return nil
+	},
+}
+
+var getSecretCmd = &cobra.Command{
+	Use:   "get [name/id] [key]",
+	Short: "Print the value of a custom secret of a project",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		value, err := actions.GetProjectSecret(args[0], args[1])
+		if err != nil {
+			return err
+		}
+
+		return printResult(secretOutput{Key: args[1], Value: value}, func() string { return value })
+	},
+}
+
+var unsetSecretCmd = &cobra.Command{
+	Use:   "unset [name/id] [key]",
+	Short: "Remove a custom secret of a project",
+	Long: `The "unset" command removes a custom secret of a project. 
+
+Templates still referencing it will fail to render on the next deploy.`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := actions.UnsetProjectSecret(args[0], args[1]); err != nil {
+			return err
+		}
+
+		fmt.Println("Secret " + args[1] + " removed")
+		return nil
+	},
+}
+
+var listSecretsCmd = &cobra.Command{
+	Use:   "list [name/id]",
+	Short: "List the keys of the custom secrets of a project",
+	Long: `The "list" command prints the keys of the custom secrets of a 
+project. Values are not printed, use "secrets get" to read one.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		keys, err := actions.ListProjectSecrets(args[0])
+		if err != nil {
+			return err
+		}
+
+		return printResult(keys, func() string {
+			if len(keys) == 0 {
+				return "No custom secrets set"
+			}
+			return strings.Join(keys, "\n")
+		})
+	},
+}
+
+var rotateSecretCmd = &cobra.Command{
+	Use:   "rotate [name/id] [AppKey|DatabasePass|WebhookSecret]",
+	Short: "Generate a new value for a secret of a project",
+	Long: `The "rotate" command generates a new value for one of the secrets 
+mole generated for a project and renders the project's templates 
+with it. The variables of the .env whose line in the .env template 
+uses the secret are set to the new value.
+
+The previous value stays available to templates as 
+{{.Previous.<secret>}} for the grace period, 24 hours by default. 
+This lets mole.sh move the database over to a new password:
+
+  {{if index .Previous "DatabasePass"}}
+  mysql -u root -p"{{.Previous.DatabasePass}}" -e "ALTER USER ..."
+  {{end}}
+
+During the grace period webhooks signed with the previous 
+WebhookSecret are still accepted.
+
+With --deploy, the project is deployed right after the rotation.`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		rotated, err := actions.RotateProjectSecret(args[0], args[1], graceFlag)
+		if err != nil {
+			return err
+		}
+
+		out := rotateSecretOutput{RotatedSecret: rotated}
+		if !rotateDeployFlag {
+			return printResult(out, rotated.Summary)
+		}
+
+		res, err := actions.RunDeployment(args[0], actions.DeployOptions{TriggeredBy: "secrets rotate", Output: progressOutput()})
+		if res.Deployment == nil && !res.Queued {
+			return fmt.Errorf("rotated %s, but the deployment failed: %w", args[1], err)
+		}
+
+		out.Deploy = &res
+		if perr := printResult(out, func() string { return rotated.Summary() + "\n" + res.Summary() }); perr != nil {
+			return perr
+		}
+
+		if err != nil && structuredOutput() {
+			return silentError{err}
+		}
+
+		return err
+	},
+}
@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/zulubit/mole/pkg/actions"
+)
+
+func init() {
+	RootCmd.AddCommand(serveCmd)
+	serveCmd.Flags().StringVarP(&listenFlag, "listen", "l", "127.0.0.1:7890", "Address to listen on, must be a loopback address")
+
+	serveCmd.AddCommand(webhookSecretCmd)
+}
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Receive push webhooks and deploy projects",
+	Long: `Serve runs a small HTTP listener receiving GitHub, GitLab and Gitea push webhooks 
+on /hooks/#project name or id#. 
+
+Webhooks are verified using the project's webhook secret. When the pushed branch 
+matches the project branch, the project is deployed.
+
+The listener is bound to localhost, expose it to your git provider through Caddy.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return actions.ServeWebhooks(listenFlag)
+	},
+}
+
+var webhookSecretCmd = &cobra.Command{
+	Use:   "secret [project name/id]",
+	Short: "Retrieve or create the webhook secret of a project",
+	Long: `The "secret" command displays the secret used to verify push webhooks 
+of a project. Configure it as the webhook secret (GitHub, Gitea) or 
+secret token (GitLab) at your git provider.
+
+If the project has no webhook secret yet, one is generated.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		secret, err := actions.FindOrCreateWebhookSecret(args[0])
+		if err != nil {
+			return err
+		}
+
+		fmt.Println(secret)
+		return nil
+	},
+}
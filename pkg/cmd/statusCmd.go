@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/zulubit/mole/pkg/actions"
+)
+
+func init() {
+	RootCmd.AddCommand(statusCmd)
+}
+
+var statusCmd = &cobra.Command{
+	Use:   "status [project name/id]",
+	Short: "Show whether a project is running",
+	Long: `Shows whether a project is actually running: the state, CPU and memory
+usage of the containers of its compose stack, whether its reserved ports
+are listening, its domains and the result of its last deployment.
+Without a project, the status of every project is shown.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		statuses, err := actions.ProjectsStatus(strings.Join(args, ""))
+		if err != nil {
+			return err
+		}
+
+		return printResult(statuses, func() string {
+			if len(statuses) == 0 {
+				return "No projects"
+			}
+
+			var b strings.Builder
+			for _, st := range statuses {
+				b.WriteString(st.Stringify() + "\n")
+			}
+			return strings.TrimSuffix(b.String(), "\n")
+		})
+	},
+}
@@ -13,6 +13,9 @@ func init() {
 
 	transformProjectCmd.AddCommand(transformProjectShCmd)
 	transformProjectCmd.AddCommand(transformProjectComposeCmd)
+	transformProjectCmd.AddCommand(renderTemplatesCmd)
+
+	renderTemplatesCmd.Flags().BoolVar(&renderDryRunFlag, "dry-run", false, "Print the rendered templates with secrets masked instead of writing them")
 }
 
 var transformProjectCmd = &cobra.Command{
@@ -20,7 +23,10 @@ var transformProjectCmd = &cobra.Command{
 	Short: "Transform project mole templates",
 	Long: `Generates project-specific configuration files by transforming 
 "mole-compose.yaml" and "mole.sh" templates into ready-to-use files.
-Variables from the project's secrets are injected during the transformation.`,
+Variables from the project's secrets are injected during the transformation.
+
+Further files, such as nginx.conf.tmpl or config/prod.yaml.tmpl, are
+rendered when they are declared in the project's mole.yaml.`,
 }
 
 var transformProjectComposeCmd = &cobra.Command{
@@ -60,3 +66,48 @@ with project-specific variables derived from the project's secrets.`,
 		return nil
 	},
 }
+
+var renderTemplatesCmd = &cobra.Command{
+	Use:   "render [project name/id] [file]",
+	Short: "Renders the templates of a project to their targets",
+	Long: `Renders every template of a project: mole.sh, its hooks,
+mole-compose.yaml and the templates declared in mole.yaml. With a file,
+given as the template or its target, only that template is rendered.
+
+With --dry-run nothing is written, the rendered templates are printed
+with the project secrets masked instead:
+
+  mole templates render my-project config/prod.yaml --dry-run`,
+	Args: cobra.RangeArgs(1, 2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		file := ""
+		if len(args) == 2 {
+			file = args[1]
+		}
+
+		rendered, err := actions.RenderTemplates(args[0], file, renderDryRunFlag)
+		if err != nil {
+			return err
+		}
+
+		return printResult(rendered, func() string {
+			if len(rendered) == 0 {
+				return "No templates to render"
+			}
+
+			var b strings.Builder
+			for _, r := range rendered {
+				if !renderDryRunFlag {
+					fmt.Fprintf(&b, "Rendered %s to %s\n", r.Source, r.Target)
+					continue
+				}
+
+				fmt.Fprintf(&b, "# %s -> %s\n%s", r.Source, r.Target, r.Content)
+				if !strings.HasSuffix(r.Content, "\n") {
+					b.WriteString("\n")
+				}
+			}
+			return strings.TrimSuffix(b.String(), "\n")
+		})
+	},
+}
@@ -0,0 +1,35 @@
+package helpers
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	tmp := t.TempDir()
+	name := path.Join(tmp, "mole.json")
+
+	err := WriteFileAtomic(name, []byte("first"), 0600)
+	assert.Nil(t, err, "new file is written")
+
+	err = WriteFileAtomic(name, []byte("second"), 0644)
+	assert.Nil(t, err, "existing file is replaced")
+
+	content, err := os.ReadFile(name)
+	assert.Nil(t, err, "file can be read")
+	assert.Equal(t, "second", string(content), "file contains the latest write")
+
+	info, err := os.Stat(name)
+	assert.Nil(t, err, "file exists")
+	assert.Equal(t, os.FileMode(0644), info.Mode().Perm(), "permissions are applied")
+
+	entries, err := os.ReadDir(tmp)
+	assert.Nil(t, err, "directory can be read")
+	assert.Len(t, entries, 1, "no temporary files are left behind")
+
+	err = WriteFileAtomic(path.Join(tmp, "missing", "mole.json"), []byte("x"), 0644)
+	assert.Error(t, err, "writing into a missing directory fails")
+}
@@ -0,0 +1,48 @@
+package helpers
+
+import (
+	"bytes"
+	"io"
+	"time"
+)
+
+// TimestampWriter prefixes every line written through it with the current time.
+type TimestampWriter struct {
+	w       io.Writer
+	now     func() time.Time
+	midLine bool
+}
+
+// NewTimestampWriter returns a TimestampWriter writing to w.
+func NewTimestampWriter(w io.Writer) *TimestampWriter {
+	return &TimestampWriter{w: w, now: time.Now}
+}
+
+// Write passes p on as soon as it arrives, so partial lines such as progress output are not held back.
+func (t *TimestampWriter) Write(p []byte) (int, error) {
+	var b bytes.Buffer
+	n := len(p)
+
+	for len(p) > 0 {
+		if !t.midLine {
+			b.WriteString(t.now().Format("[15:04:05] "))
+			t.midLine = true
+		}
+
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			b.Write(p)
+			break
+		}
+
+		b.Write(p[:i+1])
+		p = p[i+1:]
+		t.midLine = false
+	}
+
+	if _, err := t.w.Write(b.Bytes()); err != nil {
+		return 0, err
+	}
+
+	return n, nil
+}
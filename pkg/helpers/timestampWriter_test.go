@@ -0,0 +1,25 @@
+package helpers
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimestampWriter(t *testing.T) {
+	var out bytes.Buffer
+
+	w := NewTimestampWriter(&out)
+	w.now = func() time.Time { return time.Date(2024, 1, 1, 12, 30, 45, 0, time.UTC) }
+
+	n, err := w.Write([]byte("first line\nsecond "))
+	assert.Nil(t, err)
+	assert.Equal(t, 18, n, "all bytes are reported as written")
+
+	w.Write([]byte("part\n"))
+	w.Write([]byte("\nlast"))
+
+	assert.Equal(t, "[12:30:45] first line\n[12:30:45] second part\n[12:30:45] \n[12:30:45] last", out.String(), "every line is prefixed once")
+}
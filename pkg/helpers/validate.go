@@ -7,6 +7,7 @@ import (
 const emailRegex = `^[a-zA-Z0-9._%+-]+@[a-zA-Z0-9]+(-[a-zA-Z0-9]+)*(\.[a-zA-Z0-9]+(-[a-zA-Z0-9]+)*)*\.[a-zA-Z]{2,}$`
 const domainRegex = `^(\*\.)?([a-zA-Z0-9]+(-[a-zA-Z0-9]+)*\.)+[a-zA-Z\p{L}]{2,}(:\d+)?$`
 const nameRegex = `^[a-z0-9]+(?:[-_][a-z0-9]+)*$`
+const envKeyRegex = `^[A-Za-z_][A-Za-z0-9_]*$`
 
 func ValidateEmail(email string) bool {
 	re := regexp.MustCompile(emailRegex)
@@ -25,3 +26,10 @@ func ValidateProjectName(name string) bool {
 	}
 	return true
 }
+
+// ValidateEnvKey reports whether key is a valid environment variable name.
+// Custom secrets use the same rule, so their keys also work as template fields.
+func ValidateEnvKey(key string) bool {
+	re := regexp.MustCompile(envKeyRegex)
+	return re.MatchString(key)
+}
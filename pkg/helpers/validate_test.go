@@ -80,3 +80,26 @@ func TestValidateProjectName(t *testing.T) {
 		})
 	}
 }
+
+func TestValidateEnvKey(t *testing.T) {
+	tests := []struct {
+		key      string
+		expected bool
+	}{
+		{"SMTP_PASSWORD", true},
+		{"s3_key", true},
+		{"_TOKEN", true},
+		{"API2", true},
+		{"2API", false},
+		{"API-KEY", false},
+		{"API KEY", false},
+		{"API.KEY", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			assert.Equal(t, tt.expected, ValidateEnvKey(tt.key))
+		})
+	}
+}